@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/auth"
+	model "github.com/Proluxe/proluxe-common-api/model"
+	"github.com/Proluxe/proluxe-common-api/util"
+	"github.com/gin-gonic/gin"
+)
+
+// logActivity records file operations done by the caller. Of the reads,
+// only archives and signed link downloads are logged; plain downloads and
+// listings aren't.
+func logActivity(c *gin.Context, App *util.App, entries ...model.FileActivity) {
+	actor := auth.CurrentPrincipal(c).Actor()
+	now := time.Now().UTC()
+	for n := range entries {
+		entries[n].At, entries[n].Actor, entries[n].RequestID = now, actor, c.GetString("RequestID")
+	}
+	model.RecordActivity(App.Activity, entries)
+}
+
+// logResults records one entry per object a bulk operation succeeded on,
+// with Detail set to where it went if it went anywhere.
+func logResults(c *gin.Context, App *util.App, action string, results []model.ObjectResult) {
+	var entries []model.FileActivity
+	for _, r := range results {
+		if r.Err != nil {
+			continue
+		}
+		entries = append(entries, model.FileActivity{Action: action, Path: r.Source, Detail: r.Destination})
+	}
+	logActivity(c, App, entries...)
+}
+
+// GET_FILE_ACTIVITY searches the file audit log, newest first, by path
+// ?prefix, ?actor and a ?from/?to date range. A date-only ?to includes the
+// whole day.
+func GET_FILE_ACTIVITY(c *gin.Context, App *util.App) {
+	if App.Activity == nil {
+		apperror.Respond(c, apperror.Unavailable(nil, "The file activity log is turned off"))
+		return
+	}
+
+	q := model.ActivityQuery{Prefix: c.Query("prefix"), Actor: c.Query("actor"), Limit: model.DefaultActivityLimit}
+
+	var err error
+	if q.From, err = parseTimeParam(c, "from"); err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	if q.To, err = parseTimeParam(c, "to"); err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	if _, err := time.Parse(time.DateOnly, c.Query("to")); err == nil {
+		q.To = q.To.AddDate(0, 0, 1)
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		apperror.Respond(c, apperror.Validation("from must be before to"))
+		return
+	}
+
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 || q.Limit > model.MaxActivityLimit {
+			apperror.Respond(c, apperror.Validation("limit must be between 1 and %d", model.MaxActivityLimit))
+			return
+		}
+	}
+
+	entries, err := App.Activity.Find(c.Request.Context(), q)
+	if err != nil {
+		apperror.Respond(c, apperror.Unavailable(err, "Failed to read the file activity log"))
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
@@ -1,31 +1,65 @@
 package api
 
 import (
+	"github.com/Proluxe/proluxe-common-api/apperror"
 	model "github.com/Proluxe/proluxe-common-api/model"
 	"github.com/Proluxe/proluxe-common-api/util"
 	"github.com/gin-gonic/gin"
+	"github.com/scottraio/simpleforce"
 )
 
 func GET_ALGOLIA_PRODUCTS(c *gin.Context, App *util.App) {
-	products := model.FetchAlgoliaProducts(App.SF.Client)
+	var products []model.AlgoliaIndex
+	err := App.SF.Read(func(client *simpleforce.Client) (err error) {
+		products, err = model.FetchAlgoliaProducts(client)
+		return err
+	})
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
 	c.JSON(200, products)
 }
 
 func GET_ALGOLIA_CUSTOMERS(c *gin.Context, App *util.App) {
-	products := model.FetchAlgoliaCustomers(App.SF.Client)
+	var products []model.AlgoliaIndex
+	err := App.SF.Read(func(client *simpleforce.Client) (err error) {
+		products, err = model.FetchAlgoliaCustomers(client)
+		return err
+	})
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
 	c.JSON(200, products)
 }
 
 func GET_ALGOLIA_CONTACTS(c *gin.Context, App *util.App) {
-	products := model.FetchAlgoliaContacts(App.SF.Client)
+	var products []model.AlgoliaIndex
+	err := App.SF.Read(func(client *simpleforce.Client) (err error) {
+		products, err = model.FetchAlgoliaContacts(client)
+		return err
+	})
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
 	c.JSON(200, products)
 }
 
 func GET_ALGOLIA_PARTS(c *gin.Context, App *util.App) {
-	parts := model.FetchAlgoliaParts(App.SF.Client)
+	var parts []model.AlgoliaIndex
+	err := App.SF.Read(func(client *simpleforce.Client) (err error) {
+		parts, err = model.FetchAlgoliaParts(client)
+		return err
+	})
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
 	c.JSON(200, parts)
 }
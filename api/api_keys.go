@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/auth"
+	model "github.com/Proluxe/proluxe-common-api/model"
+	"github.com/Proluxe/proluxe-common-api/util"
+	"github.com/gin-gonic/gin"
+)
+
+var scopePattern = regexp.MustCompile(`^[a-z_]+:(read|write)$`)
+
+func GET_API_KEYS(c *gin.Context, App *util.App) {
+	keys, err := App.APIKeys.All()
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// POST_CREATE_API_KEY mints a key. The plaintext is only ever returned here.
+func POST_CREATE_API_KEY(c *gin.Context, App *util.App) {
+	var payload struct {
+		Name      string    `json:"Name"`
+		Scopes    []string  `json:"Scopes"`
+		ExpiresAt time.Time `json:"ExpiresAt"`
+	}
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Respond(c, apperror.Validation("Invalid JSON"))
+		return
+	}
+
+	if payload.Name == "" {
+		apperror.Respond(c, apperror.Validation("Name is required"))
+		return
+	}
+	if len(payload.Scopes) == 0 {
+		apperror.Respond(c, apperror.Validation("At least one scope is required"))
+		return
+	}
+	for _, scope := range payload.Scopes {
+		if !scopePattern.MatchString(scope) {
+			apperror.Respond(c, apperror.Validation("Invalid scope %q", scope))
+			return
+		}
+	}
+	if !payload.ExpiresAt.IsZero() && payload.ExpiresAt.Before(time.Now()) {
+		apperror.Respond(c, apperror.Validation("ExpiresAt is in the past"))
+		return
+	}
+
+	createdBy := auth.CurrentPrincipal(c).Actor()
+	key, secret, err := model.NewAPIKey(payload.Name, payload.Scopes, payload.ExpiresAt, createdBy)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	if err := App.APIKeys.Create(&key); err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"APIKey": key,
+		"Key":    secret,
+	})
+}
+
+// DELETE_API_KEY revokes a key. Servers cache key lookups briefly, so it
+// can go on working for up to 30 seconds.
+func DELETE_API_KEY(c *gin.Context, App *util.App) {
+	id := c.Param("id")
+
+	if err := App.APIKeys.Revoke(id); err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
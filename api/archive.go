@@ -0,0 +1,69 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	model "github.com/Proluxe/proluxe-common-api/model"
+	"github.com/Proluxe/proluxe-common-api/util"
+	"github.com/gin-gonic/gin"
+)
+
+// POST_ARCHIVE streams a ZIP of Paths, built on the fly. Folders (paths
+// ending in "/") are added with everything in them under their own name.
+func POST_ARCHIVE(c *gin.Context, App *util.App) {
+	var payload struct {
+		Paths    []string `json:"Paths"`
+		Filename string   `json:"Filename"`
+	}
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Respond(c, apperror.Validation("Invalid JSON"))
+		return
+	}
+
+	if !requireFolderAccess(c, App, model.PermissionRead, payload.Paths...) {
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	entries, err := file.PlanArchive(payload.Paths, App.Archives)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	filename := archiveFilename(payload.Filename, payload.Paths)
+	logActivity(c, App, model.ArchiveActivity(model.ActivityArchive, entries, filename, "")...)
+
+	h := c.Writer.Header()
+	h.Set("Content-Type", "application/zip")
+	h.Set("Content-Disposition", model.ContentDisposition("attachment", filename))
+	h.Set("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	if err := file.WriteArchive(c.Writer, entries); err != nil {
+		// The status line is already out; all we can do is cut the body short.
+		log.Printf("Request %s: streaming archive failed: %v", c.GetString("RequestID"), err)
+	}
+}
+
+// archiveFilename names an archive: as asked, after the one folder or file
+// it holds, or "files.zip".
+func archiveFilename(filename string, paths []string) string {
+	if filename == "" {
+		filename = "files"
+		if len(paths) == 1 {
+			filename = path.Base(paths[0])
+		}
+	}
+	filename = path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if !strings.HasSuffix(strings.ToLower(filename), ".zip") {
+		filename += ".zip"
+	}
+	return filename
+}
@@ -3,6 +3,8 @@ package api
 import (
 	"net/http"
 
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/auth"
 	model "github.com/Proluxe/proluxe-common-api/model"
 	"github.com/Proluxe/proluxe-common-api/util"
 	"github.com/gin-gonic/gin"
@@ -11,10 +13,18 @@ import (
 func GET_COMMENTS(c *gin.Context, App *util.App) {
 	// Fetch the PO Lines
 	recordID := c.Param("recordID")
-	whereCondition := "Record_ID__c = '" + recordID + "' ORDER BY CreatedDate ASC"
 
-	comments := model.FetchComments(App.SF.Client, whereCondition)
-	commentMentions := model.FetchMentions(App.SF.Client, whereCondition)
+	comments, err := App.Comments.ForRecord(recordID)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	commentMentions, err := App.Mentions.ForRecord(recordID)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
 	response := gin.H{
 		"Comments":        comments,
@@ -25,22 +35,29 @@ func GET_COMMENTS(c *gin.Context, App *util.App) {
 }
 
 func POST_COMMENT(c *gin.Context, App *util.App) {
-	client := App.SF.Client
-	recordID := c.Param("recordID")
+	var comment model.Comment
+	if err := c.ShouldBindJSON(&comment); err != nil {
+		apperror.Respond(c, apperror.Validation("%s", err.Error()))
+		return
+	}
 
-	_, email, _ := GetCurrentUser(c)
-	comment := model.Comment{
-		RecordID:  recordID,
-		CreatedBy: email,
+	// The author always comes from the caller, never from the payload.
+	p := auth.CurrentPrincipal(c)
+	comment.RecordID = c.Param("recordID")
+	comment.CreatedBy = p.Actor()
+	if p.Name != "" {
+		comment.CreatedByName = p.Name
+	}
+	if p.Avatar != "" {
+		comment.Avatar = p.Avatar
 	}
 
-	if err := c.ShouldBindJSON(&comment); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+	if err := App.Comments.Create(&comment); err != nil {
+		apperror.Respond(c, err)
 		return
 	}
 
-	comment.Create(client)
-	comment.SendNotificationEmail(client)
+	comment.SendNotificationEmail(App.Comments, App.Mentions)
 
 	c.JSON(http.StatusCreated, gin.H{})
 }
@@ -48,12 +65,19 @@ func POST_COMMENT(c *gin.Context, App *util.App) {
 func DELETE_COMMENT(c *gin.Context, App *util.App) {
 	commentID := c.Param("commentID")
 
-	comment := model.Comment{
-		Id: commentID,
+	comment, err := App.Comments.Get(commentID)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	if !auth.CanModify(c, comment.CreatedBy) {
+		apperror.Respond(c, apperror.Forbidden("Only the author or an admin can delete this comment"))
+		return
 	}
 
-	if err := comment.Delete(App.SF.Client); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+	if err := App.Comments.Delete(commentID); err != nil {
+		apperror.Respond(c, err)
 		return
 	}
 
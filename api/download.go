@@ -0,0 +1,262 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	model "github.com/Proluxe/proluxe-common-api/model"
+	"github.com/Proluxe/proluxe-common-api/util"
+	"github.com/gin-gonic/gin"
+)
+
+// SERVE_FILE_FROM_BUCKET streams a file as a download, or for viewing in
+// the browser with ?inline=true. ?generation= serves a past version.
+func SERVE_FILE_FROM_BUCKET(c *gin.Context, App *util.App) {
+	objectName := strings.TrimPrefix(c.Param("path"), "/")
+	// Bookkeeping, including uploads still being scanned, is never served;
+	// archives go out only through their signed links.
+	if model.IsHidden(objectName) {
+		apperror.Respond(c, apperror.NotFound("File %s not found", objectName))
+		return
+	}
+
+	disposition := "attachment"
+	if inline, _ := strconv.ParseBool(c.Query("inline")); inline {
+		disposition = "inline"
+	}
+
+	var generation int64
+	if g := c.Query("generation"); g != "" {
+		var err error
+		if generation, err = strconv.ParseInt(g, 10, 64); err != nil {
+			apperror.Respond(c, apperror.Validation("generation must be a number"))
+			return
+		}
+	}
+
+	if !requireFolderAccess(c, App, model.PermissionRead, objectName) {
+		return
+	}
+
+	serveObject(c, App.Blobs, objectName, generation, disposition, path.Base(objectName), "private, no-cache")
+}
+
+// SERVE_SIGNED_FILE serves the signed links of stores that can't sign URLs
+// themselves, such as the local-disk store.
+func SERVE_SIGNED_FILE(c *gin.Context, App *util.App) {
+	store, ok := App.Blobs.(interface {
+		VerifySignedURL(name string, q url.Values) (string, error)
+	})
+	if !ok {
+		apperror.Respond(c, apperror.NotFound("Not found"))
+		return
+	}
+
+	objectName := strings.TrimPrefix(c.Param("path"), "/")
+	filename, err := store.VerifySignedURL(objectName, c.Request.URL.Query())
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	logActivity(c, App, linkDownloadActivity(App, objectName, c.Query("expires")))
+
+	disposition := "attachment"
+	if filename == "" {
+		disposition, filename = "inline", path.Base(objectName)
+	}
+	serveObject(c, App.Blobs, objectName, 0, disposition, filename, "private, no-cache")
+}
+
+// linkDownloadActivity describes a download through a signed link, naming
+// who the link was issued to when it can be found: the link for the object
+// that expires when the URL does.
+func linkDownloadActivity(App *util.App, objectName, expires string) model.FileActivity {
+	entry := model.FileActivity{Action: model.ActivityLinkDownload, Path: objectName}
+
+	links, err := App.FileLinks.Find(objectName, "")
+	if err != nil {
+		log.Printf("Failed to find the link for %s: %v", objectName, err)
+		return entry
+	}
+	for _, link := range links {
+		if strconv.FormatInt(link.ExpiresAt.Unix(), 10) != expires {
+			continue
+		}
+		entry.Target = link.Recipient
+		entry.Detail = "link issued by " + link.IssuedBy
+		if link.Filename != "" && link.Filename != path.Base(objectName) {
+			entry.Detail += " as " + link.Filename
+		}
+		break
+	}
+	return entry
+}
+
+// serveObject streams the object straight from the store, the live
+// generation unless another is asked for. It answers conditional requests
+// from the object's generation and update time, and serves a single byte
+// range when one is asked for.
+func serveObject(c *gin.Context, store model.BlobStore, objectName string, generation int64, disposition, filename, cacheControl string) {
+	var attrs *model.BlobAttrs
+	var err error
+	if generation != 0 {
+		attrs, err = model.FindVersion(c.Request.Context(), store, objectName, generation)
+	} else {
+		attrs, err = store.Attrs(c.Request.Context(), objectName)
+	}
+	if err != nil {
+		respondStorageError(c, err)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%d"`, attrs.Generation)
+	modified := attrs.Updated.UTC().Truncate(time.Second)
+
+	h := c.Writer.Header()
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", modified.Format(http.TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Cache-Control", cacheControl)
+
+	if notModified(c.Request, etag, modified) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	offset, length, status := int64(0), attrs.Size, http.StatusOK
+	if r := c.GetHeader("Range"); r != "" && ifRange(c.Request, etag, modified) {
+		start, end, ok := parseRange(r, attrs.Size)
+		if !ok {
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", attrs.Size))
+			c.AbortWithStatus(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if start >= 0 {
+			offset, length, status = start, end-start+1, http.StatusPartialContent
+			h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, attrs.Size))
+		}
+	}
+
+	// Read the generation the headers describe, even if it was replaced
+	// in the meantime.
+	rc, _, err := store.NewVersionReader(c.Request.Context(), objectName, attrs.Generation, offset, length)
+	if err != nil {
+		respondStorageError(c, err)
+		return
+	}
+	defer rc.Close()
+
+	contentType := attrs.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	h.Set("Content-Disposition", model.ContentDisposition(disposition, filename))
+	c.Status(status)
+
+	if _, err := io.Copy(c.Writer, rc); err != nil {
+		// The status line is already out; all we can do is cut the body short.
+		log.Printf("Request %s: streaming %s failed: %v", c.GetString("RequestID"), objectName, err)
+	}
+}
+
+func respondStorageError(c *gin.Context, err error) {
+	if !apperror.Is(err, apperror.CodeNotFound) {
+		err = apperror.Unavailable(err, "Unable to read file")
+	}
+	apperror.Respond(c, err)
+}
+
+// notModified evaluates If-None-Match, falling back to If-Modified-Since
+// when no entity tag was sent.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		return err == nil && !modified.After(t)
+	}
+	return false
+}
+
+// ifRange reports whether a Range header should be honoured: always, unless
+// If-Range names a different version.
+func ifRange(r *http.Request, etag string, modified time.Time) bool {
+	v := r.Header.Get("If-Range")
+	if v == "" {
+		return true
+	}
+	if strings.HasPrefix(v, `"`) {
+		return v == etag
+	}
+	t, err := http.ParseTime(v)
+	return err == nil && modified.Equal(t)
+}
+
+// parseRange parses a single "bytes=" range against size. It returns start
+// -1 for headers we don't serve ranges for, such as multiple ranges, and ok
+// false when the range can't be satisfied.
+func parseRange(header string, size int64) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return -1, -1, true
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return -1, -1, true
+	}
+
+	if first == "" {
+		// Suffix range: the last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return -1, -1, true
+		}
+		if n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return -1, -1, true
+	}
+	if start >= size {
+		return 0, 0, false
+	}
+
+	end = size - 1
+	if last != "" {
+		e, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || e < start {
+			return -1, -1, true
+		}
+		if e < end {
+			end = e
+		}
+	}
+	return start, end, true
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	model "github.com/Proluxe/proluxe-common-api/model"
+	"github.com/Proluxe/proluxe-common-api/util"
+	"github.com/gin-gonic/gin"
+)
+
+// GET_DUPLICATE_FILES reports the groups of files under ?prefix that hold
+// the same content, and how many bytes the extra copies take up, among the
+// files the caller can read.
+func GET_DUPLICATE_FILES(c *gin.Context, App *util.App) {
+	access, err := folderAccess(c, App, c.Query("prefix"))
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	report, err := file.Duplicates(c.Query("prefix"))
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report.Readable(func(path string) bool {
+		return access.Can(path, model.PermissionRead)
+	}))
+}
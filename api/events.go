@@ -1,9 +1,9 @@
 package api
 
 import (
-	"fmt"
 	"net/http"
 
+	"github.com/Proluxe/proluxe-common-api/apperror"
 	model "github.com/Proluxe/proluxe-common-api/model"
 	"github.com/Proluxe/proluxe-common-api/util"
 	"github.com/gin-gonic/gin"
@@ -11,8 +11,11 @@ import (
 
 func GET_EVENTS(c *gin.Context, App *util.App) {
 	// Fetch the Events
-	client := App.SF.Client
-	events := model.FetchEvents(client, "Name != null ORDER BY Start_Date_Time__c ASC")
+	events, err := App.Events.All()
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
 	sortedEvents := model.SortedEvents(events)
 
@@ -22,11 +25,12 @@ func GET_EVENTS(c *gin.Context, App *util.App) {
 func GET_EVENT_DETAILS(c *gin.Context, App *util.App) {
 	// Fetch event details by ID
 	id := c.Param("id")
-	client := App.SF.Client
 
-	where := fmt.Sprintf("Id = '%s'", id)
-	events := model.FetchEvents(client, where)
-	event := events[0]
+	event, err := App.Events.Get(id)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
 	c.JSON(http.StatusOK, event)
 }
@@ -35,15 +39,17 @@ func CREATE_EVENT(c *gin.Context, App *util.App) {
 	// Parse the event details from the request body
 	var newEvent model.Event
 	if err := c.ShouldBindJSON(&newEvent); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apperror.Respond(c, apperror.Validation("%s", err.Error()))
 		return
 	}
 
 	// Create the new event in Salesforce
-	client := App.SF.Client
-	sobj := model.CreateEvent(client, newEvent)
+	if err := App.Events.Create(&newEvent); err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
-	c.JSON(http.StatusCreated, sobj)
+	c.JSON(http.StatusCreated, newEvent)
 }
 
 func UPDATE_EVENT(c *gin.Context, App *util.App) {
@@ -51,25 +57,30 @@ func UPDATE_EVENT(c *gin.Context, App *util.App) {
 	// Parse the event details from the request body
 	var updatedEvent model.Event
 	if err := c.ShouldBindJSON(&updatedEvent); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apperror.Respond(c, apperror.Validation("%s", err.Error()))
 		return
 	}
 
 	updatedEvent.Id = eventId
 
 	// Update the event in Salesforce
-	client := App.SF.Client
-	sobj := model.UpdateEvent(client, updatedEvent)
+	if err := App.Events.Update(&updatedEvent); err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
-	c.JSON(http.StatusOK, sobj)
+	c.JSON(http.StatusOK, updatedEvent)
 }
 
 func DELETE_EVENT(c *gin.Context, App *util.App) {
 	// Fetch event details by ID
 	id := c.Param("id")
-	client := App.SF.Client
+
 	// Delete the event
-	client.SObject("Events__c").Set("Id", id).Delete()
+	if err := App.Events.Delete(id); err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Event deleted successfully"})
 }
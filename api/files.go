@@ -1,17 +1,15 @@
 package api
 
 import (
-	"bytes"
-	"context"
-	"encoding/base64"
 	"fmt"
-	"io"
 	"net/http"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 
-	"cloud.google.com/go/storage"
-
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/auth"
 	"github.com/Proluxe/proluxe-common-api/util"
 	"github.com/gin-gonic/gin"
 
@@ -19,174 +17,450 @@ import (
 )
 
 type BucketPayload struct {
-	Contents    []map[string]any   `json:"Contents"`
-	SharedFiles []model.SharedFile `json:"SharedFiles"`
+	Contents      []model.BucketEntry `json:"Contents"`
+	SharedFiles   []model.SharedFile  `json:"SharedFiles"`
+	NextPageToken string              `json:"NextPageToken,omitempty"`
 }
 
-// GET_BUCKET_CONTENTS lists the contents of the specified path in the 'common_production' bucket.
+// GET_BUCKET_CONTENTS lists a page of the files and folders directly under
+// ?path, sorted by ?sort (name, size or updated) and ?order (asc or desc).
+// Pass NextPageToken back as ?page_token for the next page of ?page_size.
 func GET_BUCKET_CONTENTS(c *gin.Context, App *util.App) {
-	bucketName := "common_production"
 	path := c.Query("path") // Path within the bucket
 
 	if path == "root/" {
 		path = "/"
 	}
 
-	file := model.New(c, App)
+	q := model.ListQuery{
+		Path:      path,
+		Sort:      c.Query("sort"),
+		PageToken: c.Query("page_token"),
+	}
+	switch c.Query("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		apperror.Respond(c, apperror.Validation("order must be asc or desc"))
+		return
+	}
+	if v := c.Query("page_size"); v != "" {
+		var err error
+		if q.PageSize, err = strconv.Atoi(v); err != nil {
+			apperror.Respond(c, apperror.Validation("page_size must be a number"))
+			return
+		}
+	}
+
+	access, err := folderAccess(c, App, path)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	canRead := access.Can(path, model.PermissionRead)
+	if !canRead && !access.CanSeeInto(path) {
+		apperror.Respond(c, apperror.Forbidden("You do not have read access to %s", path))
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
 
-	contents, err := file.ListBucketContents(bucketName, path)
+	contents, err := file.ListBucketContents(q)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list bucket contents"})
+		apperror.Respond(c, err)
 		return
 	}
+	if !canRead {
+		// Only the way down to granted folders shows.
+		visible := contents.Entries[:0]
+		for _, entry := range contents.Entries {
+			if !entry.IsFolder {
+				continue
+			}
+			if !access.Can(entry.Name, model.PermissionRead) {
+				if !access.CanSeeInto(entry.Name) {
+					continue
+				}
+				// Its count would give away what's hidden in it.
+				entry.Children, entry.MoreChildren = nil, false
+			}
+			visible = append(visible, entry)
+		}
+		contents.Entries = visible
+	}
+	shares, err := App.SharedFiles.UnderPath(path)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	// Trashed files keep their shares until purged; they aren't listed.
+	sharedFiles := make([]model.SharedFile, 0, len(shares))
+	for _, share := range shares {
+		if !model.IsHidden(share.Path) && access.Can(share.Path, model.PermissionRead) {
+			sharedFiles = append(sharedFiles, share)
+		}
+	}
+
 	bucket := &BucketPayload{
-		Contents:    contents,
-		SharedFiles: file.FetchFilesForPath(path),
+		Contents:      contents.Entries,
+		SharedFiles:   sharedFiles,
+		NextPageToken: contents.NextPageToken,
 	}
 
 	c.JSON(http.StatusOK, bucket)
 }
 
+// POST_SEND_FILES emails a signed download link. Links last seven days
+// unless ExpiresIn (seconds) says otherwise. With Paths, or a folder Path,
+// the link is to a ZIP of them named Filename.
 func POST_SEND_FILES(c *gin.Context, App *util.App) {
 	var payload struct {
-		Email string `json:"Email"`
-		Path  string `json:"Path"`
+		Email     string   `json:"Email"`
+		Path      string   `json:"Path"`
+		Paths     []string `json:"Paths"`
+		Filename  string   `json:"Filename"`
+		ExpiresIn int      `json:"ExpiresIn"`
 	}
 
 	if err := c.ShouldBindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		apperror.Respond(c, apperror.Validation("Invalid JSON"))
 		return
 	}
 
-	file := model.New(c, App)
-	url, err := file.MarkPublic("common_production", payload.Path)
+	if payload.Email == "" || payload.Path == "" && len(payload.Paths) == 0 {
+		apperror.Respond(c, apperror.Validation("Email and Path or Paths are required"))
+		return
+	}
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark file as public"})
+	paths := payload.Paths
+	if len(paths) == 0 {
+		paths = []string{payload.Path}
+	}
+	if !requireFolderAccess(c, App, model.PermissionShare, paths...) {
 		return
 	}
 
-	if url != "" {
-		currentUserEmail, _, _ := GetCurrentUser(c)
+	ttl := model.MaxLinkTTL
+	if payload.ExpiresIn != 0 {
+		ttl = time.Duration(payload.ExpiresIn) * time.Second
+	}
+	if ttl <= 0 || ttl > model.MaxLinkTTL {
+		apperror.Respond(c, apperror.Validation("Links must expire within %s", model.MaxLinkTTL))
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
+	from := auth.CurrentPrincipal(c).Actor()
+
+	objectName, filename := payload.Path, path.Base(payload.Path)
+	var entries []model.ArchiveEntry
+	if len(payload.Paths) > 0 || strings.HasSuffix(payload.Path, "/") {
+		filename = archiveFilename(payload.Filename, paths)
 
-		err = file.SendFile(currentUserEmail, payload.Email, payload.Path, url)
+		var err error
+		entries, err = file.PlanArchive(paths, App.Archives)
+		if err != nil {
+			apperror.Respond(c, err)
+			return
+		}
+		// Keep the archive a little past the link so it can't expire mid-download.
+		objectName, err = file.StoreArchive(entries, filename, time.Now().Add(ttl+time.Hour))
 		if err != nil {
-			fmt.Printf("Failed to send email: %v\n", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			apperror.Respond(c, err)
 			return
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Email sent successfully"})
-}
-
-func SERVE_FILE_FROM_BUCKET(c *gin.Context) {
-	bucketName := "common_production"
-	objectName := c.Param("path")
-
-	// Check and clean up the object path
-	if strings.HasPrefix(objectName, "/") {
-		objectName = objectName[1:]
+	signedURL, link, err := file.IssueLink(objectName, payload.Email, filename, from, ttl)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
 	}
 
-	// Create storage client
-	client, err := storage.NewClient(c.Request.Context())
+	err = file.SendFile(from, payload.Email, objectName, signedURL, link.ExpiresAt)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create storage client: " + err.Error()})
+		fmt.Printf("Failed to send email: %v\n", err)
+		apperror.Respond(c, apperror.Unavailable(err, "Failed to send email"))
+		return
 	}
-	defer client.Close()
 
-	// Open a reader to the object
-	rc, err := client.Bucket(bucketName).Object(objectName).NewReader(c.Request.Context())
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found or unable to read: " + err.Error()})
+	if entries != nil {
+		logActivity(c, App, model.ArchiveActivity(model.ActivitySend, entries, filename, payload.Email)...)
+	} else {
+		logActivity(c, App, model.FileActivity{Action: model.ActivitySend, Path: objectName, Target: payload.Email})
 	}
-	defer rc.Close()
 
-	// Use http.ServeContent for better performance and file handling
-	contentType := rc.ContentType()
-	c.Header("Content-Type", contentType)
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", path.Base(objectName)))
+	c.JSON(http.StatusOK, gin.H{"message": "Email sent successfully", "Link": link})
+}
 
-	buf := new(bytes.Buffer)
-	if _, err := io.Copy(buf, rc); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file content: " + err.Error()})
+// POST_SIGNED_URL issues a time-limited download URL without making the file
+// public. ExpiresIn is in seconds and defaults to an hour.
+func POST_SIGNED_URL(c *gin.Context, App *util.App) {
+	var payload struct {
+		Path      string `json:"Path"`
+		ExpiresIn int    `json:"ExpiresIn"`
+		Filename  string `json:"Filename"`
+		Recipient string `json:"Recipient"`
 	}
-	reader := bytes.NewReader(buf.Bytes())
 
-	c.DataFromReader(http.StatusOK, rc.Attrs.Size, rc.ContentType(), reader, nil)
-}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Respond(c, apperror.Validation("Invalid JSON"))
+		return
+	}
 
-func UPLOAD_FILE_TO_BUCKET(c *gin.Context, App *util.App) {
-	bucketName := "common_production"
-	path := c.Query("path") // Path within the bucket
+	if payload.Path == "" {
+		apperror.Respond(c, apperror.Validation("Path is required"))
+		return
+	}
+	if !requireFolderAccess(c, App, model.PermissionShare, payload.Path) {
+		return
+	}
 
-	file := model.New(c, App)
+	ttl := time.Hour
+	if payload.ExpiresIn != 0 {
+		ttl = time.Duration(payload.ExpiresIn) * time.Second
+	}
 
-	fileHeader, err := c.FormFile("file")
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	signedURL, link, err := file.IssueLink(payload.Path, payload.Recipient, payload.Filename, auth.CurrentPrincipal(c).Actor(), ttl)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get file from request"})
+		apperror.Respond(c, err)
+		return
 	}
+	logActivity(c, App, model.FileActivity{
+		Action: model.ActivitySignedURL,
+		Path:   payload.Path,
+		Target: payload.Recipient,
+		Detail: "expires " + link.ExpiresAt.UTC().Format(time.RFC3339),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"URL": signedURL, "ExpiresAt": link.ExpiresAt, "Link": link})
+}
 
-	// Open the uploaded file
-	rawFile, err := fileHeader.Open()
+// GET_FILE_LINKS lists the signed links issued, optionally for one path or
+// recipient.
+func GET_FILE_LINKS(c *gin.Context, App *util.App) {
+	access, err := folderAccess(c, App, c.Query("path"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open uploaded file"})
+		apperror.Respond(c, err)
+		return
 	}
-	defer rawFile.Close()
 
-	// Upload the file to the bucket
-	objectName := path + fileHeader.Filename
-	wc := file.Client.Bucket(bucketName).Object(objectName).NewWriter(context.Background())
-
-	if _, err := io.Copy(wc, rawFile); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
+	links, err := App.FileLinks.Find(c.Query("path"), c.Query("recipient"))
+	if err != nil {
+		apperror.Respond(c, err)
+		return
 	}
 
-	if err := wc.Close(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to finalize file upload"})
+	visible := make([]model.FileLink, 0, len(links))
+	for _, link := range links {
+		if access.Can(link.Path, model.PermissionRead) {
+			visible = append(visible, link)
+		}
 	}
-
-	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("File %s uploaded to bucket %s at %s", fileHeader.Filename, bucketName, objectName)})
+	c.JSON(http.StatusOK, visible)
 }
 
-// CREATE_FOLDER_IN_BUCKET creates a folder in the 'common_production' bucket.
+// CREATE_FOLDER_IN_BUCKET creates a folder in the files bucket.
 func CREATE_FOLDER_IN_BUCKET(c *gin.Context, App *util.App) {
-	bucketName := "common_production"
 
-	file := model.New(c, App)
+	file := model.New(c, App.Blobs, App.Repositories)
 
 	var json struct {
 		Path string `json:"Path"`
 	}
 
 	if err := c.ShouldBindJSON(&json); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		apperror.Respond(c, apperror.Validation("Invalid JSON"))
+		return
 	}
 
 	folderName := json.Path // Path within the bucket
+	if !requireFolderAccess(c, App, model.PermissionWrite, folderName) {
+		return
+	}
 
-	err := file.CreateFolder(bucketName, folderName)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create folder"})
+	if err := file.CreateFolder(folderName); err != nil {
+		apperror.Respond(c, err)
+		return
 	}
+	logActivity(c, App, model.FileActivity{Action: model.ActivityCreateFolder, Path: folderName})
 
-	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Folder %s created in bucket %s", folderName, bucketName)})
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Folder %s created in bucket %s", folderName, App.Blobs.Bucket())})
 }
 
-// DELETE_FILE_FROM_BUCKET deletes a file from the specified path in the 'common_production' bucket.
+// DELETE_FILE_FROM_BUCKET moves a file to the trash. A folder path (ending
+// in "/") needs recursive=true unless it is empty. Admins can skip the trash
+// with permanent=true.
 func DELETE_FILE_FROM_BUCKET(c *gin.Context, App *util.App) {
-	bucketName := "common_production"
 	objectName := c.Query("path") // Path within the bucket
+	recursive, _ := strconv.ParseBool(c.Query("recursive"))
+	permanent, _ := strconv.ParseBool(c.Query("permanent"))
+
+	if permanent && !auth.IsAdmin(c) {
+		apperror.Respond(c, apperror.Forbidden("Only admins can delete files permanently"))
+		return
+	}
+	if !requireFolderAccess(c, App, model.PermissionWrite, objectName) {
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	if strings.HasSuffix(objectName, "/") && !recursive {
+		children, err := App.Blobs.List(c.Request.Context(), model.BlobQuery{Prefix: objectName})
+		if err != nil {
+			apperror.Respond(c, apperror.Unavailable(err, "Failed to list folder"))
+			return
+		}
+		if len(children) > 1 || len(children) == 1 && children[0].Name != objectName {
+			apperror.Respond(c, apperror.Conflict("Folder %s is not empty; delete it with recursive=true", objectName))
+			return
+		}
+	}
+
+	if permanent {
+		results, err := file.DeletePermanently(objectName)
+		if err != nil {
+			apperror.Respond(c, err)
+			return
+		}
+		logResults(c, App, model.ActivityDelete, results)
+		respondObjectResults(c, "deleted", results)
+		return
+	}
+
+	item, results, err := file.Trash(objectName, auth.CurrentPrincipal(c).Actor(), App.TrashRetention)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	logResults(c, App, model.ActivityTrash, results)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("%s moved to the trash until %s", objectName, item.ExpiresAt.Format(time.RFC3339)),
+		"Trash":   item,
+		"Results": objectResultsPayload(results),
+	})
+}
 
-	file := model.New(c, App)
+// GET_TRASH lists deleted files awaiting purge, of the folders the caller
+// can read.
+func GET_TRASH(c *gin.Context, App *util.App) {
+	access, err := folderAccess(c, App, "")
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
 
-	err := file.DeleteFile(bucketName, objectName)
+	items, err := file.ListTrash()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+		apperror.Respond(c, err)
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("File %s deleted from bucket %s", objectName, bucketName)})
+	visible := make([]model.TrashItem, 0, len(items))
+	for _, item := range items {
+		if access.Can(item.Path, model.PermissionRead) {
+			visible = append(visible, item)
+		}
+	}
+	c.JSON(http.StatusOK, visible)
+}
+
+func POST_RESTORE_TRASH(c *gin.Context, App *util.App) {
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	item, err := file.TrashedItem(c.Param("id"))
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	if !requireFolderAccess(c, App, model.PermissionWrite, item.Path) {
+		return
+	}
+
+	results, err := file.Restore(item.Id)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	var activity []model.FileActivity
+	for _, r := range results {
+		if r.Err == nil {
+			activity = append(activity, model.FileActivity{Action: model.ActivityRestore, Path: r.Destination, Detail: r.Source})
+		}
+	}
+	logActivity(c, App, activity...)
+
+	respondObjectResults(c, "restored", results)
+}
+
+func DELETE_TRASH(c *gin.Context, App *util.App) {
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	results, err := file.Purge(c.Param("id"))
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	var activity []model.FileActivity
+	for _, r := range results {
+		if r.Err == nil {
+			activity = append(activity, model.FileActivity{Action: model.ActivityPurge, Path: model.TrashedPath(r.Source), Detail: r.Source})
+		}
+	}
+	logActivity(c, App, activity...)
+
+	respondObjectResults(c, "purged", results)
+}
+
+// POST_PURGE_EXPIRED_TRASH purges whatever has outlived the retention
+// window, along with expired archives, abandoned quarantined uploads and
+// orphaned previews, for schedulers that call in rather than relying on the
+// background sweep.
+func POST_PURGE_EXPIRED_TRASH(c *gin.Context, App *util.App) {
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	purged, err := file.PurgeExpired(time.Now())
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	logActivity(c, App, model.PurgeActivity(purged)...)
+	purgedIds := make([]string, len(purged))
+	for n, item := range purged {
+		purgedIds[n] = item.Id
+	}
+	archives, err := file.PurgeExpiredArchives(time.Now())
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	quarantined, err := file.PurgeStaleQuarantine(time.Now())
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	previews, err := file.PruneOrphanedPreviews()
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     fmt.Sprintf("%d trash items, %d archives, %d quarantined uploads and %d previews purged", len(purged), archives, quarantined, previews),
+		"Purged":      purgedIds,
+		"Archives":    archives,
+		"Quarantined": quarantined,
+		"Previews":    previews,
+	})
 }
 
 func POST_SHARE_FILES(c *gin.Context, App *util.App) {
@@ -196,61 +470,194 @@ func POST_SHARE_FILES(c *gin.Context, App *util.App) {
 	}
 
 	if err := c.ShouldBindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		apperror.Respond(c, apperror.Validation("Invalid JSON"))
+		return
+	}
+	if !requireFolderAccess(c, App, model.PermissionShare, payload.Path) {
+		return
 	}
 
-	file := model.New(c, App)
+	file := model.New(c, App.Blobs, App.Repositories)
 
+	activity := make([]model.FileActivity, 0, len(payload.SharedItems))
 	for _, item := range payload.SharedItems {
-		externalID := fmt.Sprintf("%s-%s-%s", item.Object, item.ObjectId, payload.Path)
-		encodedExternalID := base64.StdEncoding.EncodeToString([]byte(externalID))
-
-		file.SF.SObject("common_File__c").
-			Set("ExternalIDField", "ExternalId__c").
-			Set("ExternalId__c", encodedExternalID).
-			Set("Object__c", item.Object).
-			Set("Object_Id__c", item.ObjectId).
-			Set("Object_Name__c", item.ObjectName).
-			Set("Path__c", payload.Path).
-			Upsert()
+		if err := App.SharedFiles.Share(payload.Path, item); err != nil {
+			logActivity(c, App, activity...)
+			apperror.Respond(c, err)
+			return
+		}
+		activity = append(activity, model.FileActivity{
+			Action: model.ActivityShare,
+			Path:   payload.Path,
+			Target: item.Object + ":" + item.ObjectId,
+			Detail: item.ObjectName,
+		})
 	}
+	logActivity(c, App, activity...)
 
-	currentUserEmail, _, _ := GetCurrentUser(c)
-	err := file.SendFileShareConfirmationEmail(currentUserEmail, payload.Path, payload.SharedItems)
+	err := file.SendFileShareConfirmationEmail(auth.CurrentPrincipal(c).Actor(), payload.Path, payload.SharedItems)
 
 	if err != nil {
 		fmt.Printf("Failed to attach file: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apperror.Respond(c, apperror.Unavailable(err, "Failed to send share confirmation"))
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Files shared successfully"})
 }
 
+// POST_MIGRATE_SHARES moves up to Limit shares out of the legacy
+// common_File__c object into CXP_File__c. Call it again until Remaining
+// comes back false.
+func POST_MIGRATE_SHARES(c *gin.Context, App *util.App) {
+	var payload struct {
+		Limit int `json:"Limit"`
+	}
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Respond(c, apperror.Validation("Invalid JSON"))
+		return
+	}
+
+	migrated, more, err := App.SharedFiles.MigrateLegacy(payload.Limit)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"Migrated":  migrated,
+		"Remaining": more,
+	})
+}
+
 func POST_MAKE_PUBLIC(c *gin.Context, App *util.App) {
 	path := c.Query("path") // Path within the bucket
+	if !requireFolderAccess(c, App, model.PermissionShare, path) {
+		return
+	}
 
-	file := model.New(c, App)
-	url, err := file.MarkPublic("common_production", path)
+	file := model.New(c, App.Blobs, App.Repositories)
 
+	url, err := file.MarkPublic(path)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark file as public"})
+		apperror.Respond(c, err)
 		return
 	}
+	logActivity(c, App, model.FileActivity{Action: model.ActivityMakePublic, Path: path})
 
 	c.JSON(http.StatusOK, gin.H{"url": url})
 }
 
 func POST_MAKE_PRIVATE(c *gin.Context, App *util.App) {
 	path := c.Query("path") // Path within the bucket
+	if !requireFolderAccess(c, App, model.PermissionShare, path) {
+		return
+	}
 
-	file := model.New(c, App)
-	err := file.MarkPrivate("common_production", path)
+	file := model.New(c, App.Blobs, App.Repositories)
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark file as private"})
+	if err := file.MarkPrivate(path); err != nil {
+		apperror.Respond(c, err)
 		return
 	}
+	logActivity(c, App, model.FileActivity{Action: model.ActivityMakePrivate, Path: path})
 
 	c.JSON(http.StatusOK, gin.H{"message": "File marked as private"})
 }
+
+// POST_MOVE_FILES moves a file, or a folder when Source ends in "/", and
+// repoints its Salesforce shares.
+func POST_MOVE_FILES(c *gin.Context, App *util.App) {
+	var payload struct {
+		Source      string `json:"Source"`
+		Destination string `json:"Destination"`
+		Overwrite   bool   `json:"Overwrite"`
+	}
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Respond(c, apperror.Validation("Invalid JSON"))
+		return
+	}
+
+	// Moving takes the files away from the source, so it needs write there.
+	if !requireFolderAccess(c, App, model.PermissionRead, payload.Source) ||
+		!requireFolderAccess(c, App, model.PermissionWrite, payload.Source, payload.Destination) {
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	results, err := file.Move(payload.Source, payload.Destination, payload.Overwrite)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	logResults(c, App, model.ActivityMove, results)
+
+	respondObjectResults(c, "moved", results)
+}
+
+// POST_COPY_FILES copies a file, or a folder when Source ends in "/".
+func POST_COPY_FILES(c *gin.Context, App *util.App) {
+	var payload struct {
+		Source      string `json:"Source"`
+		Destination string `json:"Destination"`
+		Overwrite   bool   `json:"Overwrite"`
+	}
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Respond(c, apperror.Validation("Invalid JSON"))
+		return
+	}
+
+	if !requireFolderAccess(c, App, model.PermissionRead, payload.Source) ||
+		!requireFolderAccess(c, App, model.PermissionWrite, payload.Destination) {
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	results, err := file.Copy(payload.Source, payload.Destination, payload.Overwrite)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	logResults(c, App, model.ActivityCopy, results)
+
+	respondObjectResults(c, "copied", results)
+}
+
+// ObjectResult is one object's line in the response to a bulk operation.
+type ObjectResult struct {
+	Source      string        `json:"Source"`
+	Destination string        `json:"Destination,omitempty"`
+	Error       string        `json:"Error,omitempty"`
+	Code        apperror.Code `json:"Code,omitempty"`
+}
+
+// respondObjectResults reports each object's outcome, with 207 if some
+// failed.
+func respondObjectResults(c *gin.Context, verb string, results []model.ObjectResult) {
+	failed := model.Failed(results)
+	status := http.StatusOK
+	if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{
+		"message": fmt.Sprintf("%d of %d files %s", len(results)-failed, len(results), verb),
+		"Results": objectResultsPayload(results),
+	})
+}
+
+func objectResultsPayload(results []model.ObjectResult) []ObjectResult {
+	payload := make([]ObjectResult, len(results))
+	for n, r := range results {
+		payload[n] = ObjectResult{Source: r.Source, Destination: r.Destination}
+		if r.Err != nil {
+			e := apperror.From(r.Err)
+			payload[n].Error, payload[n].Code = e.Message, e.Code
+		}
+	}
+	return payload
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/auth"
+	model "github.com/Proluxe/proluxe-common-api/model"
+	"github.com/Proluxe/proluxe-common-api/util"
+	"github.com/gin-gonic/gin"
+)
+
+// folderAccess returns what the caller may do around path. Admins may do
+// anything and get nil; staff, other users and API keys are limited to the
+// folders granted to them, with staff also holding App.StaffAccess on the
+// whole bucket.
+func folderAccess(c *gin.Context, App *util.App, path string) (*model.FolderAccess, error) {
+	if bypassesFolderGrants(c) {
+		return nil, nil
+	}
+
+	grants, err := App.FolderGrants.ForPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if App.StaffAccess != "" {
+		grants = append(grants, model.FolderGrant{
+			Folder:      model.RootFolder,
+			GranteeType: model.GranteeRole,
+			Grantee:     auth.RoleStaff,
+			Permission:  App.StaffAccess,
+		})
+	}
+	return model.NewFolderAccess(grants, auth.CurrentPrincipal(c).Grantee()), nil
+}
+
+func bypassesFolderGrants(c *gin.Context) bool {
+	return auth.IsAdmin(c)
+}
+
+// requireFolderAccess responds and returns false unless the caller has perm
+// on every one of paths.
+func requireFolderAccess(c *gin.Context, App *util.App, perm model.Permission, paths ...string) bool {
+	for _, path := range paths {
+		access, err := folderAccess(c, App, path)
+		if err != nil {
+			apperror.Respond(c, err)
+			return false
+		}
+		if !access.Can(path, perm) {
+			apperror.Respond(c, apperror.Forbidden("You do not have %s access to %s", perm, path))
+			return false
+		}
+	}
+	return true
+}
+
+// GET_FOLDER_GRANTS lists the grants on ?path's folder, the folders above
+// it and those beneath it.
+func GET_FOLDER_GRANTS(c *gin.Context, App *util.App) {
+	grants, err := App.FolderGrants.ForPath(c.Query("path"))
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	if grants == nil {
+		grants = []model.FolderGrant{}
+	}
+	c.JSON(http.StatusOK, grants)
+}
+
+func POST_FOLDER_GRANT(c *gin.Context, App *util.App) {
+	var grant model.FolderGrant
+
+	if err := c.ShouldBindJSON(&grant); err != nil {
+		apperror.Respond(c, apperror.Validation("Invalid JSON"))
+		return
+	}
+
+	if err := grant.Validate(); err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	grant.Id = ""
+	grant.GrantedBy = auth.CurrentPrincipal(c).Actor()
+
+	if err := App.FolderGrants.Create(&grant); err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	logActivity(c, App, grantActivity(model.ActivityGrant, grant))
+
+	c.JSON(http.StatusCreated, grant)
+}
+
+func DELETE_FOLDER_GRANT(c *gin.Context, App *util.App) {
+	grant, err := App.FolderGrants.Delete(c.Param("id"))
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	logActivity(c, App, grantActivity(model.ActivityRevokeGrant, *grant))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Folder grant deleted"})
+}
+
+func grantActivity(action string, g model.FolderGrant) model.FileActivity {
+	return model.FileActivity{
+		Action: action,
+		Path:   g.Folder,
+		Target: g.GranteeType + ":" + g.Grantee,
+		Detail: string(g.Permission),
+	}
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"net/http"
 
+	"github.com/Proluxe/proluxe-common-api/apperror"
 	model "github.com/Proluxe/proluxe-common-api/model"
 	"github.com/Proluxe/proluxe-common-api/util"
 	"github.com/gin-gonic/gin"
@@ -10,57 +11,60 @@ import (
 
 func GET_ISSUES(c *gin.Context, App *util.App) {
 	// Fetch the PO Lines
-	client := App.SF.Client
-	issues := model.FetchIssues(client, "Closed__c = FALSE ORDER BY CreatedDate DESC")
+	issues, err := App.Issues.Open()
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
 	c.JSON(http.StatusOK, issues)
 }
 
 func GET_ISSUE(c *gin.Context, App *util.App) {
 	// Fetch the PO Lines
-	client := App.SF.Client
 	id := c.Param("id")
-	issue := model.FetchIssues(client, "Id = '"+id+"'")[0]
+	issue, err := App.Issues.Get(id)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
-	issue.AttachRelatedObjects(client)
+	if err := issue.AttachRelatedObjects(App.SharedFiles); err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
 	c.JSON(http.StatusOK, issue)
 }
 
 func POST_CREATE_ISSUE(c *gin.Context, App *util.App) {
-	// Fetch the PO Lines
-	client := App.SF.Client
-
 	var issue model.Issue
 	if err := c.ShouldBindJSON(&issue); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+		apperror.Respond(c, apperror.Validation("%s", err.Error()))
 		return
 	}
 
-	if issue.Create(client) == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create issue"})
+	if err := App.Issues.Create(&issue); err != nil {
+		apperror.Respond(c, err)
 		return
 	}
 
-	issue.SendNewIssueNotification(client)
+	issue.SendNewIssueNotification(App.Users)
 
 	c.JSON(http.StatusOK, issue)
 }
 
 func POST_UPDATE_ISSUE(c *gin.Context, App *util.App) {
-	// Fetch the PO Lines
-	client := App.SF.Client
-
 	var issue model.Issue
 	if err := c.ShouldBindJSON(&issue); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+		apperror.Respond(c, apperror.Validation("%s", err.Error()))
 		return
 	}
 
 	issue.Id = c.Param("id")
 
-	if issue.Update(client) == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update issue"})
+	if err := App.Issues.Update(&issue); err != nil {
+		apperror.Respond(c, err)
 		return
 	}
 
@@ -68,18 +72,15 @@ func POST_UPDATE_ISSUE(c *gin.Context, App *util.App) {
 }
 
 func POST_CLOSE_ISSUE(c *gin.Context, App *util.App) {
-	// Fetch the PO Lines
-	client := App.SF.Client
-
 	var issue model.Issue
 	issue.Id = c.Param("id")
 
-	if issue.Close(client) == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close issue"})
+	if err := App.Issues.Close(issue.Id); err != nil {
+		apperror.Respond(c, err)
 		return
 	}
 
-	issue.SendClosedIssueNotification(client)
+	issue.SendClosedIssueNotification(App.Users)
 
 	c.JSON(http.StatusOK, issue)
 }
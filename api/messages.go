@@ -3,6 +3,7 @@ package api
 import (
 	"net/http"
 
+	"github.com/Proluxe/proluxe-common-api/apperror"
 	"github.com/Proluxe/proluxe-common-api/google"
 	"github.com/Proluxe/proluxe-common-api/util"
 	"github.com/gin-gonic/gin"
@@ -12,7 +13,11 @@ func GET_MESSAGES(c *gin.Context, App *util.App) {
 	// Fetch the PO Lines
 	email := c.Param("email")
 
-	messages, _ := google.GetEmails(email)
+	messages, err := google.GetEmails(email)
+	if err != nil {
+		apperror.Respond(c, apperror.Unavailable(err, "Failed to fetch messages"))
+		return
+	}
 
 	c.JSON(http.StatusOK, messages)
 }
@@ -22,7 +27,11 @@ func GET_MESSAGE_DETAILS(c *gin.Context, App *util.App) {
 	email := c.Param("email")
 	id := c.Param("id")
 
-	message, _ := google.GetEmailByID(email, id)
+	message, err := google.GetEmailByID(email, id)
+	if err != nil {
+		apperror.Respond(c, apperror.Unavailable(err, "Failed to fetch message"))
+		return
+	}
 
 	c.JSON(http.StatusOK, message)
 }
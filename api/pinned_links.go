@@ -3,6 +3,8 @@ package api
 import (
 	"net/http"
 
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/auth"
 	model "github.com/Proluxe/proluxe-common-api/model"
 	"github.com/Proluxe/proluxe-common-api/util"
 	"github.com/gin-gonic/gin"
@@ -13,19 +15,23 @@ func POST_CREATE_PINNED_LINK(c *gin.Context, app *util.App) {
 	var pinnedLink model.PinnedLink
 
 	if err := c.ShouldBindJSON(&pinnedLink); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		apperror.Respond(c, apperror.Validation("Invalid request payload"))
 		return
 	}
 
 	if pinnedLink.Email == "" || pinnedLink.Path == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields: Email or Path"})
+		apperror.Respond(c, apperror.Validation("Missing required fields: Email or Path"))
 		return
 	}
 
-	client := app.SF.Client
-	link, err := model.CreatePinnedLink(client, pinnedLink)
+	if !auth.CanModify(c, pinnedLink.Email) {
+		apperror.Respond(c, apperror.Forbidden("You can only pin links for yourself"))
+		return
+	}
+
+	link, err := app.PinnedLinks.Create(pinnedLink)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create pinned link", "details": err.Error()})
+		apperror.Respond(c, err)
 		return
 	}
 
@@ -34,14 +40,13 @@ func POST_CREATE_PINNED_LINK(c *gin.Context, app *util.App) {
 
 // GET_PINNED_LINKS retrieves all pinned links for a user
 func GET_PINNED_LINKS(c *gin.Context, app *util.App) {
-	_, email, _ := GetCurrentUser(c)
-	client := app.SF.Client
+	email := auth.CurrentPrincipal(c).Email
 
 	origin := c.Query("app")
 
-	links, err := model.FetchPinnedLinks(client, email, origin)
+	links, err := app.PinnedLinks.ForUser(email, origin)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pinned links", "details": err.Error()})
+		apperror.Respond(c, err)
 		return
 	}
 
@@ -50,11 +55,20 @@ func GET_PINNED_LINKS(c *gin.Context, app *util.App) {
 
 func DELETE_PINNED_LINK(c *gin.Context, app *util.App) {
 	id := c.Param("id")
-	client := app.SF.Client
 
-	err := model.DeletePinnedLink(client, id)
+	link, err := app.PinnedLinks.Get(id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete pinned link", "details": err.Error()})
+		apperror.Respond(c, err)
+		return
+	}
+
+	if !auth.CanModify(c, link.Email) {
+		apperror.Respond(c, apperror.Forbidden("Only the owner or an admin can delete this pinned link"))
+		return
+	}
+
+	if err := app.PinnedLinks.Delete(id); err != nil {
+		apperror.Respond(c, err)
 		return
 	}
 
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	model "github.com/Proluxe/proluxe-common-api/model"
+	"github.com/Proluxe/proluxe-common-api/util"
+	"github.com/gin-gonic/gin"
+)
+
+// SERVE_FILE_PREVIEW serves a JPEG thumbnail (?size=thumb, the default) or
+// larger preview (?size=preview) of an image or PDF, making it first if
+// needed. Previews of a given ?generation never change and are cached for
+// good; those of the live file are rechecked every few minutes.
+func SERVE_FILE_PREVIEW(c *gin.Context, App *util.App) {
+	objectName := strings.TrimPrefix(c.Param("path"), "/")
+
+	size := c.DefaultQuery("size", "thumb")
+
+	var generation int64
+	cacheControl := "private, max-age=300"
+	if g := c.Query("generation"); g != "" {
+		var err error
+		if generation, err = strconv.ParseInt(g, 10, 64); err != nil {
+			apperror.Respond(c, apperror.Validation("generation must be a number"))
+			return
+		}
+		cacheControl = "private, max-age=31536000, immutable"
+	}
+
+	if !requireFolderAccess(c, App, model.PermissionRead, objectName) {
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	preview, err := file.Preview(objectName, generation, size, App.Previews)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	filename := strings.TrimSuffix(path.Base(objectName), path.Ext(objectName)) + "-" + size + ".jpg"
+	serveObject(c, App.Blobs, preview.Name, 0, "inline", filename, cacheControl)
+}
+
+// POST_BACKFILL_PREVIEWS makes the missing previews of files already in the
+// bucket, Limit files under Prefix at a time. Call it again with NextCursor
+// until that comes back empty.
+func POST_BACKFILL_PREVIEWS(c *gin.Context, App *util.App) {
+	var payload struct {
+		Prefix string `json:"Prefix"`
+		Cursor string `json:"Cursor"`
+		Limit  int    `json:"Limit"`
+	}
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Respond(c, apperror.Validation("Invalid JSON"))
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	result, err := file.BackfillPreviews(payload.Prefix, payload.Cursor, payload.Limit, App.Previews)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"Scanned":    result.Scanned,
+		"Generated":  result.Generated,
+		"Failed":     objectResultsPayload(result.Failed),
+		"NextCursor": result.NextCursor,
+	})
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	model "github.com/Proluxe/proluxe-common-api/model"
+	"github.com/Proluxe/proluxe-common-api/util"
+	"github.com/gin-gonic/gin"
+)
+
+// POST_SCAN_FILES scans the files under Prefix that have never been
+// scanned, Limit at a time. Call it again with NextCursor until that comes
+// back empty.
+func POST_SCAN_FILES(c *gin.Context, App *util.App) {
+	var payload struct {
+		Prefix string `json:"Prefix"`
+		Cursor string `json:"Cursor"`
+		Limit  int    `json:"Limit"`
+	}
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Respond(c, apperror.Validation("Invalid JSON"))
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	report, err := file.ScanExisting(payload.Prefix, payload.Cursor, payload.Limit, App.Uploads.Scanner)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	infected := report.Infected
+	if infected == nil {
+		infected = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"Scanned":    report.Scanned,
+		"Clean":      report.Clean,
+		"Infected":   infected,
+		"Failed":     objectResultsPayload(report.Failed),
+		"NextCursor": report.NextCursor,
+	})
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	model "github.com/Proluxe/proluxe-common-api/model"
+	"github.com/Proluxe/proluxe-common-api/util"
+	"github.com/gin-gonic/gin"
+)
+
+// GET_SEARCH_FILES searches the whole bucket, or the folder in ?prefix.
+// Filters: q (name contains), extension, min_size, max_size, updated_after,
+// updated_before, uploaded_by, tag (repeatable; all must match), and object
+// and object_id for files shared with Salesforce records. Pages hold ?limit
+// files; pass the returned NextCursor as ?cursor for the next one.
+func GET_SEARCH_FILES(c *gin.Context, App *util.App) {
+	q := model.SearchQuery{
+		Prefix:     c.Query("prefix"),
+		Name:       c.Query("q"),
+		Extension:  c.Query("extension"),
+		UploadedBy: c.Query("uploaded_by"),
+		Tags:       c.QueryArray("tag"),
+		Object:     c.Query("object"),
+		ObjectId:   c.Query("object_id"),
+		Cursor:     c.Query("cursor"),
+	}
+
+	var err error
+	for _, param := range []struct {
+		name string
+		dst  *int64
+	}{{"min_size", &q.MinSize}, {"max_size", &q.MaxSize}} {
+		if v := c.Query(param.name); v != "" {
+			if *param.dst, err = strconv.ParseInt(v, 10, 64); err != nil || *param.dst < 0 {
+				apperror.Respond(c, apperror.Validation("%s must be a number of bytes", param.name))
+				return
+			}
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil {
+			apperror.Respond(c, apperror.Validation("limit must be a number"))
+			return
+		}
+	}
+	if q.UpdatedAfter, err = parseTimeParam(c, "updated_after"); err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	if q.UpdatedBefore, err = parseTimeParam(c, "updated_before"); err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	access, err := folderAccess(c, App, q.Prefix)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	result, err := file.Search(q)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	visible := result.Files[:0]
+	for _, entry := range result.Files {
+		if access.Can(entry.Name, model.PermissionRead) {
+			visible = append(visible, entry)
+		}
+	}
+	result.Files = visible
+	c.JSON(http.StatusOK, result)
+}
+
+// PUT_FILE_TAGS replaces the tags on a file.
+func PUT_FILE_TAGS(c *gin.Context, App *util.App) {
+	var payload struct {
+		Path string   `json:"Path"`
+		Tags []string `json:"Tags"`
+	}
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Respond(c, apperror.Validation("Invalid JSON"))
+		return
+	}
+
+	if !requireFolderAccess(c, App, model.PermissionWrite, payload.Path) {
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	tags, err := file.SetTags(payload.Path, payload.Tags)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	logActivity(c, App, model.FileActivity{Action: model.ActivityTag, Path: payload.Path, Detail: strings.Join(tags, ", ")})
+
+	c.JSON(http.StatusOK, gin.H{"Path": payload.Path, "Tags": tags})
+}
+
+// parseTimeParam reads an RFC 3339 timestamp or a plain date from the query.
+func parseTimeParam(c *gin.Context, name string) (time.Time, error) {
+	v := c.Query(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, apperror.Validation("%s must be a date (2006-01-02) or an RFC 3339 timestamp", name)
+}
@@ -0,0 +1,260 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/auth"
+	model "github.com/Proluxe/proluxe-common-api/model"
+	"github.com/Proluxe/proluxe-common-api/util"
+	"github.com/gin-gonic/gin"
+)
+
+// UploadResult reports how one file of a multi-file upload went.
+type UploadResult struct {
+	Filename    string `json:"Filename"`
+	Path        string `json:"Path"`
+	Size        int64  `json:"Size,omitempty"`
+	ContentType string `json:"ContentType,omitempty"`
+	// Duplicates lists the files already holding the same content, to be
+	// shared instead.
+	Duplicates []string      `json:"Duplicates,omitempty"`
+	Error      string        `json:"Error,omitempty"`
+	Code       apperror.Code `json:"Code,omitempty"`
+}
+
+// UPLOAD_FILE_TO_BUCKET stores every "file" part of a multipart form under
+// ?path. An optional "checksums" field maps filenames to "md5:<base64>" or
+// "crc32c:<base64>". Each file succeeds or fails on its own; the response is
+// 207 when some failed. Files whose content is already in the bucket come
+// back with the paths of the copies.
+func UPLOAD_FILE_TO_BUCKET(c *gin.Context, App *util.App) {
+	path := c.Query("path") // Path within the bucket
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		apperror.Respond(c, apperror.Validation("Failed to read upload form"))
+		return
+	}
+
+	fileHeaders := form.File["file"]
+	if len(fileHeaders) == 0 {
+		apperror.Respond(c, apperror.Validation("Failed to get file from request"))
+		return
+	}
+
+	checksums := map[string]string{}
+	if raw := c.PostForm("checksums"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &checksums); err != nil {
+			apperror.Respond(c, apperror.Validation("checksums must be a JSON object of filename to checksum"))
+			return
+		}
+	}
+
+	access, err := folderAccess(c, App, path)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
+	uploadedBy := auth.CurrentPrincipal(c).Actor()
+
+	results := make([]UploadResult, 0, len(fileHeaders))
+	activity := make([]model.FileActivity, 0, len(fileHeaders))
+	failed := 0
+	for _, fileHeader := range fileHeaders {
+		result := UploadResult{Filename: fileHeader.Filename, Path: path + fileHeader.Filename}
+
+		var attrs *model.BlobAttrs
+		if !access.Can(result.Path, model.PermissionWrite) {
+			err = apperror.Forbidden("You do not have write access to %s", result.Path)
+		} else {
+			attrs, err = uploadPart(file, App.Uploads, result.Path, fileHeader, checksums[fileHeader.Filename], uploadedBy)
+		}
+		if err != nil {
+			e := apperror.From(err)
+			result.Error, result.Code = e.Message, e.Code
+			failed++
+			activity = append(activity, model.FileActivity{Action: model.ActivityUploadRejected, Path: result.Path, Detail: e.Message})
+		} else {
+			result.Size, result.ContentType = attrs.Size, attrs.ContentType
+			result.Duplicates = afterUpload(file, App, access, attrs)
+			activity = append(activity, model.FileActivity{Action: model.ActivityUpload, Path: result.Path})
+		}
+		results = append(results, result)
+	}
+	logActivity(c, App, activity...)
+
+	status := http.StatusOK
+	if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{
+		"message": fmt.Sprintf("%d of %d files uploaded to bucket %s", len(results)-failed, len(results), App.Blobs.Bucket()),
+		"Results": results,
+	})
+}
+
+// afterUpload follows every finished upload, whole or resumable: it starts
+// the file's previews and returns the other files with the same content that
+// the caller can read. Failing to look is not worth failing the upload.
+func afterUpload(file *model.File, App *util.App, access *model.FolderAccess, attrs *model.BlobAttrs) []string {
+	file.PreviewInBackground(*attrs, App.Previews)
+
+	paths, err := file.FindCopies(model.ContentHash(attrs), attrs.Name)
+	if err != nil {
+		log.Printf("Failed to look up copies of %s: %v", attrs.Name, err)
+		return nil
+	}
+	var readable []string
+	for _, p := range paths {
+		if access.Can(p, model.PermissionRead) {
+			readable = append(readable, p)
+		}
+	}
+	return readable
+}
+
+func uploadPart(file *model.File, policy model.UploadPolicy, objectName string, fileHeader *multipart.FileHeader, checksum, uploadedBy string) (*model.BlobAttrs, error) {
+	if policy.MaxBytes > 0 && fileHeader.Size > policy.MaxBytes {
+		return nil, apperror.Validation("Files may be at most %d bytes", policy.MaxBytes)
+	}
+
+	want, err := model.ParseChecksum(checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	rawFile, err := fileHeader.Open()
+	if err != nil {
+		return nil, apperror.Validation("Failed to open uploaded file")
+	}
+	defer rawFile.Close()
+
+	return file.Upload(objectName, rawFile, policy, want, uploadedBy)
+}
+
+// Resumable uploads
+//
+// POST /files/uploads opens a session for a file of known size. The client
+// then PATCHes the bytes in order, each request carrying the offset it
+// starts at in Upload-Offset, and can GET the session to learn where to
+// resume after a dropped connection.
+
+func POST_START_UPLOAD(c *gin.Context, App *util.App) {
+	var payload struct {
+		Path     string `json:"Path"`
+		Size     int64  `json:"Size"`
+		Checksum string `json:"Checksum"`
+	}
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Respond(c, apperror.Validation("Invalid JSON"))
+		return
+	}
+
+	checksum, err := model.ParseChecksum(payload.Checksum)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	if !requireFolderAccess(c, App, model.PermissionWrite, payload.Path) {
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	session, err := file.StartUpload(payload.Path, payload.Size, checksum, App.Uploads, auth.CurrentPrincipal(c).Actor())
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	c.Header("Location", "/files/uploads/"+session.Id)
+	respondUpload(c, http.StatusCreated, session)
+}
+
+func GET_UPLOAD(c *gin.Context, App *util.App) {
+	file := model.New(c, App.Blobs, App.Repositories)
+	if !requireUploadAccess(c, App, file) {
+		return
+	}
+
+	session, err := file.UploadStatus(c.Param("id"))
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	respondUpload(c, http.StatusOK, session)
+}
+
+func PATCH_UPLOAD(c *gin.Context, App *util.App) {
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		apperror.Respond(c, apperror.Validation("Upload-Offset header is required"))
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
+	if !requireUploadAccess(c, App, file) {
+		return
+	}
+
+	session, err := file.AppendUpload(c.Param("id"), offset, c.Request.Body, App.Uploads)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	if session.Result != nil {
+		if access, err := folderAccess(c, App, session.Path); err != nil {
+			log.Printf("Failed to check access to copies of %s: %v", session.Path, err)
+			file.PreviewInBackground(*session.Result, App.Previews)
+		} else {
+			session.Duplicates = afterUpload(file, App, access, session.Result)
+		}
+		logActivity(c, App, model.FileActivity{Action: model.ActivityUpload, Path: session.Path})
+	}
+
+	respondUpload(c, http.StatusOK, session)
+}
+
+func DELETE_UPLOAD(c *gin.Context, App *util.App) {
+	file := model.New(c, App.Blobs, App.Repositories)
+	if !requireUploadAccess(c, App, file) {
+		return
+	}
+
+	if err := file.CancelUpload(c.Param("id")); err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Upload cancelled"})
+}
+
+// requireUploadAccess checks that the caller may still write where the
+// session's file is going.
+func requireUploadAccess(c *gin.Context, App *util.App, file *model.File) bool {
+	if bypassesFolderGrants(c) {
+		return true
+	}
+	session, err := file.UploadStatus(c.Param("id"))
+	if err != nil {
+		apperror.Respond(c, err)
+		return false
+	}
+	return requireFolderAccess(c, App, model.PermissionWrite, session.Path)
+}
+
+func respondUpload(c *gin.Context, status int, session *model.UploadSession) {
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(session.Size, 10))
+	c.JSON(status, session)
+}
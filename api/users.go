@@ -1,56 +1,75 @@
 package api
 
 import (
-	"fmt"
 	"net/http"
 
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/auth"
 	model "github.com/Proluxe/proluxe-common-api/model"
 	"github.com/Proluxe/proluxe-common-api/util"
 	"github.com/gin-gonic/gin"
 )
 
 func GET_DEFAULTS(c *gin.Context, App *util.App) {
-	_, email, _ := GetCurrentUser(c)
-	client := App.SF.Client
+	email := auth.CurrentPrincipal(c).Email
 
 	origin := c.Query("app")
 
-	links, err := model.FetchPinnedLinks(client, email, origin)
+	links, err := App.PinnedLinks.ForUser(email, origin)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pinned links", "details": err.Error()})
+		apperror.Respond(c, err)
 		return
 	}
 
-	issues := model.FetchIssues(client, "Closed__c = FALSE ORDER BY CreatedDate DESC")
+	issues, err := App.Issues.Open()
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
-	events := model.FetchEvents(client, "Name != null AND End_Date_Time__c > TODAY ORDER BY End_Date_Time__c ASC")
+	events, err := App.Events.Upcoming()
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
-	users := model.FetchUsers(client, fmt.Sprintf("rstk__syusr_empl_email__c = '%s' LIMIT 1", email))
+	user, err := App.Users.FindByEmail(email)
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
-	mentionableUsers := model.MentionableUsers(client)
+	mentionableUsers, err := App.Users.Mentionable()
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"PinnedLinks":      links,
 		"Issues":           issues,
 		"Events":           events,
-		"User":             users[0],
+		"User":             user,
 		"MentionableUsers": mentionableUsers,
 	})
 }
 
 func POST_UPDATE_USER(c *gin.Context, App *util.App) {
-	client := App.SF.Client
-
 	var user model.User
 	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+		apperror.Respond(c, apperror.Validation("%s", err.Error()))
 		return
 	}
 
 	user.Id = c.Param("id")
 
-	if user.Update(client) == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+	if p := auth.CurrentPrincipal(c); !p.HasRole(auth.RoleAdmin) && (p.UserID == "" || p.UserID != user.Id) {
+		apperror.Respond(c, apperror.Forbidden("You can only change your own settings"))
+		return
+	}
+
+	if err := App.Users.Update(&user); err != nil {
+		apperror.Respond(c, err)
 		return
 	}
 
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/auth"
+	model "github.com/Proluxe/proluxe-common-api/model"
+	"github.com/Proluxe/proluxe-common-api/util"
+	"github.com/gin-gonic/gin"
+)
+
+// GET_FILE_VERSIONS lists the generations of ?path, newest first. Any of
+// them can be downloaded with /files/download/<path>?generation=.
+func GET_FILE_VERSIONS(c *gin.Context, App *util.App) {
+	if !requireFolderAccess(c, App, model.PermissionRead, c.Query("path")) {
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	versions, err := file.Versions(c.Query("path"))
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, versions)
+}
+
+// POST_PROMOTE_FILE_VERSION makes a past generation of a file live again.
+func POST_PROMOTE_FILE_VERSION(c *gin.Context, App *util.App) {
+	var payload struct {
+		Path       string      `json:"Path"`
+		Generation json.Number `json:"Generation"`
+	}
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Respond(c, apperror.Validation("Invalid JSON"))
+		return
+	}
+	generation, err := payload.Generation.Int64()
+	if err != nil {
+		apperror.Respond(c, apperror.Validation("Generation must be a number"))
+		return
+	}
+
+	if !requireFolderAccess(c, App, model.PermissionWrite, payload.Path) {
+		return
+	}
+
+	file := model.New(c, App.Blobs, App.Repositories)
+
+	attrs, err := file.Promote(payload.Path, generation, auth.CurrentPrincipal(c).Actor())
+	if err != nil {
+		apperror.Respond(c, err)
+		return
+	}
+	logActivity(c, App, model.FileActivity{Action: model.ActivityPromote, Path: payload.Path, Detail: fmt.Sprintf("generation %d", generation)})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    fmt.Sprintf("Version %d of %s restored", generation, payload.Path),
+		"Generation": fmt.Sprint(attrs.Generation),
+	})
+}
@@ -0,0 +1,133 @@
+// Package apperror defines the typed errors returned by models and
+// repositories, and the JSON envelope every handler uses to report them.
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Code string
+
+const (
+	CodeNotFound     Code = "not_found"
+	CodeValidation   Code = "validation_error"
+	CodeUnauthorized Code = "unauthorized"
+	CodeForbidden    Code = "forbidden"
+	CodeUnavailable  Code = "upstream_unavailable"
+	CodeConflict     Code = "conflict"
+	CodeRateLimited  Code = "rate_limited"
+	CodeInternal     Code = "internal_error"
+)
+
+// Error is an error with a Code that decides the HTTP status and a Message
+// that is safe to show to API callers. The wrapped Err is only logged.
+type Error struct {
+	Code    Code
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status returns the HTTP status for the error's code.
+func (e *Error) Status() int {
+	switch e.Code {
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeValidation:
+		return http.StatusBadRequest
+	case CodeUnauthorized:
+		return http.StatusUnauthorized
+	case CodeForbidden:
+		return http.StatusForbidden
+	case CodeUnavailable:
+		return http.StatusBadGateway
+	case CodeConflict:
+		return http.StatusConflict
+	case CodeRateLimited:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func NotFound(format string, args ...interface{}) *Error {
+	return &Error{Code: CodeNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+func Validation(format string, args ...interface{}) *Error {
+	return &Error{Code: CodeValidation, Message: fmt.Sprintf(format, args...)}
+}
+
+func Unauthorized(format string, args ...interface{}) *Error {
+	return &Error{Code: CodeUnauthorized, Message: fmt.Sprintf(format, args...)}
+}
+
+func Forbidden(format string, args ...interface{}) *Error {
+	return &Error{Code: CodeForbidden, Message: fmt.Sprintf(format, args...)}
+}
+
+func Conflict(format string, args ...interface{}) *Error {
+	return &Error{Code: CodeConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+func RateLimited(format string, args ...interface{}) *Error {
+	return &Error{Code: CodeRateLimited, Message: fmt.Sprintf(format, args...)}
+}
+
+// Unavailable wraps a failure talking to an upstream service (Salesforce,
+// Cloud Storage, Knock, ...).
+func Unavailable(err error, format string, args ...interface{}) *Error {
+	return &Error{Code: CodeUnavailable, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+// Wrap attaches err as the cause of e and returns e.
+func (e *Error) Wrap(err error) *Error {
+	e.Err = err
+	return e
+}
+
+// From returns err as an *Error, treating anything untyped as internal.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return &Error{Code: CodeInternal, Message: "Internal server error", Err: err}
+}
+
+// Is reports whether err carries the given code.
+func Is(err error, code Code) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// Respond aborts the request with the error envelope:
+//
+//	{"error": "Issue not found", "code": "not_found", "request_id": "..."}
+func Respond(c *gin.Context, err error) {
+	e := From(err)
+
+	if e.Code == CodeInternal || e.Code == CodeUnavailable {
+		fmt.Printf("Request %s failed: %v\n", c.GetString("RequestID"), e)
+	}
+
+	c.AbortWithStatusJSON(e.Status(), gin.H{
+		"error":      e.Message,
+		"code":       e.Code,
+		"request_id": c.GetString("RequestID"),
+	})
+}
@@ -0,0 +1,81 @@
+// Package audit keeps the file activity log, in BigQuery or in memory.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Proluxe/proluxe-common-api/model"
+	u "github.com/scottraio/go-utils"
+)
+
+const defaultTable = "file_activity"
+
+// FromEnv opens the log chosen by AUDIT_LOG: "bigquery" writes to the
+// AUDIT_TABLE table (file_activity unless set) of BIGQUERY_DATASET_ID in
+// GCP_PROJECT_ID, "memory" keeps entries for the life of the process, and
+// "off" records nothing. Left unset, BigQuery is tried, and if it can't be
+// reached the log is turned off with a warning rather than stopping the API.
+func FromEnv(ctx context.Context) (model.ActivityLog, error) {
+	table := u.GetDotEnvVariable("AUDIT_TABLE")
+	if table == "" {
+		table = defaultTable
+	}
+	project, dataset := u.GetDotEnvVariable("GCP_PROJECT_ID"), u.GetDotEnvVariable("BIGQUERY_DATASET_ID")
+
+	switch strings.ToLower(u.GetDotEnvVariable("AUDIT_LOG")) {
+	case "":
+		activity, err := NewBigQuery(ctx, project, dataset, table)
+		if err != nil {
+			log.Printf("WARNING: AUDIT_LOG is not set and BigQuery can't be used (%v), so file activity is NOT logged. Fix the BigQuery settings, or set AUDIT_LOG=off to silence this warning.", err)
+			return nil, nil
+		}
+		return activity, nil
+	case "bigquery":
+		return NewBigQuery(ctx, project, dataset, table)
+	case "memory":
+		return &Memory{}, nil
+	case "off":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown AUDIT_LOG %q", u.GetDotEnvVariable("AUDIT_LOG"))
+}
+
+// Memory keeps the log in process memory, for local runs. It is safe for
+// concurrent use.
+type Memory struct {
+	mu      sync.Mutex
+	entries []model.FileActivity
+}
+
+func (m *Memory) Record(ctx context.Context, entries []model.FileActivity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, entries...)
+	return nil
+}
+
+func (m *Memory) Find(ctx context.Context, q model.ActivityQuery) ([]model.FileActivity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	found := []model.FileActivity{}
+	for _, e := range m.entries {
+		if !strings.HasPrefix(e.Path, q.Prefix) ||
+			q.Actor != "" && !strings.EqualFold(e.Actor, q.Actor) ||
+			!q.From.IsZero() && e.At.Before(q.From) ||
+			!q.To.IsZero() && !e.At.Before(q.To) {
+			continue
+		}
+		found = append(found, e)
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].At.After(found[j].At) })
+	if q.Limit > 0 && len(found) > q.Limit {
+		found = found[:q.Limit]
+	}
+	return found, nil
+}
@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/Proluxe/proluxe-common-api/google"
+	"github.com/Proluxe/proluxe-common-api/model"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// insertBatch keeps each streaming insert well under BigQuery's request limits.
+const insertBatch = 500
+
+// BigQuery keeps the log in a BigQuery table partitioned by day.
+type BigQuery struct {
+	client  *bigquery.Client
+	dataset string
+	table   string
+}
+
+// NewBigQuery connects to project and creates the table in dataset if it
+// isn't there yet.
+func NewBigQuery(ctx context.Context, project, dataset, table string) (*BigQuery, error) {
+	if project == "" || dataset == "" {
+		return nil, errors.New("GCP_PROJECT_ID and BIGQUERY_DATASET_ID must be set")
+	}
+	client, err := bigquery.NewClient(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &BigQuery{client: client, dataset: dataset, table: table}
+	if err := b.ensureTable(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *BigQuery) ensureTable(ctx context.Context) error {
+	t := b.client.Dataset(b.dataset).Table(b.table)
+	_, err := t.Metadata(ctx)
+	var apiErr *googleapi.Error
+	if err == nil || !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+		return err
+	}
+
+	schema, err := bigquery.InferSchema(model.FileActivity{})
+	if err != nil {
+		return err
+	}
+	err = t.Create(ctx, &bigquery.TableMetadata{
+		Schema:           schema,
+		TimePartitioning: &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "At"},
+		Clustering:       &bigquery.Clustering{Fields: []string{"Path", "Actor"}},
+	})
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+		// Another instance created it first.
+		return nil
+	}
+	return err
+}
+
+func (b *BigQuery) Record(ctx context.Context, entries []model.FileActivity) error {
+	for start := 0; start < len(entries); start += insertBatch {
+		batch := entries[start:min(start+insertBatch, len(entries))]
+		rows := make([]interface{}, len(batch))
+		for n := range batch {
+			rows[n] = &batch[n]
+		}
+		if err := google.BigQueryInsert(ctx, b.client, rows, b.table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BigQuery) Find(ctx context.Context, q model.ActivityQuery) ([]model.FileActivity, error) {
+	var where []string
+	var params []bigquery.QueryParameter
+	if q.Prefix != "" {
+		where = append(where, "STARTS_WITH(Path, @prefix)")
+		params = append(params, bigquery.QueryParameter{Name: "prefix", Value: q.Prefix})
+	}
+	if q.Actor != "" {
+		where = append(where, "LOWER(Actor) = LOWER(@actor)")
+		params = append(params, bigquery.QueryParameter{Name: "actor", Value: q.Actor})
+	}
+	if !q.From.IsZero() {
+		where = append(where, "At >= @from")
+		params = append(params, bigquery.QueryParameter{Name: "from", Value: q.From})
+	}
+	if !q.To.IsZero() {
+		where = append(where, "At < @to")
+		params = append(params, bigquery.QueryParameter{Name: "to", Value: q.To})
+	}
+
+	sql := fmt.Sprintf("SELECT * FROM `%s.%s`", b.dataset, b.table)
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	sql += " ORDER BY At DESC"
+	if q.Limit > 0 {
+		sql += " LIMIT @limit"
+		params = append(params, bigquery.QueryParameter{Name: "limit", Value: q.Limit})
+	}
+
+	query := b.client.Query(sql)
+	query.Parameters = params
+	it, err := query.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	found := []model.FileActivity{}
+	for {
+		var entry model.FileActivity
+		err := it.Next(&entry)
+		if err == iterator.Done {
+			return found, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, entry)
+	}
+}
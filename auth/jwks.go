@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// JWKS fetches and caches the signing keys published at URL. Keys are
+// refetched once MaxAge has passed, or early when a token names a key we
+// haven't seen, so that key rotation needs no restart.
+type JWKS struct {
+	URL        string
+	MaxAge     time.Duration
+	HTTPClient *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetched   time.Time
+	attempted time.Time
+	fetchErr  error
+	// inflight is closed when the fetch under way finishes, so callers
+	// that need fresh keys wait for it instead of fetching again.
+	inflight chan struct{}
+}
+
+// minRefetch limits how often an unknown kid, or an endpoint that is down,
+// can trigger a fetch.
+const minRefetch = time.Minute
+
+func NewJWKS(url string) *JWKS {
+	return &JWKS{
+		URL:        url,
+		MaxAge:     time.Hour,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Key returns the public key with the given kid.
+func (j *JWKS) Key(kid string) (interface{}, error) {
+	j.mu.Lock()
+	key, ok := j.keys[kid]
+	if ok && time.Since(j.fetched) < j.MaxAge {
+		j.mu.Unlock()
+		return key, nil
+	}
+
+	wait := j.inflight
+	if wait == nil {
+		if time.Since(j.attempted) < minRefetch {
+			// Fetched or tried too recently; keep serving the keys we have
+			// if the endpoint is down.
+			defer j.mu.Unlock()
+			return j.lookup(kid)
+		}
+		wait = make(chan struct{})
+		j.inflight, j.attempted = wait, time.Now()
+		j.mu.Unlock()
+
+		keys, err := j.fetch()
+
+		j.mu.Lock()
+		if err == nil {
+			j.keys, j.fetched = keys, time.Now()
+		}
+		j.fetchErr, j.inflight = err, nil
+		close(wait)
+		j.mu.Unlock()
+	} else {
+		j.mu.Unlock()
+	}
+	<-wait
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.lookup(kid)
+}
+
+// lookup must be called with j.mu held.
+func (j *JWKS) lookup(kid string) (interface{}, error) {
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	if j.fetchErr != nil {
+		return nil, j.fetchErr
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetch reads the key set. It is called without j.mu held.
+func (j *JWKS) fetch() (map[string]interface{}, error) {
+	resp, err := j.HTTPClient.Get(j.URL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+		if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+
+	return keys, nil
+}
+
+// publicKey decodes an RSA or P-256 key. Other key types are skipped.
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
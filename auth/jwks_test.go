@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// jwksServer publishes a key set that tests can change, and counts fetches.
+type jwksServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    []jsonWebKey
+	fetches int
+}
+
+func newJWKSServer(t *testing.T, keys ...jsonWebKey) *jwksServer {
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) publish(keys ...jsonWebKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *jwksServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func rsaJWK(t *testing.T, kid string) (*rsa.PrivateKey, jsonWebKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, jsonWebKey{
+		Kid: kid,
+		Kty: "RSA",
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(t *testing.T, kid string, curve elliptic.Curve) (*ecdsa.PrivateKey, jsonWebKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	return key, jsonWebKey{
+		Kid: kid,
+		Kty: "EC",
+		Crv: curve.Params().Name,
+		X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+func TestJWKSKeyTypes(t *testing.T) {
+	rsaKey, rsaPub := rsaJWK(t, "rsa")
+	ecKey, ecPub := ecJWK(t, "p256", elliptic.P256())
+	_, p384 := ecJWK(t, "p384", elliptic.P384())
+	_, encryption := rsaJWK(t, "enc")
+	encryption.Use = "enc"
+	server := newJWKSServer(t, rsaPub, ecPub, p384, encryption)
+
+	jwks := NewJWKS(server.URL)
+
+	got, err := jwks.Key("rsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub, ok := got.(*rsa.PublicKey); !ok || !pub.Equal(&rsaKey.PublicKey) {
+		t.Errorf("rsa key = %#v, want the published one", got)
+	}
+
+	got, err = jwks.Key("p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub, ok := got.(*ecdsa.PublicKey); !ok || !pub.Equal(&ecKey.PublicKey) {
+		t.Errorf("p256 key = %#v, want the published one", got)
+	}
+
+	for _, kid := range []string{"p384", "enc"} {
+		if key, err := jwks.Key(kid); err == nil {
+			t.Errorf("Key(%q) = %T, want it skipped", kid, key)
+		}
+	}
+}
+
+func TestJWKSUnknownKidRefetch(t *testing.T) {
+	_, first := rsaJWK(t, "first")
+	_, second := rsaJWK(t, "second")
+	server := newJWKSServer(t, first)
+	jwks := NewJWKS(server.URL)
+
+	if _, err := jwks.Key("first"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key set rotates, but an unknown kid so soon after a fetch
+	// doesn't trigger another.
+	server.publish(first, second)
+	for n := 0; n < 3; n++ {
+		if _, err := jwks.Key("second"); err == nil {
+			t.Fatal("unknown kid was found without a refetch")
+		}
+	}
+	if got := server.fetchCount(); got != 1 {
+		t.Fatalf("%d fetches, want 1 within minRefetch", got)
+	}
+
+	// Once minRefetch has passed, the unknown kid triggers one.
+	jwks.mu.Lock()
+	jwks.attempted = time.Now().Add(-minRefetch)
+	jwks.mu.Unlock()
+	if _, err := jwks.Key("second"); err != nil {
+		t.Fatalf("Key after rotation: %v", err)
+	}
+	if _, err := jwks.Key("first"); err != nil {
+		t.Fatalf("Key for the old kid: %v", err)
+	}
+	if got := server.fetchCount(); got != 2 {
+		t.Errorf("%d fetches, want 2", got)
+	}
+}
+
+func TestJWKSServesCachedKeysWhenDown(t *testing.T) {
+	_, pub := rsaJWK(t, "rsa")
+	server := newJWKSServer(t, pub)
+	jwks := NewJWKS(server.URL)
+	if _, err := jwks.Key("rsa"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Past MaxAge with the endpoint gone, the old keys are still served.
+	server.Close()
+	jwks.mu.Lock()
+	jwks.fetched = time.Now().Add(-2 * jwks.MaxAge)
+	jwks.attempted = time.Now().Add(-minRefetch)
+	jwks.mu.Unlock()
+	if _, err := jwks.Key("rsa"); err != nil {
+		t.Errorf("Key with the endpoint down: %v", err)
+	}
+}
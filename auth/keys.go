@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/model"
+)
+
+const (
+	// keyCacheTTL is also how long a revoked key keeps working.
+	keyCacheTTL = 30 * time.Second
+	// keyTouchInterval is how often a key's use is recorded.
+	keyTouchInterval = time.Minute
+	// maxCachedKeys bounds the cache against callers cycling through
+	// made-up keys.
+	maxCachedKeys = 1000
+)
+
+// keyCache remembers API key lookups, including misses, so a busy key
+// doesn't cost a Salesforce query per request, and when each key's use was
+// last recorded.
+type keyCache struct {
+	keys model.APIKeyRepository
+
+	mu      sync.Mutex
+	entries map[string]keyEntry
+	touched map[string]time.Time
+}
+
+type keyEntry struct {
+	key     *model.APIKey
+	fetched time.Time
+}
+
+func newKeyCache(keys model.APIKeyRepository) *keyCache {
+	return &keyCache{keys: keys, entries: make(map[string]keyEntry), touched: make(map[string]time.Time)}
+}
+
+// lookup returns the key whose hash is hash, or an apperror.CodeNotFound
+// error.
+func (kc *keyCache) lookup(hash string) (*model.APIKey, error) {
+	kc.mu.Lock()
+	entry, ok := kc.entries[hash]
+	kc.mu.Unlock()
+	if !ok || time.Since(entry.fetched) >= keyCacheTTL {
+		key, err := kc.keys.FindByHash(hash)
+		if err != nil && !apperror.Is(err, apperror.CodeNotFound) {
+			return nil, err
+		}
+		entry = keyEntry{key: key, fetched: time.Now()}
+
+		kc.mu.Lock()
+		if len(kc.entries) >= maxCachedKeys {
+			kc.sweep()
+		}
+		kc.entries[hash] = entry
+		kc.mu.Unlock()
+	}
+
+	if entry.key == nil {
+		return nil, apperror.NotFound("API key not found")
+	}
+	// Callers get their own copy, since the principal holds on to it.
+	key := *entry.key
+	return &key, nil
+}
+
+// sweep drops expired entries, or every entry if none have expired. It must
+// be called with kc.mu held.
+func (kc *keyCache) sweep() {
+	for hash, entry := range kc.entries {
+		if time.Since(entry.fetched) >= keyCacheTTL {
+			delete(kc.entries, hash)
+		}
+	}
+	if len(kc.entries) >= maxCachedKeys {
+		kc.entries = make(map[string]keyEntry)
+	}
+}
+
+// touch records in the background that key id was used at now, at most
+// once every keyTouchInterval to keep DML calls down.
+func (kc *keyCache) touch(id string, now time.Time) {
+	kc.mu.Lock()
+	due := now.Sub(kc.touched[id]) >= keyTouchInterval
+	if due {
+		kc.touched[id] = now
+	}
+	kc.mu.Unlock()
+	if !due {
+		return
+	}
+
+	go func() {
+		if err := kc.keys.Touch(id, now); err != nil {
+			log.Println("Error recording API key use: ", err)
+		}
+	}()
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/model"
+	"github.com/Proluxe/proluxe-common-api/model/memory"
+)
+
+// countingKeys counts the calls that would reach Salesforce.
+type countingKeys struct {
+	model.APIKeyRepository
+	finds   int
+	touches chan string
+}
+
+func (k *countingKeys) FindByHash(hash string) (*model.APIKey, error) {
+	k.finds++
+	return k.APIKeyRepository.FindByHash(hash)
+}
+
+func (k *countingKeys) Touch(id string, at time.Time) error {
+	k.touches <- id
+	return k.APIKeyRepository.Touch(id, at)
+}
+
+func TestKeyCacheLookup(t *testing.T) {
+	keys := &countingKeys{APIKeyRepository: memory.New().Repositories().APIKeys}
+	key, secret, err := model.NewAPIKey("erp-sync", []string{"files:read"}, time.Time{}, "admin@proluxe.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Create(&key); err != nil {
+		t.Fatal(err)
+	}
+	kc := newKeyCache(keys)
+
+	for n := 0; n < 3; n++ {
+		got, err := kc.lookup(model.HashAPIKey(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Id != key.Id {
+			t.Fatalf("lookup = %s, want %s", got.Id, key.Id)
+		}
+	}
+	for n := 0; n < 3; n++ {
+		if _, err := kc.lookup(model.HashAPIKey("made-up")); !apperror.Is(err, apperror.CodeNotFound) {
+			t.Fatalf("lookup of an unknown key: %v, want not found", err)
+		}
+	}
+	if keys.finds != 2 {
+		t.Errorf("%d FindByHash calls, want one per key", keys.finds)
+	}
+
+	// Once the entry is stale the key is looked up again, so a revocation
+	// takes effect.
+	if err := keys.Revoke(key.Id); err != nil {
+		t.Fatal(err)
+	}
+	kc.entries[model.HashAPIKey(secret)] = keyEntry{key: &key, fetched: time.Now().Add(-keyCacheTTL)}
+	got, err := kc.lookup(model.HashAPIKey(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Revoked {
+		t.Error("stale entry was used after the key was revoked")
+	}
+}
+
+func TestKeyCacheTouch(t *testing.T) {
+	keys := &countingKeys{APIKeyRepository: memory.New().Repositories().APIKeys, touches: make(chan string, 10)}
+	kc := newKeyCache(keys)
+
+	now := time.Now()
+	kc.touch("key1", now)
+	kc.touch("key1", now.Add(time.Second))
+	kc.touch("key2", now.Add(time.Second))
+	kc.touch("key1", now.Add(keyTouchInterval))
+
+	want := map[string]int{"key1": 2, "key2": 1}
+	got := make(map[string]int)
+	for n := 0; n < 3; n++ {
+		select {
+		case id := <-keys.touches:
+			got[id]++
+		case <-time.After(time.Second):
+			t.Fatalf("touches = %v, want %v", got, want)
+		}
+	}
+	select {
+	case id := <-keys.touches:
+		t.Errorf("extra touch of %s", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got["key1"] != want["key1"] || got["key2"] != want["key2"] {
+		t.Errorf("touches = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/model"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	u "github.com/scottraio/go-utils"
+)
+
+// Config holds the secrets and stores the auth modes check against.
+type Config struct {
+	Tokens        *TokenVerifier
+	BasicUser     string
+	BasicPassword string
+	WebhookSecret string
+
+	// users resolves signed-in users to their user record and roles.
+	users *userCache
+	// keys looks up API keys and records their use.
+	keys *keyCache
+}
+
+// ConfigFromEnv reads the secrets from the environment. API keys and user
+// roles are looked up in repos.
+func ConfigFromEnv(repos model.Repositories) (Config, error) {
+	tokens, err := TokenVerifierFromEnv()
+	if err != nil {
+		return Config{}, err
+	}
+
+	cfg := NewConfig(tokens, repos)
+	cfg.BasicUser = u.GetDotEnvVariable("ALGOLIA_AUTH_USER")
+	cfg.BasicPassword = u.GetDotEnvVariable("ALGOLIA_AUTH_PASS")
+	cfg.WebhookSecret = u.GetDotEnvVariable("WEBHOOK_SECRET")
+	return cfg, nil
+}
+
+// NewConfig returns a config that verifies user tokens with tokens and looks
+// up API keys and user roles in repos. The basic auth and webhook secrets
+// are left for the caller to set.
+func NewConfig(tokens *TokenVerifier, repos model.Repositories) Config {
+	return Config{
+		Tokens: tokens,
+		users:  newUserCache(repos.Users),
+		keys:   newKeyCache(repos.APIKeys),
+	}
+}
+
+func (cfg Config) checkJWT(c *gin.Context) (bool, error) {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false, nil
+	}
+	tokenString := strings.TrimPrefix(header, "Bearer ")
+	if strings.HasPrefix(tokenString, model.APIKeyPrefix) {
+		return false, nil
+	}
+
+	claims, err := cfg.Tokens.Verify(tokenString)
+	if err != nil {
+		log.Println("Rejected token: ", err)
+		return true, apperror.Unauthorized("Invalid token")
+	}
+
+	email := ClaimEmail(claims)
+	userID, roles, err := cfg.users.lookup(email)
+	if err != nil {
+		return true, err
+	}
+	if fromClaims := claimRoles(claims); len(fromClaims) > 0 {
+		roles = fromClaims
+	}
+
+	name, _ := claims["name"].(string)
+	avatar, _ := claims["avatar"].(string)
+
+	setPrincipal(c, &Principal{
+		Email:    email,
+		Name:     name,
+		Avatar:   avatar,
+		Roles:    roles,
+		Method:   JWT,
+		UserID:   userID,
+		Accounts: claimAccounts(claims),
+	})
+	return true, nil
+}
+
+// ClaimEmail returns the caller's email: the "id" claim set by our own
+// frontends, or the "email" claim of Supabase-issued tokens.
+func ClaimEmail(claims jwt.MapClaims) string {
+	if id, ok := claims["id"].(string); ok && strings.Contains(id, "@") {
+		return id
+	}
+	email, _ := claims["email"].(string)
+	return email
+}
+
+// claimRoles reads our roles from the "roles" claim, or a single "role".
+// Other values, such as Supabase's "authenticated", are ignored.
+func claimRoles(claims jwt.MapClaims) []string {
+	var values []interface{}
+	if list, ok := claims["roles"].([]interface{}); ok {
+		values = list
+	}
+	values = append(values, claims["role"])
+
+	var roles []string
+	for _, v := range values {
+		if role, ok := v.(string); ok {
+			switch role = strings.ToLower(role); role {
+			case RoleAdmin, RoleStaff, RoleExternal:
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+// claimAccounts reads Salesforce account Ids from the "accounts" claim, or a
+// single "account_id".
+func claimAccounts(claims jwt.MapClaims) []string {
+	var values []interface{}
+	if list, ok := claims["accounts"].([]interface{}); ok {
+		values = list
+	}
+	values = append(values, claims["account_id"])
+
+	var accounts []string
+	for _, v := range values {
+		if account, ok := v.(string); ok && account != "" {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
+}
+
+// checkAPIKey accepts a usable key holding every one of scopes.
+func (cfg Config) checkAPIKey(scopes []string) checker {
+	return func(c *gin.Context) (bool, error) {
+		secret := apiKeyFromHeader(c.GetHeader("Authorization"))
+		if secret == "" {
+			return false, nil
+		}
+
+		key, err := cfg.keys.lookup(model.HashAPIKey(secret))
+		if apperror.Is(err, apperror.CodeNotFound) {
+			return true, apperror.Unauthorized("Invalid API key")
+		}
+		if err != nil {
+			return true, err
+		}
+
+		now := time.Now()
+		if !key.Usable(now) {
+			return true, apperror.Unauthorized("API key is revoked or expired")
+		}
+		if !key.HasScopes(scopes...) {
+			return true, apperror.Forbidden("API key lacks the scopes: %v", scopes)
+		}
+
+		cfg.keys.touch(key.Id, now)
+
+		setPrincipal(c, &Principal{
+			Name:   key.Name,
+			Method: APIKey,
+			APIKey: key,
+		})
+		return true, nil
+	}
+}
+
+func apiKeyFromHeader(header string) string {
+	switch {
+	case strings.HasPrefix(header, "ApiKey "):
+		return strings.TrimPrefix(header, "ApiKey ")
+	case strings.HasPrefix(header, "Bearer "+model.APIKeyPrefix):
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return ""
+}
+
+func (cfg Config) checkBasic(c *gin.Context) (bool, error) {
+	user, pass, ok := c.Request.BasicAuth()
+	if !ok {
+		return false, nil
+	}
+
+	userOk := subtle.ConstantTimeCompare([]byte(user), []byte(cfg.BasicUser)) == 1
+	passOk := subtle.ConstantTimeCompare([]byte(pass), []byte(cfg.BasicPassword)) == 1
+	if !userOk || !passOk {
+		c.Header("WWW-Authenticate", `Basic realm="Restricted"`)
+		return true, apperror.Unauthorized("Unauthorized")
+	}
+
+	setPrincipal(c, &Principal{Name: user, Method: Basic})
+	return true, nil
+}
+
+// checkWebhook verifies "X-Signature-256: sha256=<hex HMAC of the body>" and
+// puts the body back for the handler.
+func (cfg Config) checkWebhook(c *gin.Context) (bool, error) {
+	signature := c.GetHeader("X-Signature-256")
+	if signature == "" {
+		return false, nil
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return true, apperror.Validation("Unable to read request body")
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return true, apperror.Unauthorized("Invalid signature")
+	}
+
+	mac := hmac.New(sha256.New, []byte(cfg.WebhookSecret))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return true, apperror.Unauthorized("Invalid signature")
+	}
+
+	setPrincipal(c, &Principal{Method: Webhook})
+	return true, nil
+}
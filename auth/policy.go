@@ -0,0 +1,135 @@
+// Package auth turns the auth policy each route declares into gin middleware.
+package auth
+
+import (
+	"fmt"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/gin-gonic/gin"
+)
+
+// Mode is a way a caller can prove who they are.
+type Mode string
+
+const (
+	// Public routes need no credentials.
+	Public Mode = "public"
+	// JWT expects a user token in "Authorization: Bearer", verified by
+	// Config.Tokens.
+	JWT Mode = "jwt"
+	// APIKey expects a key minted through /admin/api_keys, sent as
+	// "Authorization: ApiKey <key>" or "Authorization: Bearer <key>".
+	APIKey Mode = "api_key"
+	// Basic expects HTTP basic auth credentials.
+	Basic Mode = "basic"
+	// Webhook expects an HMAC-SHA256 signature of the body in X-Signature-256.
+	Webhook Mode = "webhook"
+)
+
+// Policy declares who may call a route. A request passes if it satisfies any
+// of Modes. Signed-in users must also have one of Roles when it is set, and
+// API keys must hold every one of Scopes.
+type Policy struct {
+	Modes  []Mode
+	Roles  []string
+	Scopes []string
+}
+
+// Allow returns a policy accepting any of modes.
+func Allow(modes ...Mode) Policy {
+	return Policy{Modes: modes}
+}
+
+// WithRoles returns a copy of p whose signed-in users need one of roles.
+func (p Policy) WithRoles(roles ...string) Policy {
+	p.Roles = roles
+	return p
+}
+
+// WithScopes returns a copy of p whose API keys must hold all of scopes.
+func (p Policy) WithScopes(scopes ...string) Policy {
+	p.Scopes = scopes
+	return p
+}
+
+// checker verifies one mode. It reports whether the request carried that
+// mode's credentials at all, and an error if they were rejected.
+type checker func(c *gin.Context) (presented bool, err error)
+
+// Middleware returns the handler enforcing p. It fails if p is empty, names an
+// unknown mode, requires roles without accepting user tokens, accepts API keys
+// without naming their scopes, or relies on a mode that cfg can't check.
+func (cfg Config) Middleware(p Policy) (gin.HandlerFunc, error) {
+	if len(p.Modes) == 0 {
+		return nil, fmt.Errorf("no auth modes declared")
+	}
+
+	checkers := make(map[Mode]checker)
+	acceptsBasic := false
+	for _, mode := range p.Modes {
+		switch mode {
+		case Public:
+			if len(p.Modes) > 1 || len(p.Roles) > 0 {
+				return nil, fmt.Errorf("public can't be combined with other modes or roles")
+			}
+			return func(c *gin.Context) { c.Next() }, nil
+		case JWT:
+			if cfg.Tokens == nil {
+				return nil, fmt.Errorf("neither JWKS_URL nor JWT_SECRET is set")
+			}
+			if cfg.users == nil {
+				return nil, fmt.Errorf("no user store configured to resolve roles")
+			}
+			checkers[mode] = cfg.checkJWT
+		case APIKey:
+			if len(p.Scopes) == 0 {
+				return nil, fmt.Errorf("api_key mode requires scopes")
+			}
+			if cfg.keys == nil {
+				return nil, fmt.Errorf("no API key store configured")
+			}
+			checkers[mode] = cfg.checkAPIKey(p.Scopes)
+		case Basic:
+			if cfg.BasicUser == "" || cfg.BasicPassword == "" {
+				return nil, fmt.Errorf("basic auth credentials are not set")
+			}
+			checkers[mode] = cfg.checkBasic
+			acceptsBasic = true
+		case Webhook:
+			if cfg.WebhookSecret == "" {
+				return nil, fmt.Errorf("WEBHOOK_SECRET is not set")
+			}
+			checkers[mode] = cfg.checkWebhook
+		default:
+			return nil, fmt.Errorf("unknown auth mode %q", mode)
+		}
+	}
+
+	if _, ok := checkers[JWT]; len(p.Roles) > 0 && !ok {
+		return nil, fmt.Errorf("roles require the jwt mode")
+	}
+
+	return func(c *gin.Context) {
+		for _, mode := range p.Modes {
+			presented, err := checkers[mode](c)
+			if !presented {
+				continue
+			}
+			if err != nil {
+				apperror.Respond(c, err)
+				return
+			}
+			if mode == JWT && !hasRole(c, p.Roles) {
+				apperror.Respond(c, apperror.Forbidden("Requires one of the roles: %v", p.Roles))
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if acceptsBasic {
+			c.Header("WWW-Authenticate", `Basic realm="Restricted"`)
+		}
+		apperror.Respond(c, apperror.Unauthorized("Authorization token not provided"))
+	}, nil
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"github.com/Proluxe/proluxe-common-api/model"
+	"github.com/gin-gonic/gin"
+)
+
+const principalKey = "Principal"
+
+// Principal is the authenticated caller, set on the request by the auth
+// middleware.
+type Principal struct {
+	Email  string
+	Name   string
+	Avatar string
+	Roles  []string
+	// Method is the auth mode the caller passed.
+	Method Mode
+	// UserID is the caller's rstk__syusr__c Id, when they have one.
+	UserID string
+	// Accounts lists the Salesforce accounts an external caller belongs to,
+	// from their token.
+	Accounts []string
+	// APIKey is the key the caller used, for API key callers.
+	APIKey *model.APIKey
+}
+
+// Actor names the caller in logs, records and notifications: their email,
+// or "<method>:<name>" for callers without one, e.g. "api_key:erp-sync".
+func (p *Principal) Actor() string {
+	switch {
+	case p.Email != "":
+		return p.Email
+	case p.Name != "":
+		return string(p.Method) + ":" + p.Name
+	default:
+		return string(p.Method)
+	}
+}
+
+// Grantee identifies the caller to folder grants.
+func (p *Principal) Grantee() model.Grantee {
+	who := model.Grantee{Email: p.Email, Roles: p.Roles, Accounts: p.Accounts}
+	if p.APIKey != nil {
+		who.APIKey = p.APIKey.Id
+	}
+	return who
+}
+
+// HasRole reports whether the caller has any of roles.
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, have := range p.Roles {
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CurrentPrincipal returns the caller of c. On public routes that is an
+// anonymous Principal whose Method is Public.
+func CurrentPrincipal(c *gin.Context) *Principal {
+	if p, ok := c.Get(principalKey); ok {
+		return p.(*Principal)
+	}
+	return &Principal{Method: Public}
+}
+
+func setPrincipal(c *gin.Context, p *Principal) {
+	c.Set(principalKey, p)
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/model"
+	"github.com/gin-gonic/gin"
+	u "github.com/scottraio/go-utils"
+)
+
+const (
+	RoleAdmin = "admin"
+	RoleStaff = "staff"
+	// RoleExternal is read-only, apart from the caller's own pinned links and
+	// settings.
+	RoleExternal = "external"
+)
+
+// IsAdmin reports whether the caller is a signed-in admin.
+func IsAdmin(c *gin.Context) bool {
+	return CurrentPrincipal(c).HasRole(RoleAdmin)
+}
+
+// CanModify reports whether the caller may change a record owned by owner
+// (an email address): only the owner and admins may. API keys own nothing.
+func CanModify(c *gin.Context, owner string) bool {
+	p := CurrentPrincipal(c)
+	if p.HasRole(RoleAdmin) {
+		return true
+	}
+	if p.Method != JWT {
+		return false
+	}
+	return owner != "" && strings.EqualFold(p.Email, owner)
+}
+
+// StaffAccessFromEnv reads STAFF_FOLDER_ACCESS, the permission the staff
+// role holds on the whole bucket on top of its folder grants: share by
+// default, so staff keep their access until grants are set up, or "none" to
+// limit staff to their grants.
+func StaffAccessFromEnv() (model.Permission, error) {
+	switch v := u.GetDotEnvVariable("STAFF_FOLDER_ACCESS"); v {
+	case "":
+		return model.PermissionShare, nil
+	case "none":
+		return "", nil
+	default:
+		if perm := model.Permission(v); perm.Valid() {
+			return perm, nil
+		}
+		return "", fmt.Errorf("STAFF_FOLDER_ACCESS: invalid permission %q, want read, write, share or none", v)
+	}
+}
+
+func hasRole(c *gin.Context, required []string) bool {
+	return len(required) == 0 || CurrentPrincipal(c).HasRole(required...)
+}
+
+const userCacheTTL = 5 * time.Minute
+
+// userCache resolves signed-in users to their rstk__syusr__c record, whose
+// CXP_Role__c field gives the roles of tokens that carry none.
+type userCache struct {
+	users model.UserRepository
+
+	mu      sync.Mutex
+	entries map[string]userEntry
+}
+
+type userEntry struct {
+	userID  string
+	roles   []string
+	fetched time.Time
+}
+
+func newUserCache(users model.UserRepository) *userCache {
+	return &userCache{users: users, entries: make(map[string]userEntry)}
+}
+
+// lookup returns the user Id and roles for email. Org users without a role
+// are staff; anyone without a user record is external and has no Id.
+func (uc *userCache) lookup(email string) (string, []string, error) {
+	if email == "" {
+		return "", []string{RoleExternal}, nil
+	}
+	key := strings.ToLower(email)
+
+	uc.mu.Lock()
+	entry, ok := uc.entries[key]
+	uc.mu.Unlock()
+	if ok && time.Since(entry.fetched) < userCacheTTL {
+		return entry.userID, entry.roles, nil
+	}
+
+	entry = userEntry{fetched: time.Now()}
+	user, err := uc.users.FindByEmail(email)
+	switch {
+	case apperror.Is(err, apperror.CodeNotFound):
+		entry.roles = []string{RoleExternal}
+	case err != nil:
+		return "", nil, err
+	case user.Role == "":
+		entry.userID = user.Id
+		entry.roles = []string{RoleStaff}
+	default:
+		entry.userID = user.Id
+		entry.roles = []string{strings.ToLower(user.Role)}
+	}
+
+	uc.mu.Lock()
+	uc.entries[key] = entry
+	uc.mu.Unlock()
+
+	return entry.userID, entry.roles, nil
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"crypto/elliptic"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// claimsAt returns claims for a token issued now that expires in an hour.
+func claimsAt(now time.Time, extra jwt.MapClaims) jwt.MapClaims {
+	claims := jwt.MapClaims{"id": "staff@proluxe.test", "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}
+	for k, v := range extra {
+		if v == nil {
+			delete(claims, k)
+			continue
+		}
+		claims[k] = v
+	}
+	return claims
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, rsaPub := rsaJWK(t, "rsa")
+	ecKey, ecPub := ecJWK(t, "ec", elliptic.P256())
+	server := newJWKSServer(t, rsaPub, ecPub)
+	secret, fallback := []byte("jwt-secret"), []byte("supabase-secret")
+
+	both := &TokenVerifier{JWKS: NewJWKS(server.URL), Secrets: [][]byte{secret, fallback}, Leeway: time.Minute}
+	jwksOnly := &TokenVerifier{JWKS: NewJWKS(server.URL), Leeway: time.Minute}
+	secretOnly := &TokenVerifier{Secrets: [][]byte{secret}, Leeway: time.Minute}
+	lists := &TokenVerifier{
+		Secrets:   [][]byte{secret},
+		Issuers:   []string{"https://auth.proluxe.test", "https://login.proluxe.test"},
+		Audiences: []string{"cxp", "crm"},
+	}
+
+	now := time.Now()
+	rs256 := sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claimsAt(now, nil))
+	hs256 := sign(t, jwt.SigningMethodHS256, "", secret, claimsAt(now, nil))
+
+	tests := []struct {
+		name     string
+		verifier *TokenVerifier
+		token    string
+		wantErr  string
+	}{
+		{"RS256", both, rs256, ""},
+		{"ES256", both, sign(t, jwt.SigningMethodES256, "ec", ecKey, claimsAt(now, nil)), ""},
+		{"HS256 with the first secret", both, hs256, ""},
+		{"HS256 falls back to the next secret", both, sign(t, jwt.SigningMethodHS256, "", fallback, claimsAt(now, nil)), ""},
+		{"HS256 with an unknown secret", both, sign(t, jwt.SigningMethodHS256, "", []byte("other"), claimsAt(now, nil)), "signature is invalid"},
+		{"RS256 without a JWKS", secretOnly, rs256, "signing method RS256 is invalid"},
+		{"HS256 without secrets", jwksOnly, hs256, "signing method HS256 is invalid"},
+		// An HS256 token keyed with the public RSA key mustn't pass as RS256.
+		{"HS256 keyed with the public RSA key", both, sign(t, jwt.SigningMethodHS256, "rsa", []byte(rsaPub.N), claimsAt(now, nil)), "signature is invalid"},
+		{"ES256 naming an RSA key", both, sign(t, jwt.SigningMethodES256, "rsa", ecKey, claimsAt(now, nil)), "key is of invalid type"},
+		{"RS256 naming an EC key", both, sign(t, jwt.SigningMethodRS256, "ec", rsaKey, claimsAt(now, nil)), "key is of invalid type"},
+		{"RS384", both, sign(t, jwt.SigningMethodRS384, "rsa", rsaKey, claimsAt(now, nil)), "signing method RS384 is invalid"},
+		{"alg none", both, sign(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, claimsAt(now, nil)), "signing method none is invalid"},
+		{"unknown kid", both, sign(t, jwt.SigningMethodRS256, "gone", rsaKey, claimsAt(now, nil)), "unknown key id"},
+
+		{"no exp", both, sign(t, jwt.SigningMethodHS256, "", secret, claimsAt(now, jwt.MapClaims{"exp": nil})), "exp claim is required"},
+		{"expired within leeway", both, sign(t, jwt.SigningMethodHS256, "", secret, claimsAt(now, jwt.MapClaims{"exp": now.Add(-30 * time.Second).Unix()})), ""},
+		{"expired past leeway", both, sign(t, jwt.SigningMethodHS256, "", secret, claimsAt(now, jwt.MapClaims{"exp": now.Add(-2 * time.Minute).Unix()})), "token is expired"},
+		{"issued slightly in the future", both, sign(t, jwt.SigningMethodHS256, "", secret, claimsAt(now, jwt.MapClaims{"iat": now.Add(30 * time.Second).Unix()})), ""},
+		{"issued well in the future", both, sign(t, jwt.SigningMethodHS256, "", secret, claimsAt(now, jwt.MapClaims{"iat": now.Add(time.Hour).Unix()})), "token used before issued"},
+		{"not valid yet", both, sign(t, jwt.SigningMethodHS256, "", secret, claimsAt(now, jwt.MapClaims{"nbf": now.Add(time.Hour).Unix()})), "token is not valid yet"},
+
+		{"listed issuer and audience", lists, sign(t, jwt.SigningMethodHS256, "", secret, claimsAt(now, jwt.MapClaims{"iss": "https://login.proluxe.test", "aud": "crm"})), ""},
+		{"one of several audiences listed", lists, sign(t, jwt.SigningMethodHS256, "", secret, claimsAt(now, jwt.MapClaims{"iss": "https://auth.proluxe.test", "aud": []string{"billing", "cxp"}})), ""},
+		{"unlisted issuer", lists, sign(t, jwt.SigningMethodHS256, "", secret, claimsAt(now, jwt.MapClaims{"iss": "https://evil.test", "aud": "cxp"})), "issuer \"https://evil.test\" is not accepted"},
+		{"no issuer", lists, sign(t, jwt.SigningMethodHS256, "", secret, claimsAt(now, jwt.MapClaims{"aud": "cxp"})), "is not accepted"},
+		{"unlisted audience", lists, sign(t, jwt.SigningMethodHS256, "", secret, claimsAt(now, jwt.MapClaims{"iss": "https://auth.proluxe.test", "aud": "billing"})), "audience [billing] is not accepted"},
+		{"no audience", lists, sign(t, jwt.SigningMethodHS256, "", secret, claimsAt(now, jwt.MapClaims{"iss": "https://auth.proluxe.test"})), "is not accepted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.verifier.Verify(tt.token)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Verify: %v", err)
+				}
+				if claims["id"] != "staff@proluxe.test" {
+					t.Errorf("claims = %v, want the signed ones", claims)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Verify = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package blob
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/Proluxe/proluxe-common-api/model"
+	u "github.com/scottraio/go-utils"
+)
+
+const (
+	defaultMaxArchiveBytes = 10 << 30
+	defaultMaxArchiveFiles = 10000
+)
+
+// ArchiveLimitsFromEnv reads ARCHIVE_MAX_BYTES (10 GiB by default) and
+// ARCHIVE_MAX_FILES (10,000 by default). Zero lifts a limit.
+func ArchiveLimitsFromEnv() (model.ArchiveLimits, error) {
+	limits := model.ArchiveLimits{MaxBytes: defaultMaxArchiveBytes, MaxFiles: defaultMaxArchiveFiles}
+
+	if v := u.GetDotEnvVariable("ARCHIVE_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return limits, fmt.Errorf("ARCHIVE_MAX_BYTES: invalid size %q", v)
+		}
+		limits.MaxBytes = n
+	}
+	if v := u.GetDotEnvVariable("ARCHIVE_MAX_FILES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return limits, fmt.Errorf("ARCHIVE_MAX_FILES: invalid count %q", v)
+		}
+		limits.MaxFiles = n
+	}
+	return limits, nil
+}
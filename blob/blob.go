@@ -0,0 +1,47 @@
+// Package blob implements model.BlobStore on Cloud Storage and on local disk.
+package blob
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"strings"
+
+	"github.com/Proluxe/proluxe-common-api/model"
+	u "github.com/scottraio/go-utils"
+)
+
+const defaultBucket = "common_production"
+
+// FromEnv opens the store chosen by BLOB_STORE: "gcs" (the default) uses the
+// GCS_BUCKET bucket, "local" keeps objects under BLOB_DIR and serves them
+// back through the API at BLOB_BASE_URL, signing links with
+// BLOB_SIGNING_KEY (random per process if unset).
+func FromEnv(ctx context.Context) (model.BlobStore, error) {
+	switch strings.ToLower(u.GetDotEnvVariable("BLOB_STORE")) {
+	case "", "gcs":
+		bucket := u.GetDotEnvVariable("GCS_BUCKET")
+		if bucket == "" {
+			bucket = defaultBucket
+		}
+		return NewGCS(ctx, bucket)
+	case "local":
+		dir := u.GetDotEnvVariable("BLOB_DIR")
+		if dir == "" {
+			dir = "blobs"
+		}
+		baseURL := u.GetDotEnvVariable("BLOB_BASE_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:" + u.GetDotEnvVariable("PORT")
+		}
+		key := []byte(u.GetDotEnvVariable("BLOB_SIGNING_KEY"))
+		if len(key) == 0 {
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return nil, err
+			}
+		}
+		return NewLocal(dir, baseURL, key)
+	}
+	return nil, fmt.Errorf("unknown BLOB_STORE %q", u.GetDotEnvVariable("BLOB_STORE"))
+}
@@ -0,0 +1,235 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/model"
+)
+
+// GCS stores objects in a Cloud Storage bucket through one shared client.
+type GCS struct {
+	Client *storage.Client
+	Name   string
+}
+
+func NewGCS(ctx context.Context, bucket string) (*GCS, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating storage client: %w", err)
+	}
+	return &GCS{Client: client, Name: bucket}, nil
+}
+
+func (g *GCS) Bucket() string {
+	return g.Name
+}
+
+func (g *GCS) object(name string) *storage.ObjectHandle {
+	return g.Client.Bucket(g.Name).Object(name)
+}
+
+func (g *GCS) List(ctx context.Context, q model.BlobQuery) ([]model.BlobAttrs, error) {
+	// StartOffset is inclusive, so StartAfter itself is skipped below. The
+	// full projection brings back ACLs, which tell public objects apart.
+	query := &storage.Query{Prefix: q.Prefix, Delimiter: q.Delimiter, StartOffset: q.StartAfter, Projection: storage.ProjectionFull}
+	it := g.Client.Bucket(g.Name).Objects(ctx, query)
+
+	var list []model.BlobAttrs
+	for q.Limit == 0 || len(list) < q.Limit {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if q.StartAfter != "" && attrs.Name+attrs.Prefix == q.StartAfter {
+			continue
+		}
+		list = append(list, gcsAttrs(attrs))
+	}
+	return list, nil
+}
+
+func (g *GCS) Attrs(ctx context.Context, name string) (*model.BlobAttrs, error) {
+	attrs, err := g.object(name).Attrs(ctx)
+	if err != nil {
+		return nil, gcsError(err, name)
+	}
+	a := gcsAttrs(attrs)
+	return &a, nil
+}
+
+func (g *GCS) NewRangeReader(ctx context.Context, name string, offset, length int64) (io.ReadCloser, *model.BlobAttrs, error) {
+	r, err := g.object(name).NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, nil, gcsError(err, name)
+	}
+	return r, &model.BlobAttrs{
+		Name:        name,
+		Size:        r.Attrs.Size,
+		ContentType: r.Attrs.ContentType,
+		Updated:     r.Attrs.LastModified,
+		Generation:  r.Attrs.Generation,
+	}, nil
+}
+
+func (g *GCS) Write(ctx context.Context, name string, r io.Reader, opts model.BlobWriteOptions) (*model.BlobAttrs, error) {
+	// Cancelling the writer's context abandons the upload, so a failed read
+	// never leaves a truncated object behind.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := g.object(name).NewWriter(ctx)
+	w.ContentType = opts.ContentType
+	w.Metadata = opts.Metadata
+	w.MD5 = opts.MD5
+	if opts.CRC32C != nil {
+		w.CRC32C = *opts.CRC32C
+		w.SendCRC32C = true
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	a := gcsAttrs(w.Attrs())
+	return &a, nil
+}
+
+func (g *GCS) Delete(ctx context.Context, name string) error {
+	return gcsError(g.object(name).Delete(ctx), name)
+}
+
+func (g *GCS) Copy(ctx context.Context, src, dst string) (*model.BlobAttrs, error) {
+	attrs, err := g.object(dst).CopierFrom(g.object(src)).Run(ctx)
+	if err != nil {
+		return nil, gcsError(err, src)
+	}
+	a := gcsAttrs(attrs)
+	return &a, nil
+}
+
+func (g *GCS) Versions(ctx context.Context, name string) ([]model.BlobAttrs, error) {
+	it := g.Client.Bucket(g.Name).Objects(ctx, &storage.Query{Prefix: name, Versions: true})
+
+	var list []model.BlobAttrs
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attrs.Name == name {
+			list = append(list, gcsAttrs(attrs))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Generation > list[j].Generation })
+	return list, nil
+}
+
+func (g *GCS) NewVersionReader(ctx context.Context, name string, generation, offset, length int64) (io.ReadCloser, *model.BlobAttrs, error) {
+	r, err := g.object(name).Generation(generation).NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, nil, gcsError(err, name)
+	}
+	return r, &model.BlobAttrs{
+		Name:        name,
+		Size:        r.Attrs.Size,
+		ContentType: r.Attrs.ContentType,
+		Updated:     r.Attrs.LastModified,
+		Generation:  r.Attrs.Generation,
+	}, nil
+}
+
+func (g *GCS) CopyVersion(ctx context.Context, src string, generation int64, dst string) (*model.BlobAttrs, error) {
+	attrs, err := g.object(dst).CopierFrom(g.object(src).Generation(generation)).Run(ctx)
+	if err != nil {
+		return nil, gcsError(err, src)
+	}
+	a := gcsAttrs(attrs)
+	return &a, nil
+}
+
+func (g *GCS) UpdateMetadata(ctx context.Context, name string, metadata map[string]string) (*model.BlobAttrs, error) {
+	attrs, err := g.object(name).Update(ctx, storage.ObjectAttrsToUpdate{Metadata: metadata})
+	if err != nil {
+		return nil, gcsError(err, name)
+	}
+	a := gcsAttrs(attrs)
+	return &a, nil
+}
+
+func (g *GCS) SetPublic(ctx context.Context, name string, public bool) error {
+	acl := g.object(name).ACL()
+	if public {
+		return gcsError(acl.Set(ctx, storage.AllUsers, storage.RoleReader), name)
+	}
+	return gcsError(acl.Delete(ctx, storage.AllUsers), name)
+}
+
+func (g *GCS) PublicURL(name string) string {
+	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", g.Name, name)
+}
+
+// SignedURL signs a V4 URL with the client's credentials, or through the IAM
+// signBlob API when they hold no private key.
+func (g *GCS) SignedURL(name string, opts model.SignedURLOptions) (string, error) {
+	signOpts := &storage.SignedURLOptions{
+		Scheme:  storage.SigningSchemeV4,
+		Method:  http.MethodGet,
+		Expires: opts.Expires,
+	}
+	if opts.Filename != "" {
+		signOpts.QueryParameters = url.Values{
+			"response-content-disposition": {model.ContentDisposition("attachment", opts.Filename)},
+		}
+	}
+	return g.Client.Bucket(g.Name).SignedURL(name, signOpts)
+}
+
+func gcsAttrs(attrs *storage.ObjectAttrs) model.BlobAttrs {
+	a := model.BlobAttrs{
+		Name:        attrs.Name,
+		Prefix:      attrs.Prefix,
+		Size:        attrs.Size,
+		ContentType: attrs.ContentType,
+		Created:     attrs.Created,
+		Updated:     attrs.Updated,
+		Generation:  attrs.Generation,
+		MD5:         attrs.MD5,
+		CRC32C:      attrs.CRC32C,
+		Metadata:    attrs.Metadata,
+		Archived:    attrs.Deleted,
+	}
+	for _, rule := range attrs.ACL {
+		if rule.Entity == storage.AllUsers {
+			a.Public = true
+			break
+		}
+	}
+	return a
+}
+
+func gcsError(err error, name string) error {
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return apperror.NotFound("File %s not found", name)
+	}
+	return err
+}
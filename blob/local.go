@@ -0,0 +1,425 @@
+package blob
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/model"
+)
+
+// Local stores objects on disk under Dir, for running the files API in dev
+// and CI without Cloud Storage. Object names are escaped into flat file
+// names so that folder placeholders like "a/b/" can be stored too; each
+// object's attributes sit next to it in a JSON file. Like a bucket with
+// versioning on, it keeps every overwritten or deleted generation, under
+// Dir/versions.
+type Local struct {
+	Dir string
+	// BaseURL is the API's own address. Public and signed URLs point at its
+	// download routes.
+	BaseURL string
+	// SigningKey signs the URLs returned by SignedURL.
+	SigningKey []byte
+
+	mu sync.Mutex
+}
+
+func NewLocal(dir, baseURL string, signingKey []byte) (*Local, error) {
+	for _, sub := range []string{"objects", "attrs", "versions"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &Local{Dir: dir, BaseURL: baseURL, SigningKey: signingKey}, nil
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func (l *Local) Bucket() string {
+	return l.Dir
+}
+
+func (l *Local) dataPath(name string) string {
+	return filepath.Join(l.Dir, "objects", url.PathEscape(name))
+}
+
+func (l *Local) attrsPath(name string) string {
+	return filepath.Join(l.Dir, "attrs", url.PathEscape(name)+".json")
+}
+
+func (l *Local) versionDir(name string) string {
+	return filepath.Join(l.Dir, "versions", url.PathEscape(name))
+}
+
+func (l *Local) versionPath(name string, generation int64) string {
+	return filepath.Join(l.versionDir(name), strconv.FormatInt(generation, 10))
+}
+
+func (l *Local) readAttrs(name string) (*model.BlobAttrs, error) {
+	b, err := os.ReadFile(l.attrsPath(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, apperror.NotFound("File %s not found", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var a model.BlobAttrs
+	if err := json.Unmarshal(b, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (l *Local) writeAttrs(a *model.BlobAttrs) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(l.attrsPath(a.Name), b, 0o644)
+}
+
+func (l *Local) List(ctx context.Context, q model.BlobQuery) ([]model.BlobAttrs, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries, err := os.ReadDir(filepath.Join(l.Dir, "attrs"))
+	if err != nil {
+		return nil, err
+	}
+
+	var list []model.BlobAttrs
+	prefixes := make(map[string]bool)
+	for _, entry := range entries {
+		name, err := url.PathUnescape(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil || !strings.HasPrefix(name, q.Prefix) {
+			continue
+		}
+
+		rest := name[len(q.Prefix):]
+		if i := strings.Index(rest, q.Delimiter); q.Delimiter != "" && i >= 0 {
+			prefix := q.Prefix + rest[:i+len(q.Delimiter)]
+			if !prefixes[prefix] {
+				prefixes[prefix] = true
+				list = append(list, model.BlobAttrs{Prefix: prefix})
+			}
+			continue
+		}
+
+		a, err := l.readAttrs(name)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *a)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name+list[i].Prefix < list[j].Name+list[j].Prefix
+	})
+	if q.StartAfter != "" {
+		n := sort.Search(len(list), func(i int) bool { return list[i].Name+list[i].Prefix > q.StartAfter })
+		list = list[n:]
+	}
+	if q.Limit > 0 && len(list) > q.Limit {
+		list = list[:q.Limit]
+	}
+	return list, nil
+}
+
+func (l *Local) Attrs(ctx context.Context, name string) (*model.BlobAttrs, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.readAttrs(name)
+}
+
+func (l *Local) NewRangeReader(ctx context.Context, name string, offset, length int64) (io.ReadCloser, *model.BlobAttrs, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, err := l.readAttrs(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return openRange(l.dataPath(name), a, offset, length)
+}
+
+func openRange(dataPath string, a *model.BlobAttrs, offset, length int64) (io.ReadCloser, *model.BlobAttrs, error) {
+	f, err := os.Open(dataPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if length < 0 {
+		return f, a, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, a, nil
+}
+
+func (l *Local) Write(ctx context.Context, name string, r io.Reader, opts model.BlobWriteOptions) (*model.BlobAttrs, error) {
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(name))
+	}
+	br := bufio.NewReader(r)
+	if contentType == "" {
+		head, _ := br.Peek(512)
+		contentType = http.DetectContentType(head)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(l.Dir, "objects"), ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	md5sum, crc := md5.New(), crc32.New(crc32cTable)
+	size, err := io.Copy(io.MultiWriter(tmp, md5sum, crc), br)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.MD5 != nil && !bytes.Equal(opts.MD5, md5sum.Sum(nil)) || opts.CRC32C != nil && *opts.CRC32C != crc.Sum32() {
+		return nil, apperror.Validation("Checksum mismatch for %s", name)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now().UTC()
+	a := &model.BlobAttrs{
+		Name:        name,
+		Size:        size,
+		ContentType: contentType,
+		Created:     now,
+		Updated:     now,
+		Generation:  now.UnixNano(),
+		MD5:         md5sum.Sum(nil),
+		CRC32C:      crc.Sum32(),
+		Metadata:    opts.Metadata,
+	}
+	if old, err := l.readAttrs(name); err == nil {
+		if err := l.archive(old); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), l.dataPath(name)); err != nil {
+		return nil, err
+	}
+	if err := l.writeAttrs(a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (l *Local) Delete(ctx context.Context, name string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, err := l.readAttrs(name)
+	if err != nil {
+		return err
+	}
+	if err := l.archive(a); err != nil {
+		return err
+	}
+	return os.Remove(l.attrsPath(name))
+}
+
+// archive moves the live generation described by a into the versions
+// directory. The caller holds l.mu.
+func (l *Local) archive(a *model.BlobAttrs) error {
+	if err := os.MkdirAll(l.versionDir(a.Name), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(l.dataPath(a.Name), l.versionPath(a.Name, a.Generation)); err != nil {
+		return err
+	}
+	archived := *a
+	archived.Archived = time.Now().UTC()
+	b, err := json.Marshal(&archived)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(l.versionPath(a.Name, a.Generation)+".json", b, 0o644)
+}
+
+func (l *Local) Copy(ctx context.Context, src, dst string) (*model.BlobAttrs, error) {
+	r, a, err := l.NewRangeReader(ctx, src, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return l.Write(ctx, dst, r, model.BlobWriteOptions{ContentType: a.ContentType, Metadata: a.Metadata})
+}
+
+func (l *Local) Versions(ctx context.Context, name string) ([]model.BlobAttrs, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var list []model.BlobAttrs
+	if a, err := l.readAttrs(name); err == nil {
+		list = append(list, *a)
+	}
+
+	entries, err := os.ReadDir(l.versionDir(name))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(l.versionDir(name), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var a model.BlobAttrs
+		if err := json.Unmarshal(b, &a); err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Generation > list[j].Generation })
+	return list, nil
+}
+
+func (l *Local) NewVersionReader(ctx context.Context, name string, generation, offset, length int64) (io.ReadCloser, *model.BlobAttrs, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if a, err := l.readAttrs(name); err == nil && a.Generation == generation {
+		return openRange(l.dataPath(name), a, offset, length)
+	}
+
+	b, err := os.ReadFile(l.versionPath(name, generation) + ".json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, apperror.NotFound("Version %d of %s not found", generation, name)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var a model.BlobAttrs
+	if err := json.Unmarshal(b, &a); err != nil {
+		return nil, nil, err
+	}
+	return openRange(l.versionPath(name, generation), &a, offset, length)
+}
+
+func (l *Local) CopyVersion(ctx context.Context, src string, generation int64, dst string) (*model.BlobAttrs, error) {
+	r, a, err := l.NewVersionReader(ctx, src, generation, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return l.Write(ctx, dst, r, model.BlobWriteOptions{ContentType: a.ContentType, Metadata: a.Metadata})
+}
+
+func (l *Local) UpdateMetadata(ctx context.Context, name string, metadata map[string]string) (*model.BlobAttrs, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, err := l.readAttrs(name)
+	if err != nil {
+		return nil, err
+	}
+	if a.Metadata == nil {
+		a.Metadata = make(map[string]string)
+	}
+	for k, v := range metadata {
+		if v == "" {
+			delete(a.Metadata, k)
+		} else {
+			a.Metadata[k] = v
+		}
+	}
+	a.Updated = time.Now().UTC()
+	return a, l.writeAttrs(a)
+}
+
+func (l *Local) SetPublic(ctx context.Context, name string, public bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, err := l.readAttrs(name)
+	if err != nil {
+		return err
+	}
+	a.Public = public
+	return l.writeAttrs(a)
+}
+
+func (l *Local) PublicURL(name string) string {
+	return l.BaseURL + "/files/download/" + escapeName(name)
+}
+
+// SignedURL returns a link to the API's /files/signed route, which checks
+// it with VerifySignedURL.
+func (l *Local) SignedURL(name string, opts model.SignedURLOptions) (string, error) {
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(opts.Expires.Unix(), 10))
+	if opts.Filename != "" {
+		q.Set("filename", opts.Filename)
+	}
+	q.Set("signature", l.sign(name, q.Get("expires"), opts.Filename))
+
+	return l.BaseURL + "/files/signed/" + escapeName(name) + "?" + q.Encode(), nil
+}
+
+// VerifySignedURL checks the query of a URL made by SignedURL and returns the
+// download filename it asked for.
+func (l *Local) VerifySignedURL(name string, q url.Values) (string, error) {
+	want := l.sign(name, q.Get("expires"), q.Get("filename"))
+	if !hmac.Equal([]byte(want), []byte(q.Get("signature"))) {
+		return "", apperror.Forbidden("Invalid link")
+	}
+
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", apperror.Forbidden("This link has expired")
+	}
+	return q.Get("filename"), nil
+}
+
+func (l *Local) sign(name, expires, filename string) string {
+	mac := hmac.New(sha256.New, l.SigningKey)
+	mac.Write([]byte(name + "\n" + expires + "\n" + filename))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func escapeName(name string) string {
+	return (&url.URL{Path: name}).EscapedPath()
+}
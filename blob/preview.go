@@ -0,0 +1,48 @@
+package blob
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/model"
+	u "github.com/scottraio/go-utils"
+)
+
+const defaultMaxPreviewSourceBytes = 100 << 20
+
+// PreviewSettingsFromEnv reads PREVIEW_MAX_SOURCE_BYTES (100 MiB by
+// default, zero for no limit), PREVIEW_PDFTOPPM, the pdftoppm binary PDF
+// previews are rendered with ("pdftoppm" on the PATH by default, "off" to
+// turn PDF previews off), and PREVIEW_RENDER_TIMEOUT, how long making a
+// file's previews may take (2 minutes by default).
+func PreviewSettingsFromEnv() (model.PreviewSettings, error) {
+	settings := model.PreviewSettings{
+		MaxSourceBytes: defaultMaxPreviewSourceBytes,
+		PDFRenderer:    "pdftoppm",
+		RenderTimeout:  model.DefaultPreviewRenderTimeout,
+	}
+
+	if v := u.GetDotEnvVariable("PREVIEW_MAX_SOURCE_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return settings, fmt.Errorf("PREVIEW_MAX_SOURCE_BYTES: invalid size %q", v)
+		}
+		settings.MaxSourceBytes = n
+	}
+	if v := u.GetDotEnvVariable("PREVIEW_RENDER_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return settings, fmt.Errorf("PREVIEW_RENDER_TIMEOUT: invalid duration %q", v)
+		}
+		settings.RenderTimeout = d
+	}
+	switch v := u.GetDotEnvVariable("PREVIEW_PDFTOPPM"); v {
+	case "":
+	case "off":
+		settings.PDFRenderer = ""
+	default:
+		settings.PDFRenderer = v
+	}
+	return settings, nil
+}
@@ -0,0 +1,29 @@
+package blob
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/model"
+	u "github.com/scottraio/go-utils"
+)
+
+// TrashSettingsFromEnv reads TRASH_RETENTION, how long deleted files are
+// kept (30 days by default), and TRASH_PURGE_INTERVAL, how often expired
+// trash is purged in the background (daily by default, "0" to leave it to
+// POST /files/trash/purge_expired).
+func TrashSettingsFromEnv() (retention, purgeInterval time.Duration, err error) {
+	retention, purgeInterval = model.DefaultTrashRetention, 24*time.Hour
+
+	if v := u.GetDotEnvVariable("TRASH_RETENTION"); v != "" {
+		if retention, err = time.ParseDuration(v); err != nil || retention <= 0 {
+			return 0, 0, fmt.Errorf("TRASH_RETENTION: invalid duration %q", v)
+		}
+	}
+	if v := u.GetDotEnvVariable("TRASH_PURGE_INTERVAL"); v != "" {
+		if purgeInterval, err = time.ParseDuration(v); err != nil || purgeInterval < 0 {
+			return 0, 0, fmt.Errorf("TRASH_PURGE_INTERVAL: invalid duration %q", v)
+		}
+	}
+	return retention, purgeInterval, nil
+}
@@ -0,0 +1,34 @@
+package blob
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Proluxe/proluxe-common-api/model"
+	u "github.com/scottraio/go-utils"
+)
+
+const defaultMaxUploadBytes = 5 << 30
+
+// UploadPolicyFromEnv reads UPLOAD_MAX_BYTES (5 GiB by default) and
+// UPLOAD_ALLOWED_TYPES, a comma separated list such as
+// "image/*,application/pdf". Leaving the list unset allows any type.
+func UploadPolicyFromEnv() (model.UploadPolicy, error) {
+	policy := model.UploadPolicy{MaxBytes: defaultMaxUploadBytes}
+
+	if max := u.GetDotEnvVariable("UPLOAD_MAX_BYTES"); max != "" {
+		n, err := strconv.ParseInt(max, 10, 64)
+		if err != nil || n < 0 {
+			return policy, fmt.Errorf("UPLOAD_MAX_BYTES: invalid size %q", max)
+		}
+		policy.MaxBytes = n
+	}
+
+	for _, t := range strings.Split(u.GetDotEnvVariable("UPLOAD_ALLOWED_TYPES"), ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			policy.AllowedTypes = append(policy.AllowedTypes, t)
+		}
+	}
+	return policy, nil
+}
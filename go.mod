@@ -6,9 +6,9 @@ require (
 	cloud.google.com/go v0.115.1
 	cloud.google.com/go/bigquery v1.62.0
 	cloud.google.com/go/storage v1.43.0
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gin-contrib/cors v1.7.4
 	github.com/gin-gonic/gin v1.10.0
+	github.com/golang-jwt/jwt/v5 v5.3.1
 	github.com/knocklabs/knock-go v0.1.20
 	github.com/mattevans/postmark-go v1.0.0
 	github.com/rollbar/rollbar-go v1.4.6
@@ -17,6 +17,7 @@ require (
 	github.com/scottraio/simpleforce v0.0.0-20250410061729-b3731d135fee
 	github.com/supabase-community/supabase-go v0.0.4
 	golang.org/x/oauth2 v0.23.0
+	golang.org/x/sync v0.12.0
 	google.golang.org/api v0.199.0
 )
 
@@ -78,7 +79,6 @@ require (
 	golang.org/x/exp v0.0.0-20231006140011-7918f672742d // indirect
 	golang.org/x/mod v0.18.0 // indirect
 	golang.org/x/net v0.37.0 // indirect
-	golang.org/x/sync v0.12.0 // indirect
 	golang.org/x/sys v0.31.0 // indirect
 	golang.org/x/text v0.23.0 // indirect
 	golang.org/x/time v0.6.0 // indirect
@@ -1,179 +1,264 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"log"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/Proluxe/proluxe-common-api/api"
+	"github.com/Proluxe/proluxe-common-api/audit"
+	"github.com/Proluxe/proluxe-common-api/auth"
+	"github.com/Proluxe/proluxe-common-api/blob"
+	"github.com/Proluxe/proluxe-common-api/model"
 	"github.com/Proluxe/proluxe-common-api/salesforce"
+	"github.com/Proluxe/proluxe-common-api/scanner"
 	"github.com/Proluxe/proluxe-common-api/services"
 	"github.com/Proluxe/proluxe-common-api/util"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	u "github.com/scottraio/go-utils"
 )
 
-// JWT middleware
-func JWTAuthMiddleware(secret string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		if c.Request.Method == "OPTIONS" ||
-			c.Request.URL.Path == "/" ||
-			c.Request.URL.Path == "/notify/build_order_changes" ||
-			c.Request.URL.Path == "/mrp/timeline/prototype" ||
-			c.Request.URL.Path == "/webhook/leads" ||
-			c.Request.URL.Path == "/algolia/products" ||
-			c.Request.URL.Path == "/algolia/contacts" ||
-			c.Request.URL.Path == "/algolia/parts" ||
-			c.Request.URL.Path == "/algolia/customers" {
-			c.Next()
-			return
-		}
+func main() {
+	router := gin.Default()
 
-		apiKey := c.Query("api_key")
-		if apiKey == u.GetDotEnvVariable("EXTERNAL_API_KEY") {
-			c.Next()
-			return
-		}
+	// Tag each request with an ID for logs and error responses
+	router.Use(services.RequestID())
 
-		tokenString := c.GetHeader("Authorization")
-		if tokenString == "" {
-			fmt.Println("Authorization token not provided")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization token not provided"})
-			return
-		}
+	// Setup error handling middleware
+	router.Use(services.ErrorHandling())
 
-		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+	// Setup CORS
+	config := cors.DefaultConfig()
+	config.AllowHeaders = []string{"*"}
+	config.AllowOrigins = []string{"*"}
+	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	config.ExposeHeaders = []string{"Location", "Upload-Offset", "Upload-Length", "ETag", "Content-Range", "Content-Disposition"}
+	router.Use(cors.New(config))
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(secret), nil
-		})
+	blobs, err := blob.FromEnv(context.Background())
+	if err != nil {
+		log.Fatalf("Invalid blob store configuration: %v", err)
+	}
 
-		if err != nil || !token.Valid {
-			fmt.Println("Invalid token")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			return
-		}
+	uploads, err := blob.UploadPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid upload configuration: %v", err)
+	}
+	if uploads.Scanner, err = scanner.FromEnv(); err != nil {
+		log.Fatalf("Invalid scanner configuration: %v", err)
+	}
 
-		claims := token.Claims.(jwt.MapClaims)
-		fmt.Printf("User: %v\n", claims)
-		c.Set("User", claims)
+	trashRetention, trashPurgeInterval, err := blob.TrashSettingsFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid trash configuration: %v", err)
+	}
 
-		c.Next()
+	archives, err := blob.ArchiveLimitsFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid archive configuration: %v", err)
 	}
-}
 
-// Basic Auth middleware for specific routes
-func BasicAuthMiddleware(username, password string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		user, pass, hasAuth := c.Request.BasicAuth()
-		if !hasAuth || user != username || pass != password {
-			c.Header("WWW-Authenticate", `Basic realm="Restricted"`)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-			return
-		}
-		c.Next()
+	previews, err := blob.PreviewSettingsFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid preview configuration: %v", err)
 	}
-}
 
-func main() {
-	router := gin.Default()
+	staffAccess, err := auth.StaffAccessFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid folder grant configuration: %v", err)
+	}
 
-	// Setup JWT middleware
-	secret := u.GetDotEnvVariable("JWT_SECRET")
-	router.Use(JWTAuthMiddleware(secret))
+	activity, err := audit.FromEnv(context.Background())
+	if err != nil {
+		log.Fatalf("Invalid audit log configuration: %v", err)
+	}
 
-	// Setup error handling middleware
-	router.Use(services.ErrorHandling())
+	SF := salesforce.NewSF()
+	app := util.App{
+		SF:              SF,
+		Repositories:    model.NewSalesforceRepositories(SF),
+		Blobs:           blobs,
+		Uploads:         uploads,
+		TrashRetention:  trashRetention,
+		Archives:        archives,
+		Previews:        previews,
+		StaffAccess:     staffAccess,
+		Activity:        activity,
+		RepositoriesFor: model.NewSalesforceRepositories,
+	}
 
-	// Setup CORS
-	config := cors.DefaultConfig()
-	config.AllowHeaders = []string{"*"}
-	config.AllowOrigins = []string{"*"}
-	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	router.Use(cors.New(config))
+	authConfig, err := auth.ConfigFromEnv(app.Repositories)
+	if err != nil {
+		log.Fatalf("Invalid auth configuration: %v", err)
+	}
 
-	SF := salesforce.NewSF()
-	app := util.App{SF: SF}
-
-	// Health Check
-	router.GET("/", apiRoute(StatusOk, &app))
-
-	// Algolia Products (Basic Auth)
-	basicUsername := u.GetDotEnvVariable("ALGOLIA_AUTH_USER")
-	basicPassword := u.GetDotEnvVariable("ALGOLIA_AUTH_PASS")
-
-	router.GET("/algolia/products",
-		BasicAuthMiddleware(basicUsername, basicPassword),
-		apiRoute(api.GET_ALGOLIA_PRODUCTS, &app),
-	)
-
-	router.GET("/algolia/customers",
-		BasicAuthMiddleware(basicUsername, basicPassword),
-		apiRoute(api.GET_ALGOLIA_CUSTOMERS, &app),
-	)
-
-	router.GET("/algolia/contacts",
-		BasicAuthMiddleware(basicUsername, basicPassword),
-		apiRoute(api.GET_ALGOLIA_CONTACTS, &app),
-	)
-
-	router.GET("/algolia/parts",
-		BasicAuthMiddleware(basicUsername, basicPassword),
-		apiRoute(api.GET_ALGOLIA_PARTS, &app),
-	)
-
-	// Messages
-	router.GET("/messages/search/:email", apiRoute(api.GET_MESSAGES, &app))
-	router.GET("/messages/:email/:id", apiRoute(api.GET_MESSAGE_DETAILS, &app))
-
-	// Users
-	router.POST("/users/pinned_links", apiRoute(api.POST_CREATE_PINNED_LINK, &app))
-	router.DELETE("/users/pinned_links/:id", apiRoute(api.DELETE_PINNED_LINK, &app))
-	router.GET("/users/pinned_links", apiRoute(api.GET_PINNED_LINKS, &app))
-
-	// Events
-	router.POST("/events", apiRoute(api.CREATE_EVENT, &app))
-	router.POST("/events/:id", apiRoute(api.UPDATE_EVENT, &app))
-	router.GET("/events", apiRoute(api.GET_EVENTS, &app))
-	router.GET("/events/:id", apiRoute(api.GET_EVENT_DETAILS, &app))
-	router.DELETE("/events/:id", apiRoute(api.DELETE_EVENT, &app))
-
-	// Files
-	router.GET("/files", apiRoute(api.GET_BUCKET_CONTENTS, &app))
-	router.POST("/files/make_public", apiRoute(api.POST_MAKE_PUBLIC, &app))
-	router.POST("/files/make_private", apiRoute(api.POST_MAKE_PRIVATE, &app))
-	router.POST("/files/upload", apiRoute(api.UPLOAD_FILE_TO_BUCKET, &app))
-	router.POST("/files/create_folder", apiRoute(api.CREATE_FOLDER_IN_BUCKET, &app))
-	router.GET("/files/download/*path", api.SERVE_FILE_FROM_BUCKET)
-	router.DELETE("/files", apiRoute(api.DELETE_FILE_FROM_BUCKET, &app))
-	router.POST("/files/share", apiRoute(api.POST_SHARE_FILES, &app))
-	router.POST("/files/send", apiRoute(api.POST_SEND_FILES, &app))
-
-	// Comments
-	router.GET("/comments/:recordID", apiRoute(api.GET_COMMENTS, &app))
-	router.POST("/comments/:recordID", apiRoute(api.POST_COMMENT, &app))
-	router.DELETE("/comments/:commentID", apiRoute(api.DELETE_COMMENT, &app))
-
-	// Issues
-	router.GET("/issues", apiRoute(api.GET_ISSUES, &app))
-	router.GET("/issues/:id", apiRoute(api.GET_ISSUE, &app))
-	router.POST("/issues/:id", apiRoute(api.POST_UPDATE_ISSUE, &app))
-	router.POST("/issues", apiRoute(api.POST_CREATE_ISSUE, &app))
-	router.POST("/issues/:id/close", apiRoute(api.POST_CLOSE_ISSUE, &app))
-
-	// Users
-	router.GET("/users/current", apiRoute(api.GET_DEFAULTS, &app))
-	router.POST("/users/:id/settings", apiRoute(api.POST_UPDATE_USER, &app))
+	if err := registerRoutes(router, routeTable(&app), authConfig); err != nil {
+		log.Fatalf("Invalid route table: %v", err)
+	}
+
+	if trashPurgeInterval > 0 {
+		go purgeExpiredEvery(&app, trashPurgeInterval)
+	}
 
 	// Start server
 	router.Run(":" + u.GetDotEnvVariable("PORT"))
 }
 
+// routeTable declares every route with its auth policy. registerRoutes
+// refuses to start if a route is missing one.
+func routeTable(app *util.App) []Route {
+	// user lets in any signed-in user and API keys holding scope. staff
+	// limits signed-in users to the staff and admin roles. Ownership of
+	// comments, pinned links and settings is checked by the handlers.
+	user := func(scope string) auth.Policy {
+		return auth.Allow(auth.JWT, auth.APIKey).WithScopes(scope)
+	}
+	staff := func(scope string) auth.Policy {
+		return user(scope).WithRoles(auth.RoleStaff, auth.RoleAdmin)
+	}
+	algolia := auth.Allow(auth.Basic, auth.APIKey).WithScopes("algolia:read")
+	admin := auth.Allow(auth.JWT).WithRoles(auth.RoleAdmin)
+	// Lets schedulers in with an API key as well as admins.
+	scheduled := func(scope string) auth.Policy {
+		return auth.Allow(auth.JWT, auth.APIKey).WithRoles(auth.RoleAdmin).WithScopes(scope)
+	}
+
+	return []Route{
+		// Health Check
+		{"GET", "/", auth.Allow(auth.Public), func(c *gin.Context) { StatusOk(c, app) }},
+
+		// Algolia
+		{"GET", "/algolia/products", algolia, apiRoute(api.GET_ALGOLIA_PRODUCTS, app)},
+		{"GET", "/algolia/customers", algolia, apiRoute(api.GET_ALGOLIA_CUSTOMERS, app)},
+		{"GET", "/algolia/contacts", algolia, apiRoute(api.GET_ALGOLIA_CONTACTS, app)},
+		{"GET", "/algolia/parts", algolia, apiRoute(api.GET_ALGOLIA_PARTS, app)},
+
+		// Messages
+		{"GET", "/messages/search/:email", user("messages:read"), apiRoute(api.GET_MESSAGES, app)},
+		{"GET", "/messages/:email/:id", user("messages:read"), apiRoute(api.GET_MESSAGE_DETAILS, app)},
+
+		// Pinned links
+		{"POST", "/users/pinned_links", user("pinned_links:write"), apiRoute(api.POST_CREATE_PINNED_LINK, app)},
+		{"DELETE", "/users/pinned_links/:id", user("pinned_links:write"), apiRoute(api.DELETE_PINNED_LINK, app)},
+		{"GET", "/users/pinned_links", user("pinned_links:read"), apiRoute(api.GET_PINNED_LINKS, app)},
+
+		// Events
+		{"POST", "/events", staff("events:write"), apiRoute(api.CREATE_EVENT, app)},
+		{"POST", "/events/:id", staff("events:write"), apiRoute(api.UPDATE_EVENT, app)},
+		{"GET", "/events", user("events:read"), apiRoute(api.GET_EVENTS, app)},
+		{"GET", "/events/:id", user("events:read"), apiRoute(api.GET_EVENT_DETAILS, app)},
+		{"DELETE", "/events/:id", staff("events:write"), apiRoute(api.DELETE_EVENT, app)},
+
+		// Files
+		{"GET", "/files", user("files:read"), apiRoute(api.GET_BUCKET_CONTENTS, app)},
+		{"POST", "/files/make_public", user("files:write"), apiRoute(api.POST_MAKE_PUBLIC, app)},
+		{"POST", "/files/make_private", user("files:write"), apiRoute(api.POST_MAKE_PRIVATE, app)},
+		{"POST", "/files/upload", user("files:write"), apiRoute(api.UPLOAD_FILE_TO_BUCKET, app)},
+		{"POST", "/files/uploads", user("files:write"), storeRoute(api.POST_START_UPLOAD, app)},
+		{"GET", "/files/uploads/:id", user("files:write"), storeRoute(api.GET_UPLOAD, app)},
+		{"PATCH", "/files/uploads/:id", user("files:write"), storeRoute(api.PATCH_UPLOAD, app)},
+		{"DELETE", "/files/uploads/:id", user("files:write"), storeRoute(api.DELETE_UPLOAD, app)},
+		{"POST", "/files/create_folder", user("files:write"), apiRoute(api.CREATE_FOLDER_IN_BUCKET, app)},
+		{"GET", "/files/download/*path", user("files:read"), storeRoute(api.SERVE_FILE_FROM_BUCKET, app)},
+		{"DELETE", "/files", user("files:write"), apiRoute(api.DELETE_FILE_FROM_BUCKET, app)},
+		{"POST", "/files/move", staff("files:write"), apiRoute(api.POST_MOVE_FILES, app)},
+		{"POST", "/files/copy", staff("files:write"), apiRoute(api.POST_COPY_FILES, app)},
+		{"GET", "/files/search", staff("files:read"), apiRoute(api.GET_SEARCH_FILES, app)},
+		{"PUT", "/files/tags", staff("files:write"), storeRoute(api.PUT_FILE_TAGS, app)},
+		{"GET", "/files/duplicates", staff("files:read"), storeRoute(api.GET_DUPLICATE_FILES, app)},
+		{"GET", "/files/versions", staff("files:read"), storeRoute(api.GET_FILE_VERSIONS, app)},
+		{"POST", "/files/versions/promote", staff("files:write"), storeRoute(api.POST_PROMOTE_FILE_VERSION, app)},
+		{"GET", "/files/trash", staff("files:read"), storeRoute(api.GET_TRASH, app)},
+		{"POST", "/files/trash/purge_expired", scheduled("trash:write"), apiRoute(api.POST_PURGE_EXPIRED_TRASH, app)},
+		{"POST", "/files/trash/:id/restore", staff("files:write"), apiRoute(api.POST_RESTORE_TRASH, app)},
+		{"DELETE", "/files/trash/:id", admin, apiRoute(api.DELETE_TRASH, app)},
+		{"GET", "/files/preview/*path", user("files:read"), storeRoute(api.SERVE_FILE_PREVIEW, app)},
+		{"POST", "/files/previews/backfill", admin, storeRoute(api.POST_BACKFILL_PREVIEWS, app)},
+		{"POST", "/files/scan", admin, storeRoute(api.POST_SCAN_FILES, app)},
+		{"POST", "/files/archive", staff("files:read"), storeRoute(api.POST_ARCHIVE, app)},
+		{"POST", "/files/share", user("files:write"), apiRoute(api.POST_SHARE_FILES, app)},
+		{"POST", "/files/shares/migrate", admin, apiRoute(api.POST_MIGRATE_SHARES, app)},
+		{"POST", "/files/send", user("files:write"), apiRoute(api.POST_SEND_FILES, app)},
+		{"POST", "/files/signed_url", user("files:write"), apiRoute(api.POST_SIGNED_URL, app)},
+		{"GET", "/files/grants", admin, apiRoute(api.GET_FOLDER_GRANTS, app)},
+		{"POST", "/files/grants", admin, apiRoute(api.POST_FOLDER_GRANT, app)},
+		{"DELETE", "/files/grants/:id", admin, apiRoute(api.DELETE_FOLDER_GRANT, app)},
+		{"GET", "/files/links", staff("files:read"), apiRoute(api.GET_FILE_LINKS, app)},
+		{"GET", "/files/activity", admin, storeRoute(api.GET_FILE_ACTIVITY, app)},
+		{"GET", "/files/signed/*path", auth.Allow(auth.Public), storeRoute(api.SERVE_SIGNED_FILE, app)},
+
+		// Comments
+		{"GET", "/comments/:recordID", user("comments:read"), apiRoute(api.GET_COMMENTS, app)},
+		{"POST", "/comments/:recordID", staff("comments:write"), apiRoute(api.POST_COMMENT, app)},
+		{"DELETE", "/comments/:commentID", staff("comments:write"), apiRoute(api.DELETE_COMMENT, app)},
+
+		// Issues
+		{"GET", "/issues", user("issues:read"), apiRoute(api.GET_ISSUES, app)},
+		{"GET", "/issues/:id", user("issues:read"), apiRoute(api.GET_ISSUE, app)},
+		{"POST", "/issues/:id", staff("issues:write"), apiRoute(api.POST_UPDATE_ISSUE, app)},
+		{"POST", "/issues", staff("issues:write"), apiRoute(api.POST_CREATE_ISSUE, app)},
+		{"POST", "/issues/:id/close", staff("issues:write"), apiRoute(api.POST_CLOSE_ISSUE, app)},
+
+		// Users
+		{"GET", "/users/current", user("users:read"), apiRoute(api.GET_DEFAULTS, app)},
+		{"POST", "/users/:id/settings", user("users:write"), apiRoute(api.POST_UPDATE_USER, app)},
+
+		// API keys
+		{"GET", "/admin/api_keys", admin, apiRoute(api.GET_API_KEYS, app)},
+		{"POST", "/admin/api_keys", admin, apiRoute(api.POST_CREATE_API_KEY, app)},
+		{"DELETE", "/admin/api_keys/:id", admin, apiRoute(api.DELETE_API_KEY, app)},
+	}
+}
+
+// purgeExpiredEvery purges expired trash and archives, abandoned
+// quarantined uploads and the previews of files that are gone, on a timer
+// for as long as the process runs.
+func purgeExpiredEvery(app *util.App, interval time.Duration) {
+	for range time.Tick(interval) {
+		file := &model.File{
+			Store:   app.Blobs,
+			Context: context.Background(),
+			Shares:  app.SharedFiles,
+			Links:   app.FileLinks,
+		}
+		purged, err := file.PurgeExpired(time.Now())
+		if err != nil {
+			log.Printf("Purging expired trash failed: %v", err)
+		}
+		if len(purged) > 0 {
+			log.Printf("Purged %d expired trash items", len(purged))
+			activity := model.PurgeActivity(purged)
+			for n := range activity {
+				activity[n].At, activity[n].Actor = time.Now().UTC(), "system:trash-sweep"
+			}
+			model.RecordActivity(app.Activity, activity)
+		}
+		archives, err := file.PurgeExpiredArchives(time.Now())
+		if err != nil {
+			log.Printf("Purging expired archives failed: %v", err)
+		}
+		if archives > 0 {
+			log.Printf("Purged %d expired archives", archives)
+		}
+		quarantined, err := file.PurgeStaleQuarantine(time.Now())
+		if err != nil {
+			log.Printf("Purging stale quarantine failed: %v", err)
+		}
+		if quarantined > 0 {
+			log.Printf("Purged %d stale quarantined uploads", quarantined)
+		}
+		previews, err := file.PruneOrphanedPreviews()
+		if err != nil {
+			log.Printf("Pruning orphaned previews failed: %v", err)
+		}
+		if previews > 0 {
+			log.Printf("Pruned %d orphaned previews", previews)
+		}
+	}
+}
+
 func StatusOk(c *gin.Context, App *util.App) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
@@ -0,0 +1,1593 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/api"
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/audit"
+	"github.com/Proluxe/proluxe-common-api/auth"
+	"github.com/Proluxe/proluxe-common-api/blob"
+	"github.com/Proluxe/proluxe-common-api/model"
+	"github.com/Proluxe/proluxe-common-api/model/memory"
+	"github.com/Proluxe/proluxe-common-api/salesforce"
+	"github.com/Proluxe/proluxe-common-api/services"
+	"github.com/Proluxe/proluxe-common-api/util"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/scottraio/simpleforce"
+)
+
+const (
+	testSecret     = "test-jwt-secret"
+	testFilesURL   = "http://files.test"
+	testBasicUser  = "algolia"
+	testBasicPass  = "algolia-pass"
+	staffEmail     = "staff@proluxe.test"
+	adminEmail     = "admin@proluxe.test"
+	externalEmail  = "buyer@customer.test"
+	testRecordID   = "001000000000000AAA"
+	reachedHandler = 0
+)
+
+// testEnv is the API as main wires it up, on in-memory repositories, a local
+// blob store and a stand-in Salesforce org that answers every query with no
+// records.
+type testEnv struct {
+	app    *util.App
+	store  *memory.Store
+	router *gin.Engine
+	staff  model.User
+	// target is the last request's, for checks on what setup seeded.
+	target string
+}
+
+// testLogin logs in to the stand-in org without a password.
+type testLogin struct{ url string }
+
+func (l testLogin) Login(client *simpleforce.Client) error {
+	client.SetSidLoc("test-session", l.url)
+	return nil
+}
+
+// cleanScanner finds every file clean.
+type cleanScanner struct{}
+
+func (cleanScanner) Name() string { return "test" }
+
+func (cleanScanner) Scan(ctx context.Context, r io.Reader) (*model.ScanResult, error) {
+	_, err := io.Copy(io.Discard, r)
+	return &model.ScanResult{}, err
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	org := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"totalSize":0,"done":true,"records":[]}`)
+	}))
+	t.Cleanup(org.Close)
+
+	blobs, err := blob.NewLocal(t.TempDir(), testFilesURL, []byte("test-signing-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := memory.New()
+	repos := store.Repositories()
+	app := &util.App{
+		SF:           salesforce.New(org.URL, testLogin{org.URL}),
+		Repositories: repos,
+		Blobs:        blobs,
+		Activity:     &audit.Memory{},
+	}
+	app.Uploads.Scanner = cleanScanner{}
+
+	e := &testEnv{app: app, store: store}
+	e.staff = store.AddUser(model.User{Name: "Sam Staff", Email: staffEmail})
+	store.AddUser(model.User{Name: "Ada Admin", Email: adminEmail, Role: auth.RoleAdmin})
+	// Staff reach files through a grant on the whole bucket, as in production.
+	if err := repos.FolderGrants.Create(&model.FolderGrant{Folder: model.RootFolder, GranteeType: model.GranteeRole, Grantee: auth.RoleStaff, Permission: model.PermissionShare}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := auth.NewConfig(&auth.TokenVerifier{Secrets: [][]byte{[]byte(testSecret)}, Leeway: time.Minute}, repos)
+	cfg.BasicUser, cfg.BasicPassword = testBasicUser, testBasicPass
+
+	e.router = gin.New()
+	e.router.Use(services.RequestID())
+	if err := registerRoutes(e.router, routeTable(app), cfg); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// file returns a model.File on the env's store, for seeding.
+func (e *testEnv) file() *model.File {
+	return &model.File{Store: e.app.Blobs, Context: context.Background(), Shares: e.app.SharedFiles, Links: e.app.FileLinks}
+}
+
+// put uploads content to name as staff, scanned clean.
+func (e *testEnv) put(t *testing.T, name string, content []byte) *model.BlobAttrs {
+	t.Helper()
+	attrs, err := e.file().Upload(name, bytes.NewReader(content), e.app.Uploads, nil, staffEmail)
+	if err != nil {
+		t.Fatalf("seeding %s: %v", name, err)
+	}
+	return attrs
+}
+
+// caller adds a caller's credentials to a request.
+type caller func(t *testing.T, e *testEnv, r *http.Request)
+
+func anonymous(*testing.T, *testEnv, *http.Request) {}
+
+// signedIn sends a user token for email. Roles come from the user record:
+// staff and admin are seeded, anyone else is external.
+func signedIn(email string) caller {
+	return func(t *testing.T, e *testEnv, r *http.Request) {
+		now := time.Now()
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"id":  email,
+			"iat": now.Unix(),
+			"exp": now.Add(time.Hour).Unix(),
+		}).SignedString([]byte(testSecret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+var (
+	staff    = signedIn(staffEmail)
+	admin    = signedIn(adminEmail)
+	external = signedIn(externalEmail)
+)
+
+// withKey sends a new API key holding scopes.
+func withKey(scopes ...string) caller {
+	return func(t *testing.T, e *testEnv, r *http.Request) {
+		key, secret, err := model.NewAPIKey("test", scopes, time.Time{}, adminEmail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.app.APIKeys.Create(&key); err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Authorization", "ApiKey "+secret)
+	}
+}
+
+func basic(user, pass string) caller {
+	return func(_ *testing.T, _ *testEnv, r *http.Request) {
+		r.SetBasicAuth(user, pass)
+	}
+}
+
+// tampered breaks the signature of a signed link.
+func tampered(_ *testing.T, _ *testEnv, r *http.Request) {
+	q := r.URL.Query()
+	q.Set("signature", strings.Repeat("0", 64))
+	r.URL.RawQuery = q.Encode()
+}
+
+// routeCase drives one route: the caller as gets want, and deniedAs is
+// turned away with denied.
+type routeCase struct {
+	target  string
+	body    string
+	headers map[string]string
+	// setup seeds what the request needs and returns its target and body
+	// when they depend on what was seeded.
+	setup func(t *testing.T, e *testEnv) (target, body string)
+
+	as caller
+	// want is the status for as. reachedHandler accepts anything but an
+	// auth failure, for routes that go on to call services the tests don't
+	// stand in for, such as Gmail and Knock.
+	want int
+
+	// check asserts what the allowed call returned and what it changed.
+	check func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder)
+
+	// deniedAs is nil only for public routes with nothing to check.
+	deniedAs caller
+	denied   int
+	// unchanged asserts the denied call left things as they were seeded.
+	unchanged func(t *testing.T, e *testEnv)
+}
+
+func (rc routeCase) request(t *testing.T, e *testEnv, method string, as caller) *http.Request {
+	target, body := rc.target, rc.body
+	if rc.setup != nil {
+		target, body = rc.setup(t, e)
+	}
+	e.target = target
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if strings.HasPrefix(body, "{") {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range rc.headers {
+		r.Header.Set(k, v)
+	}
+	as(t, e, r)
+	return r
+}
+
+// withFile seeds docs/a.txt and sends target and body.
+func withFile(target, body string) func(t *testing.T, e *testEnv) (string, string) {
+	return func(t *testing.T, e *testEnv) (string, string) {
+		e.put(t, "docs/a.txt", []byte("hello"))
+		return target, body
+	}
+}
+
+func routeCases(t *testing.T) map[string]routeCase {
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, err := mw.CreateFormFile("file", "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("hello"))
+	mw.Close()
+
+	var thumb bytes.Buffer
+	if err := png.Encode(&thumb, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Setups take a format for the Id of what they seed; %.0s drops it for
+	// routes that list rather than address it.
+	return map[string]routeCase{
+		"GET /": {
+			target: "/", as: anonymous, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got map[string]string
+				decode(t, w, &got)
+				if got["status"] != "ok" {
+					t.Errorf("status = %q, want ok", got["status"])
+				}
+			},
+		},
+
+		"GET /algolia/products":  {target: "/algolia/products", as: basic(testBasicUser, testBasicPass), want: http.StatusOK, check: emptyList, deniedAs: basic(testBasicUser, "wrong"), denied: http.StatusUnauthorized},
+		"GET /algolia/customers": {target: "/algolia/customers", as: basic(testBasicUser, testBasicPass), want: http.StatusOK, check: emptyList, deniedAs: anonymous, denied: http.StatusUnauthorized},
+		"GET /algolia/contacts":  {target: "/algolia/contacts", as: withKey("algolia:read"), want: http.StatusOK, check: emptyList, deniedAs: withKey("files:read"), denied: http.StatusForbidden},
+		"GET /algolia/parts":     {target: "/algolia/parts", as: basic(testBasicUser, testBasicPass), want: http.StatusOK, check: emptyList, deniedAs: staff, denied: http.StatusUnauthorized},
+
+		"GET /messages/search/:email": {target: "/messages/search/" + externalEmail, as: staff, want: reachedHandler, deniedAs: anonymous, denied: http.StatusUnauthorized},
+		"GET /messages/:email/:id":    {target: "/messages/" + staffEmail + "/msg1", as: staff, want: reachedHandler, deniedAs: withKey("files:read"), denied: http.StatusForbidden},
+
+		"POST /users/pinned_links": {
+			target: "/users/pinned_links", body: `{"App":"crm","Email":"` + staffEmail + `","Name":"Orders","Path":"/orders"}`,
+			as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var link model.PinnedLink
+				decode(t, w, &link)
+				if link.Id == "" {
+					t.Error("no Id on the new link")
+				}
+				if links := e.pinnedLinks(t); len(links) != 1 || links[0].Path != "/orders" {
+					t.Errorf("pinned links = %+v, want /orders", links)
+				}
+			},
+			deniedAs: withKey("pinned_links:read"), denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if links := e.pinnedLinks(t); len(links) != 0 {
+					t.Errorf("pinned links = %+v, want none", links)
+				}
+			},
+		},
+		"DELETE /users/pinned_links/:id": {
+			setup: withPinnedLink("/users/pinned_links/%s"), as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if links := e.pinnedLinks(t); len(links) != 0 {
+					t.Errorf("pinned links = %+v, want none", links)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if links := e.pinnedLinks(t); len(links) != 1 {
+					t.Errorf("pinned links = %+v, want the seeded one", links)
+				}
+			},
+		},
+		"GET /users/pinned_links": {
+			setup: withPinnedLink("/users/pinned_links?app=crm%.0s"), as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got struct{ Links []model.PinnedLink }
+				decode(t, w, &got)
+				if len(got.Links) != 1 || got.Links[0].Name != "Orders" {
+					t.Errorf("links = %+v, want Orders", got.Links)
+				}
+			},
+			deniedAs: anonymous, denied: http.StatusUnauthorized,
+		},
+
+		"POST /events": {
+			target: "/events", body: `{"Name":"Open house"}`, as: staff, want: http.StatusCreated,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var event model.Event
+				decode(t, w, &event)
+				if got := e.events(t); len(got) != 1 || got[0].Id != event.Id || got[0].Name != "Open house" {
+					t.Errorf("events = %+v, want Open house as %s", got, event.Id)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if got := e.events(t); len(got) != 0 {
+					t.Errorf("events = %+v, want none", got)
+				}
+			},
+		},
+		"POST /events/:id": {
+			setup: withEvent("/events/%s", `{"Name":"Open house, moved"}`), as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if got := e.events(t); len(got) != 1 || got[0].Name != "Open house, moved" {
+					t.Errorf("events = %+v, want the new name", got)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if got := e.events(t); len(got) != 1 || got[0].Name != "Open house" {
+					t.Errorf("events = %+v, want the seeded name", got)
+				}
+			},
+		},
+		"GET /events": {
+			setup: withEvent("/events%.0s", ""), as: external, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got []model.Event
+				decode(t, w, &got)
+				if len(got) != 1 || got[0].Name != "Open house" {
+					t.Errorf("events = %+v, want Open house", got)
+				}
+			},
+			deniedAs: anonymous, denied: http.StatusUnauthorized,
+		},
+		"GET /events/:id": {
+			setup: withEvent("/events/%s", ""), as: withKey("events:read"), want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got model.Event
+				decode(t, w, &got)
+				if got.Name != "Open house" {
+					t.Errorf("event = %+v, want Open house", got)
+				}
+			},
+			deniedAs: withKey("issues:read"), denied: http.StatusForbidden,
+		},
+		"DELETE /events/:id": {
+			setup: withEvent("/events/%s", ""), as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if got := e.events(t); len(got) != 0 {
+					t.Errorf("events = %+v, want none", got)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if got := e.events(t); len(got) != 1 {
+					t.Errorf("events = %+v, want the seeded one", got)
+				}
+			},
+		},
+
+		"GET /files": {
+			setup: withFile("/files?path=docs/", ""), as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got struct{ Contents []model.BucketEntry }
+				decode(t, w, &got)
+				if len(got.Contents) != 1 || got.Contents[0].Name != "docs/a.txt" {
+					t.Errorf("contents = %+v, want docs/a.txt", got.Contents)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+		},
+		"POST /files/make_public": {
+			setup: withFile("/files/make_public?path=docs/a.txt", ""), as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if !e.attrs(t, "docs/a.txt").Public {
+					t.Error("docs/a.txt is still private")
+				}
+				e.waitForActivity(t, model.ActivityMakePublic, "docs/a.txt")
+			},
+			deniedAs: anonymous, denied: http.StatusUnauthorized,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if e.attrs(t, "docs/a.txt").Public {
+					t.Error("docs/a.txt was made public")
+				}
+			},
+		},
+		"POST /files/make_private": {
+			setup: func(t *testing.T, e *testEnv) (string, string) {
+				e.put(t, "docs/a.txt", []byte("hello"))
+				if err := e.app.Blobs.SetPublic(context.Background(), "docs/a.txt", true); err != nil {
+					t.Fatal(err)
+				}
+				return "/files/make_private?path=docs/a.txt", ""
+			},
+			as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if e.attrs(t, "docs/a.txt").Public {
+					t.Error("docs/a.txt is still public")
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if !e.attrs(t, "docs/a.txt").Public {
+					t.Error("docs/a.txt was made private")
+				}
+			},
+		},
+		"POST /files/upload": {
+			target: "/files/upload?path=docs/", body: form.String(), headers: map[string]string{"Content-Type": mw.FormDataContentType()},
+			as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if got := e.read(t, "docs/a.txt"); got != "hello" {
+					t.Errorf("docs/a.txt = %q, want hello", got)
+				}
+				e.waitForActivity(t, model.ActivityUpload, "docs/a.txt")
+			},
+			deniedAs: withKey("files:read"), denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if e.attrs(t, "docs/a.txt") != nil {
+					t.Error("docs/a.txt was uploaded")
+				}
+			},
+		},
+		"POST /files/uploads": {
+			target: "/files/uploads", body: `{"Path":"docs/b.txt","Size":5}`, as: staff, want: http.StatusCreated,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var session model.UploadSession
+				decode(t, w, &session)
+				got, err := e.file().UploadStatus(session.Id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Path != "docs/b.txt" || got.Size != 5 || got.Offset != 0 {
+					t.Errorf("session = %+v, want docs/b.txt, 5 bytes, none sent", got)
+				}
+			},
+			deniedAs: anonymous, denied: http.StatusUnauthorized,
+		},
+		"GET /files/uploads/:id": {
+			setup: withUpload, as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got model.UploadSession
+				decode(t, w, &got)
+				if got.Path != "docs/b.txt" || got.Size != 5 || got.Offset != 0 {
+					t.Errorf("session = %+v, want docs/b.txt, 5 bytes, none sent", got)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+		},
+		"PATCH /files/uploads/:id": {
+			setup: withUpload, headers: map[string]string{"Upload-Offset": "0"}, as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got model.UploadSession
+				decode(t, w, &got)
+				if got.Offset != 5 || got.Result == nil {
+					t.Errorf("session = %+v, want all 5 bytes stored", got)
+				}
+				if body := e.read(t, "docs/b.txt"); body != "hello" {
+					t.Errorf("docs/b.txt = %q, want hello", body)
+				}
+			},
+			deniedAs: anonymous, denied: http.StatusUnauthorized,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if e.attrs(t, "docs/b.txt") != nil {
+					t.Error("docs/b.txt was stored")
+				}
+			},
+		},
+		"DELETE /files/uploads/:id": {
+			setup: withUpload, as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				id := path.Base(e.target)
+				if _, err := e.file().UploadStatus(id); !apperror.Is(err, apperror.CodeNotFound) {
+					t.Errorf("UploadStatus after cancelling: %v, want not found", err)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+		},
+		"POST /files/create_folder": {
+			target: "/files/create_folder", body: `{"Path":"reports/"}`, as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if e.attrs(t, "reports/") == nil {
+					t.Error("reports/ wasn't created")
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if e.attrs(t, "reports/") != nil {
+					t.Error("reports/ was created")
+				}
+			},
+		},
+		"GET /files/download/*path": {
+			setup: withFile("/files/download/docs/a.txt", ""), as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if w.Body.String() != "hello" {
+					t.Errorf("body = %q, want hello", w.Body)
+				}
+				if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, "a.txt") {
+					t.Errorf("Content-Disposition = %q, want a.txt", cd)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+		},
+		"DELETE /files": {
+			setup: withFile("/files?path=docs/a.txt", ""), as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if e.attrs(t, "docs/a.txt") != nil {
+					t.Error("docs/a.txt is still there")
+				}
+				if items := e.trash(t); len(items) != 1 || items[0].Path != "docs/a.txt" {
+					t.Errorf("trash = %+v, want docs/a.txt", items)
+				}
+				e.waitForActivity(t, model.ActivityTrash, "docs/a.txt")
+			},
+			deniedAs: anonymous, denied: http.StatusUnauthorized,
+			unchanged: fileUntouched("docs/a.txt"),
+		},
+		"POST /files/move": {
+			setup: func(t *testing.T, e *testEnv) (string, string) {
+				e.put(t, "docs/a.txt", []byte("hello"))
+				if err := e.app.SharedFiles.Share("docs/a.txt", model.SharedItem{Object: "Account", ObjectId: testRecordID, ObjectName: "Acme"}); err != nil {
+					t.Fatal(err)
+				}
+				return "/files/move", `{"Source":"docs/a.txt","Destination":"old/a.txt"}`
+			},
+			as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if e.attrs(t, "docs/a.txt") != nil {
+					t.Error("docs/a.txt is still there")
+				}
+				if got := e.read(t, "old/a.txt"); got != "hello" {
+					t.Errorf("old/a.txt = %q, want hello", got)
+				}
+				shares, err := e.app.SharedFiles.ForRecord("Account", testRecordID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(shares) != 1 || shares[0].Path != "old/a.txt" {
+					t.Errorf("shares = %+v, want one on old/a.txt", shares)
+				}
+				e.waitForActivity(t, model.ActivityMove, "docs/a.txt")
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: fileUntouched("docs/a.txt"),
+		},
+		"POST /files/copy": {
+			setup: withFile("/files/copy", `{"Source":"docs/a.txt","Destination":"old/a.txt"}`), as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				for _, name := range []string{"docs/a.txt", "old/a.txt"} {
+					if got := e.read(t, name); got != "hello" {
+						t.Errorf("%s = %q, want hello", name, got)
+					}
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if e.attrs(t, "old/a.txt") != nil {
+					t.Error("old/a.txt was created")
+				}
+			},
+		},
+		"GET /files/search": {
+			setup: func(t *testing.T, e *testEnv) (string, string) {
+				e.put(t, "docs/a.txt", []byte("hello"))
+				e.put(t, "docs/b.txt", []byte("hello"))
+				return "/files/search?q=a.txt", ""
+			},
+			as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got model.SearchResult
+				decode(t, w, &got)
+				if len(got.Files) != 1 || got.Files[0].Name != "docs/a.txt" {
+					t.Errorf("files = %+v, want docs/a.txt", got.Files)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+		},
+		"PUT /files/tags": {
+			setup: withFile("/files/tags", `{"Path":"docs/a.txt","Tags":["invoice"]}`), as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if got := e.attrs(t, "docs/a.txt").Metadata["tags"]; got != "invoice" {
+					t.Errorf("tags = %q, want invoice", got)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if got := e.attrs(t, "docs/a.txt").Metadata["tags"]; got != "" {
+					t.Errorf("tags = %q, want none", got)
+				}
+			},
+		},
+		"GET /files/duplicates": {
+			setup: func(t *testing.T, e *testEnv) (string, string) {
+				e.put(t, "docs/a.txt", []byte("hello"))
+				e.put(t, "docs/b.txt", []byte("hello"))
+				e.put(t, "docs/c.txt", []byte("other"))
+				return "/files/duplicates?prefix=docs/", ""
+			},
+			as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got model.DuplicateReport
+				decode(t, w, &got)
+				if len(got.Groups) != 1 || strings.Join(got.Groups[0].Paths, ",") != "docs/a.txt,docs/b.txt" {
+					t.Errorf("groups = %+v, want docs/a.txt and docs/b.txt", got.Groups)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+		},
+		"GET /files/versions": {
+			setup: func(t *testing.T, e *testEnv) (string, string) {
+				withOldVersion(t, e)
+				return "/files/versions?path=docs/a.txt", ""
+			},
+			as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got []model.FileVersion
+				decode(t, w, &got)
+				if len(got) != 2 {
+					t.Fatalf("versions = %+v, want 2", got)
+				}
+				live := 0
+				for _, v := range got {
+					if v.Live {
+						live++
+					}
+				}
+				if live != 1 {
+					t.Errorf("%d live versions, want 1", live)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+		},
+		"POST /files/versions/promote": {
+			setup: withOldVersion, as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if got := e.read(t, "docs/a.txt"); got != "one" {
+					t.Errorf("docs/a.txt = %q, want the first version", got)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if got := e.read(t, "docs/a.txt"); got != "two" {
+					t.Errorf("docs/a.txt = %q, want the second version", got)
+				}
+			},
+		},
+		"GET /files/trash": {
+			setup: withTrashItem("/files/trash%.0s", time.Hour), as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got []model.TrashItem
+				decode(t, w, &got)
+				if len(got) != 1 || got[0].Path != "docs/a.txt" || got[0].DeletedBy != staffEmail {
+					t.Errorf("trash = %+v, want docs/a.txt deleted by staff", got)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+		},
+		"POST /files/trash/purge_expired": {
+			setup: withTrashItem("/files/trash/purge_expired%.0s", -time.Hour), as: withKey("trash:write"), want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got struct{ Purged []string }
+				decode(t, w, &got)
+				if len(got.Purged) != 1 {
+					t.Errorf("purged = %v, want the expired item", got.Purged)
+				}
+				if items := e.trash(t); len(items) != 0 {
+					t.Errorf("trash = %+v, want it empty", items)
+				}
+			},
+			deniedAs: staff, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if items := e.trash(t); len(items) != 1 {
+					t.Errorf("trash = %+v, want the seeded item", items)
+				}
+			},
+		},
+		"POST /files/trash/:id/restore": {
+			setup: withTrashItem("/files/trash/%s/restore", time.Hour), as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if got := e.read(t, "docs/a.txt"); got != "hello" {
+					t.Errorf("docs/a.txt = %q, want hello", got)
+				}
+				if items := e.trash(t); len(items) != 0 {
+					t.Errorf("trash = %+v, want it empty", items)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if e.attrs(t, "docs/a.txt") != nil {
+					t.Error("docs/a.txt was restored")
+				}
+			},
+		},
+		"DELETE /files/trash/:id": {
+			setup: withTrashItem("/files/trash/%s", time.Hour), as: admin, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if items := e.trash(t); len(items) != 0 {
+					t.Errorf("trash = %+v, want it empty", items)
+				}
+				if e.attrs(t, "docs/a.txt") != nil {
+					t.Error("docs/a.txt came back")
+				}
+			},
+			deniedAs: staff, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if items := e.trash(t); len(items) != 1 {
+					t.Errorf("trash = %+v, want the seeded item", items)
+				}
+			},
+		},
+		"GET /files/preview/*path": {
+			setup: func(t *testing.T, e *testEnv) (string, string) {
+				e.put(t, "docs/thumb.png", thumb.Bytes())
+				return "/files/preview/docs/thumb.png", ""
+			},
+			as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if _, _, err := image.Decode(w.Body); err != nil {
+					t.Errorf("preview isn't an image: %v", err)
+				}
+			},
+			deniedAs: anonymous, denied: http.StatusUnauthorized,
+		},
+		"POST /files/previews/backfill": {
+			setup: func(t *testing.T, e *testEnv) (string, string) {
+				e.put(t, "docs/thumb.png", thumb.Bytes())
+				return "/files/previews/backfill", `{}`
+			},
+			as: admin, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got struct {
+					Scanned int
+					Failed  []api.ObjectResult
+				}
+				decode(t, w, &got)
+				if got.Scanned != 1 || len(got.Failed) != 0 {
+					t.Errorf("backfill = %+v, want docs/thumb.png scanned without failures", got)
+				}
+			},
+			deniedAs: staff, denied: http.StatusForbidden,
+		},
+		"POST /files/scan": {
+			setup: withFile("/files/scan", `{}`), as: admin, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got struct {
+					Infected []string
+					Failed   []api.ObjectResult
+				}
+				decode(t, w, &got)
+				if len(got.Infected) != 0 || len(got.Failed) != 0 {
+					t.Errorf("scan = %+v, want nothing infected or failed", got)
+				}
+			},
+			deniedAs: withKey("files:write"), denied: http.StatusUnauthorized,
+		},
+		"POST /files/archive": {
+			setup: withFile("/files/archive", `{"Paths":["docs/"]}`), as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+				if err != nil {
+					t.Fatalf("archive isn't a zip: %v", err)
+				}
+				if len(zr.File) != 1 || zr.File[0].Name != "docs/a.txt" {
+					t.Errorf("archive holds %d files, want docs/a.txt", len(zr.File))
+				}
+				e.waitForActivity(t, model.ActivityArchive, "docs/a.txt")
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+		},
+		"POST /files/share": {
+			setup: withFile("/files/share", `{"Path":"docs/a.txt","SharedItems":[{"Object":"Account","ObjectId":"`+testRecordID+`","ObjectName":"Acme"}]}`),
+			as:    staff, want: reachedHandler,
+			// The share is saved before the confirmation goes out through Knock.
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				shares, err := e.app.SharedFiles.ForRecord("Account", testRecordID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(shares) != 1 || shares[0].Path != "docs/a.txt" {
+					t.Errorf("shares = %+v, want docs/a.txt", shares)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if shares, _ := e.app.SharedFiles.ForRecord("Account", testRecordID); len(shares) != 0 {
+					t.Errorf("shares = %+v, want none", shares)
+				}
+			},
+		},
+		"POST /files/shares/migrate": {
+			setup: func(t *testing.T, e *testEnv) (string, string) {
+				for _, id := range []string{"001000000000001AAA", "001000000000002AAA", "001000000000003AAA"} {
+					e.store.AddLegacyShare(model.SharedFile{Object: "Account", ObjectId: id, ObjectName: "Acme", Path: "docs/a.txt"})
+				}
+				return "/files/shares/migrate", `{"Limit":2}`
+			},
+			as: admin, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got struct {
+					Migrated  int
+					Remaining bool
+				}
+				decode(t, w, &got)
+				if got.Migrated != 2 || !got.Remaining {
+					t.Errorf("migration = %+v, want 2 moved and more to go", got)
+				}
+				if shares, _ := e.app.SharedFiles.UnderPath("docs/a.txt"); len(shares) != 2 {
+					t.Errorf("shares = %+v, want the 2 migrated", shares)
+				}
+			},
+			deniedAs: staff, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if shares, _ := e.app.SharedFiles.UnderPath("docs/a.txt"); len(shares) != 0 {
+					t.Errorf("shares = %+v, want none migrated", shares)
+				}
+			},
+		},
+		"POST /files/send": {
+			setup: withFile("/files/send", `{"Email":"`+externalEmail+`","Path":"docs/a.txt"}`),
+			as:    staff, want: reachedHandler,
+			// The link is issued before the email goes out through Gmail.
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if links := e.links(t, externalEmail); len(links) != 1 || links[0].IssuedBy != staffEmail {
+					t.Errorf("links = %+v, want one issued by staff", links)
+				}
+			},
+			deniedAs: anonymous, denied: http.StatusUnauthorized,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if links := e.links(t, ""); len(links) != 0 {
+					t.Errorf("links = %+v, want none", links)
+				}
+			},
+		},
+		"POST /files/signed_url": {
+			setup: withFile("/files/signed_url", `{"Path":"docs/a.txt"}`), as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got struct{ URL string }
+				decode(t, w, &got)
+				if !strings.HasPrefix(got.URL, testFilesURL+"/files/signed/docs/a.txt?") {
+					t.Errorf("URL = %s, want a signed link to docs/a.txt", got.URL)
+				}
+				if links := e.links(t, ""); len(links) != 1 {
+					t.Errorf("links = %+v, want the one issued", links)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+		},
+		"GET /files/grants": {
+			setup: func(t *testing.T, e *testEnv) (string, string) {
+				withGrant(t, e)
+				return "/files/grants?path=docs/", ""
+			},
+			as: admin, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got []model.FolderGrant
+				decode(t, w, &got)
+				if len(got) != 2 {
+					t.Errorf("grants = %+v, want the root grant and the one on docs/", got)
+				}
+			},
+			deniedAs: staff, denied: http.StatusForbidden,
+		},
+		"POST /files/grants": {
+			target: "/files/grants", body: `{"Folder":"docs/","GranteeType":"user","Grantee":"` + externalEmail + `","Permission":"read"}`, as: admin, want: http.StatusCreated,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var grant model.FolderGrant
+				decode(t, w, &grant)
+				if !e.hasGrant(t, grant.Id) || grant.Grantee != externalEmail {
+					t.Errorf("grant %+v wasn't saved", grant)
+				}
+				e.waitForActivity(t, model.ActivityGrant, "docs/")
+			},
+			deniedAs: staff, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if grants, _ := e.app.FolderGrants.ForPath("docs/"); len(grants) != 1 {
+					t.Errorf("grants = %+v, want only the root grant", grants)
+				}
+			},
+		},
+		"DELETE /files/grants/:id": {
+			setup: withGrant, as: admin, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if grants, _ := e.app.FolderGrants.ForPath("docs/"); len(grants) != 1 {
+					t.Errorf("grants = %+v, want only the root grant", grants)
+				}
+			},
+			deniedAs: withKey("files:write"), denied: http.StatusUnauthorized,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if grants, _ := e.app.FolderGrants.ForPath("docs/"); len(grants) != 2 {
+					t.Errorf("grants = %+v, want the seeded grant kept", grants)
+				}
+			},
+		},
+		"GET /files/links": {
+			setup: func(t *testing.T, e *testEnv) (string, string) {
+				e.put(t, "docs/a.txt", []byte("hello"))
+				if _, _, err := e.file().IssueLink("docs/a.txt", externalEmail, "a.txt", staffEmail, time.Hour); err != nil {
+					t.Fatal(err)
+				}
+				return "/files/links?path=docs/a.txt", ""
+			},
+			as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got []model.FileLink
+				decode(t, w, &got)
+				if len(got) != 1 || got[0].Recipient != externalEmail {
+					t.Errorf("links = %+v, want the one sent to %s", got, externalEmail)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+		},
+		"GET /files/activity": {
+			setup: func(t *testing.T, e *testEnv) (string, string) {
+				entry := model.FileActivity{At: time.Now().UTC(), Actor: staffEmail, Action: model.ActivityUpload, Path: "docs/a.txt"}
+				if err := e.app.Activity.Record(context.Background(), []model.FileActivity{entry}); err != nil {
+					t.Fatal(err)
+				}
+				return "/files/activity", ""
+			},
+			as: admin, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got []model.FileActivity
+				decode(t, w, &got)
+				if len(got) != 1 || got[0].Path != "docs/a.txt" || got[0].Actor != staffEmail {
+					t.Errorf("activity = %+v, want the seeded upload", got)
+				}
+			},
+			deniedAs: staff, denied: http.StatusForbidden,
+		},
+		"GET /files/signed/*path": {
+			setup: withSignedLink, as: anonymous, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if w.Body.String() != "hello" {
+					t.Errorf("body = %q, want hello", w.Body)
+				}
+				e.waitForActivity(t, model.ActivityLinkDownload, "docs/a.txt")
+			},
+			deniedAs: tampered, denied: http.StatusForbidden,
+		},
+		"GET /comments/:recordID": {
+			setup: func(t *testing.T, e *testEnv) (string, string) {
+				withComment(t, e)
+				return "/comments/" + testRecordID, ""
+			},
+			as: external, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got struct{ Comments []model.Comment }
+				decode(t, w, &got)
+				if len(got.Comments) != 1 || got.Comments[0].Message != "Looks good" {
+					t.Errorf("comments = %+v, want the seeded one", got.Comments)
+				}
+			},
+			deniedAs: anonymous, denied: http.StatusUnauthorized,
+		},
+		"POST /comments/:recordID": {
+			target: "/comments/" + testRecordID, body: `{"Message":"Looks good","RecordType":"Account","CreatedBy":"` + adminEmail + `"}`, as: staff, want: http.StatusCreated,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				got := e.comments(t)
+				if len(got) != 1 || got[0].Message != "Looks good" {
+					t.Fatalf("comments = %+v, want the new one", got)
+				}
+				if got[0].CreatedBy != staffEmail {
+					t.Errorf("CreatedBy = %s, want the caller, not the payload", got[0].CreatedBy)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if got := e.comments(t); len(got) != 0 {
+					t.Errorf("comments = %+v, want none", got)
+				}
+			},
+		},
+		"DELETE /comments/:commentID": {
+			setup: withComment, as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if got := e.comments(t); len(got) != 0 {
+					t.Errorf("comments = %+v, want none", got)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if got := e.comments(t); len(got) != 1 {
+					t.Errorf("comments = %+v, want the seeded one", got)
+				}
+			},
+		},
+
+		"GET /issues": {
+			setup: withIssue("/issues%.0s", ""), as: external, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got []model.Issue
+				decode(t, w, &got)
+				if len(got) != 1 || got[0].Name != "Broken hinge" {
+					t.Errorf("issues = %+v, want Broken hinge", got)
+				}
+			},
+			deniedAs: anonymous, denied: http.StatusUnauthorized,
+		},
+		"GET /issues/:id": {
+			setup: withIssue("/issues/%s", ""), as: withKey("issues:read"), want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got model.Issue
+				decode(t, w, &got)
+				if got.Name != "Broken hinge" {
+					t.Errorf("issue = %+v, want Broken hinge", got)
+				}
+			},
+			deniedAs: withKey("issues:write"), denied: http.StatusForbidden,
+		},
+		"POST /issues/:id": {
+			setup: withIssue("/issues/%s", `{"Name":"Broken hinge","Description":"Left door"}`), as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if got := e.issues(t); len(got) != 1 || got[0].Description != "Left door" {
+					t.Errorf("issues = %+v, want the new description", got)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if got := e.issues(t); len(got) != 1 || got[0].Description != "" {
+					t.Errorf("issues = %+v, want no description", got)
+				}
+			},
+		},
+		"POST /issues": {
+			target: "/issues", body: `{"Name":"Broken hinge"}`, as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var issue model.Issue
+				decode(t, w, &issue)
+				if got := e.issues(t); len(got) != 1 || got[0].Id != issue.Id {
+					t.Errorf("issues = %+v, want %s", got, issue.Id)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if got := e.issues(t); len(got) != 0 {
+					t.Errorf("issues = %+v, want none", got)
+				}
+			},
+		},
+		"POST /issues/:id/close": {
+			setup: withIssue("/issues/%s/close", ""), as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if got := e.issues(t); len(got) != 0 {
+					t.Errorf("open issues = %+v, want none", got)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if got := e.issues(t); len(got) != 1 {
+					t.Errorf("open issues = %+v, want the seeded one", got)
+				}
+			},
+		},
+
+		"GET /users/current": {
+			target: "/users/current", as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got struct{ User model.User }
+				decode(t, w, &got)
+				if got.User.Email != staffEmail {
+					t.Errorf("user = %+v, want %s", got.User, staffEmail)
+				}
+			},
+			deniedAs: anonymous, denied: http.StatusUnauthorized,
+		},
+		"POST /users/:id/settings": {
+			setup: func(t *testing.T, e *testEnv) (string, string) {
+				return "/users/" + e.staff.Id + "/settings", `{"Name":"Sam Staff","IssueNotifications":true}`
+			},
+			as: staff, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if !e.user(t, staffEmail).IssueNotifications {
+					t.Error("issue notifications are still off")
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if e.user(t, staffEmail).IssueNotifications {
+					t.Error("issue notifications were turned on")
+				}
+			},
+		},
+
+		"GET /admin/api_keys": {
+			setup: func(t *testing.T, e *testEnv) (string, string) {
+				withAPIKey(t, e)
+				return "/admin/api_keys", ""
+			},
+			as: admin, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got []map[string]interface{}
+				decode(t, w, &got)
+				if len(got) != 1 || got[0]["Name"] != "erp-sync" {
+					t.Errorf("keys = %+v, want erp-sync", got)
+				}
+				if _, ok := got[0]["Hash"]; ok {
+					t.Error("key hash was listed")
+				}
+			},
+			deniedAs: staff, denied: http.StatusForbidden,
+		},
+		"POST /admin/api_keys": {
+			target: "/admin/api_keys", body: `{"Name":"erp-sync","Scopes":["files:read"]}`, as: admin, want: http.StatusCreated,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				var got struct{ Key string }
+				decode(t, w, &got)
+				key, err := e.app.APIKeys.FindByHash(model.HashAPIKey(got.Key))
+				if err != nil {
+					t.Fatalf("new key not found by its secret: %v", err)
+				}
+				if key.Name != "erp-sync" || key.CreatedBy != adminEmail {
+					t.Errorf("key = %+v, want erp-sync created by admin", key)
+				}
+			},
+			deniedAs: withKey("files:read"), denied: http.StatusUnauthorized,
+		},
+		"DELETE /admin/api_keys/:id": {
+			setup: withAPIKey, as: admin, want: http.StatusOK,
+			check: func(t *testing.T, e *testEnv, w *httptest.ResponseRecorder) {
+				if keys := e.apiKeys(t); len(keys) != 1 || !keys[0].Revoked {
+					t.Errorf("keys = %+v, want it revoked", keys)
+				}
+			},
+			deniedAs: external, denied: http.StatusForbidden,
+			unchanged: func(t *testing.T, e *testEnv) {
+				if keys := e.apiKeys(t); len(keys) != 1 || keys[0].Revoked {
+					t.Errorf("keys = %+v, want it still live", keys)
+				}
+			},
+		},
+	}
+}
+
+func withEvent(format, body string) func(t *testing.T, e *testEnv) (string, string) {
+	return func(t *testing.T, e *testEnv) (string, string) {
+		event := model.Event{Name: "Open house", StartDateTime: time.Now().Add(24 * time.Hour), EndDateTime: time.Now().Add(26 * time.Hour)}
+		if err := e.app.Events.Create(&event); err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprintf(format, event.Id), body
+	}
+}
+
+func withIssue(format, body string) func(t *testing.T, e *testEnv) (string, string) {
+	return func(t *testing.T, e *testEnv) (string, string) {
+		issue := model.Issue{Name: "Broken hinge"}
+		if err := e.app.Issues.Create(&issue); err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprintf(format, issue.Id), body
+	}
+}
+
+func withUpload(t *testing.T, e *testEnv) (string, string) {
+	session, err := e.file().StartUpload("docs/b.txt", 5, nil, e.app.Uploads, staffEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "/files/uploads/" + session.Id, "hello"
+}
+
+func withOldVersion(t *testing.T, e *testEnv) (string, string) {
+	first := e.put(t, "docs/a.txt", []byte("one"))
+	e.put(t, "docs/a.txt", []byte("two"))
+	return "/files/versions/promote", fmt.Sprintf(`{"Path":"docs/a.txt","Generation":%d}`, first.Generation)
+}
+
+// withTrashItem trashes docs/a.txt to be kept for retention; a negative
+// retention leaves it already expired.
+func withTrashItem(format string, retention time.Duration) func(t *testing.T, e *testEnv) (string, string) {
+	return func(t *testing.T, e *testEnv) (string, string) {
+		e.put(t, "docs/a.txt", []byte("hello"))
+		item, _, err := e.file().Trash("docs/a.txt", staffEmail, retention)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprintf(format, item.Id), ""
+	}
+}
+
+func withSignedLink(t *testing.T, e *testEnv) (string, string) {
+	e.put(t, "docs/a.txt", []byte("hello"))
+	signed, err := e.app.Blobs.SignedURL("docs/a.txt", model.SignedURLOptions{Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.RequestURI(), ""
+}
+
+func withPinnedLink(format string) func(t *testing.T, e *testEnv) (string, string) {
+	return func(t *testing.T, e *testEnv) (string, string) {
+		link, err := e.app.PinnedLinks.Create(model.PinnedLink{App: "crm", Email: staffEmail, Name: "Orders", Path: "/orders"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprintf(format, link.Id), ""
+	}
+}
+
+func withGrant(t *testing.T, e *testEnv) (string, string) {
+	grant := model.FolderGrant{Folder: "docs/", GranteeType: model.GranteeUser, Grantee: externalEmail, Permission: model.PermissionRead}
+	if err := e.app.FolderGrants.Create(&grant); err != nil {
+		t.Fatal(err)
+	}
+	return "/files/grants/" + grant.Id, ""
+}
+
+func withComment(t *testing.T, e *testEnv) (string, string) {
+	comment := model.Comment{RecordID: testRecordID, RecordType: "Account", Message: "Looks good", CreatedBy: staffEmail}
+	if err := e.app.Comments.Create(&comment); err != nil {
+		t.Fatal(err)
+	}
+	return "/comments/" + comment.Id, ""
+}
+
+func withAPIKey(t *testing.T, e *testEnv) (string, string) {
+	key, _, err := model.NewAPIKey("erp-sync", []string{"files:read"}, time.Time{}, adminEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.app.APIKeys.Create(&key); err != nil {
+		t.Fatal(err)
+	}
+	return "/admin/api_keys/" + key.Id, ""
+}
+
+func TestRoutes(t *testing.T) {
+	cases := routeCases(t)
+
+	seen := make(map[string]bool)
+	for _, route := range routeTable(&util.App{}) {
+		name := route.Method + " " + route.Path
+		seen[name] = true
+
+		rc, ok := cases[name]
+		if !ok {
+			t.Errorf("%s: no test case", name)
+			continue
+		}
+
+		t.Run(name, func(t *testing.T) {
+			e := newTestEnv(t)
+			w := httptest.NewRecorder()
+			e.router.ServeHTTP(w, rc.request(t, e, route.Method, rc.as))
+			checkStatus(t, w, rc.want)
+			if rc.check != nil && !t.Failed() {
+				rc.check(t, e, w)
+			}
+		})
+
+		if rc.deniedAs == nil {
+			if route.Auth.Modes[0] != auth.Public {
+				t.Errorf("%s: no denied caller", name)
+			}
+			continue
+		}
+		t.Run(name+" denied", func(t *testing.T) {
+			e := newTestEnv(t)
+			w := httptest.NewRecorder()
+			e.router.ServeHTTP(w, rc.request(t, e, route.Method, rc.deniedAs))
+			checkDenied(t, w, rc.denied)
+			if rc.unchanged != nil {
+				rc.unchanged(t, e)
+			}
+		})
+	}
+
+	for name := range cases {
+		if !seen[name] {
+			t.Errorf("%s: test case for a route that doesn't exist", name)
+		}
+	}
+}
+
+// TestHiddenDestinations checks that nothing can be written into the API's
+// bookkeeping prefixes, even by an admin, whom grants don't limit.
+func TestHiddenDestinations(t *testing.T) {
+	for _, prefix := range []string{model.TrashPrefix, model.UploadsPrefix, model.QuarantinePrefix} {
+		t.Run(prefix, func(t *testing.T) {
+			e := newTestEnv(t)
+
+			var form bytes.Buffer
+			mw := multipart.NewWriter(&form)
+			part, err := mw.CreateFormFile("file", "a.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			part.Write([]byte("hello"))
+			mw.Close()
+
+			requests := []*http.Request{
+				httptest.NewRequest(http.MethodPost, "/files/upload?path="+prefix, &form),
+				httptest.NewRequest(http.MethodPost, "/files/uploads", strings.NewReader(`{"Path":"`+prefix+`b.txt","Size":5}`)),
+				httptest.NewRequest(http.MethodPost, "/files/create_folder", strings.NewReader(`{"Path":"`+prefix+`reports/"}`)),
+			}
+			requests[0].Header.Set("Content-Type", mw.FormDataContentType())
+			for _, r := range requests[1:] {
+				r.Header.Set("Content-Type", "application/json")
+			}
+
+			for _, r := range requests {
+				admin(t, e, r)
+				w := httptest.NewRecorder()
+				e.router.ServeHTTP(w, r)
+				if w.Code < http.StatusMultiStatus {
+					t.Errorf("%s %s: status %d, want it turned down: %s", r.Method, r.URL, w.Code, w.Body)
+				}
+			}
+
+			for _, name := range []string{prefix + "a.txt", prefix + "reports/"} {
+				if e.attrs(t, name) != nil {
+					t.Errorf("%s was written", name)
+				}
+			}
+		})
+	}
+}
+
+// TestStaffAccess checks that staff reach the bucket through StaffAccess
+// without a grant, and only through their grants once it is turned off.
+func TestStaffAccess(t *testing.T) {
+	tests := []struct {
+		access      model.Permission
+		read, write int
+	}{
+		{"", http.StatusForbidden, http.StatusForbidden},
+		{model.PermissionRead, http.StatusOK, http.StatusForbidden},
+		{model.PermissionShare, http.StatusOK, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.access), func(t *testing.T) {
+			e := newTestEnv(t)
+			grants, err := e.app.FolderGrants.ForPath("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, g := range grants {
+				if _, err := e.app.FolderGrants.Delete(g.Id); err != nil {
+					t.Fatal(err)
+				}
+			}
+			e.put(t, "docs/a.txt", []byte("hello"))
+			e.app.StaffAccess = tt.access
+
+			r := httptest.NewRequest(http.MethodGet, "/files?path=docs/", nil)
+			staff(t, e, r)
+			w := httptest.NewRecorder()
+			e.router.ServeHTTP(w, r)
+			checkStatus(t, w, tt.read)
+
+			r = httptest.NewRequest(http.MethodPost, "/files/create_folder", strings.NewReader(`{"Path":"reports/"}`))
+			r.Header.Set("Content-Type", "application/json")
+			staff(t, e, r)
+			w = httptest.NewRecorder()
+			e.router.ServeHTTP(w, r)
+			checkStatus(t, w, tt.write)
+			if created := e.attrs(t, "reports/") != nil; created != (tt.write == http.StatusOK) {
+				t.Errorf("reports/ created = %v, want %v", created, tt.write == http.StatusOK)
+			}
+		})
+	}
+}
+
+func checkStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if want == reachedHandler {
+		if w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden {
+			t.Errorf("status %d, want the handler's response: %s", w.Code, w.Body)
+		}
+		return
+	}
+	if w.Code != want {
+		t.Errorf("status %d, want %d: %s", w.Code, want, w.Body)
+	}
+}
+
+// checkDenied checks the status and that the body is the API's error
+// envelope with the matching code.
+func checkDenied(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	checkStatus(t, w, want)
+
+	code := apperror.CodeForbidden
+	if want == http.StatusUnauthorized {
+		code = apperror.CodeUnauthorized
+	}
+	var got struct {
+		Error     string        `json:"error"`
+		Code      apperror.Code `json:"code"`
+		RequestID string        `json:"request_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("error body %q: %v", w.Body, err)
+	}
+	if got.Code != code || got.Error == "" || got.RequestID == "" {
+		t.Errorf("error body = %+v, want code %s with a message and request id", got, code)
+	}
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body, err)
+	}
+}
+
+// emptyList checks for a JSON list with nothing in it, as the stand-in org
+// has no records.
+func emptyList(t *testing.T, _ *testEnv, w *httptest.ResponseRecorder) {
+	var got []json.RawMessage
+	decode(t, w, &got)
+	if len(got) != 0 {
+		t.Errorf("got %d records, want none", len(got))
+	}
+}
+
+// fileUntouched checks name is still where it was seeded, as it was.
+func fileUntouched(name string) func(t *testing.T, e *testEnv) {
+	return func(t *testing.T, e *testEnv) {
+		if got := e.read(t, name); got != "hello" {
+			t.Errorf("%s = %q, want hello", name, got)
+		}
+	}
+}
+
+// attrs returns name's attributes, or nil if there is no such file.
+func (e *testEnv) attrs(t *testing.T, name string) *model.BlobAttrs {
+	t.Helper()
+	attrs, err := e.app.Blobs.Attrs(context.Background(), name)
+	if apperror.Is(err, apperror.CodeNotFound) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return attrs
+}
+
+func (e *testEnv) read(t *testing.T, name string) string {
+	t.Helper()
+	r, _, err := e.app.Blobs.NewRangeReader(context.Background(), name, 0, -1)
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func (e *testEnv) trash(t *testing.T) []model.TrashItem {
+	t.Helper()
+	items, err := e.file().ListTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return items
+}
+
+func (e *testEnv) links(t *testing.T, recipient string) []model.FileLink {
+	t.Helper()
+	links, err := e.app.FileLinks.Find("", recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return links
+}
+
+func (e *testEnv) hasGrant(t *testing.T, id string) bool {
+	t.Helper()
+	grants, err := e.app.FolderGrants.ForPath("docs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range grants {
+		if g.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *testEnv) pinnedLinks(t *testing.T) []model.PinnedLink {
+	t.Helper()
+	links, err := e.app.PinnedLinks.ForUser(staffEmail, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return links
+}
+
+func (e *testEnv) events(t *testing.T) []model.Event {
+	t.Helper()
+	events, err := e.app.Events.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func (e *testEnv) issues(t *testing.T) []model.Issue {
+	t.Helper()
+	issues, err := e.app.Issues.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issues
+}
+
+func (e *testEnv) comments(t *testing.T) []model.Comment {
+	t.Helper()
+	comments, err := e.app.Comments.ForRecord(testRecordID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return comments
+}
+
+func (e *testEnv) user(t *testing.T, email string) *model.User {
+	t.Helper()
+	user, err := e.app.Users.FindByEmail(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func (e *testEnv) apiKeys(t *testing.T) []model.APIKey {
+	t.Helper()
+	keys, err := e.app.APIKeys.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// waitForActivity waits for the audit entry for action on path, which is
+// recorded after the response is written.
+func (e *testEnv) waitForActivity(t *testing.T, action, path string) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		entries, err := e.app.Activity.Find(context.Background(), model.ActivityQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if entry.Action == action && entry.Path == path {
+				return
+			}
+		}
+	}
+	t.Errorf("no %s activity recorded for %s", action, path)
+}
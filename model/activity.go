@@ -0,0 +1,111 @@
+package model
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// File activity actions.
+const (
+	ActivityUpload         = "upload"
+	ActivityUploadRejected = "upload_rejected"
+	ActivityCreateFolder   = "create_folder"
+	ActivityTrash          = "trash"
+	ActivityRestore        = "restore"
+	ActivityPurge          = "purge"
+	ActivityDelete         = "delete"
+	ActivityMove           = "move"
+	ActivityCopy           = "copy"
+	ActivityMakePublic     = "make_public"
+	ActivityMakePrivate    = "make_private"
+	ActivityShare          = "share"
+	ActivitySend           = "send"
+	ActivitySignedURL      = "signed_url"
+	ActivityArchive        = "archive"
+	ActivityLinkDownload   = "link_download"
+	ActivityPromote        = "promote_version"
+	ActivityTag            = "tag"
+	ActivityGrant          = "grant"
+	ActivityRevokeGrant    = "revoke_grant"
+)
+
+// FileActivity is one entry in the file audit log: one action on one path.
+type FileActivity struct {
+	At     time.Time `json:"At" bigquery:"At"`
+	Actor  string    `json:"Actor" bigquery:"Actor"`
+	Action string    `json:"Action" bigquery:"Action"`
+	Path   string    `json:"Path" bigquery:"Path"`
+	// Target is who the file went to: an email address, or a Salesforce
+	// record as "<Object>:<Id>".
+	Target string `json:"Target,omitempty" bigquery:"Target"`
+	// Detail says more about the action, such as where a file was moved
+	// to or why an upload was turned down.
+	Detail    string `json:"Detail,omitempty" bigquery:"Detail"`
+	RequestID string `json:"RequestID,omitempty" bigquery:"RequestID"`
+}
+
+// ActivityQuery selects audit log entries, newest first. Empty fields
+// match anything.
+type ActivityQuery struct {
+	Prefix string
+	Actor  string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+// Audit log pages hold DefaultActivityLimit entries unless asked otherwise.
+const (
+	DefaultActivityLimit = 100
+	MaxActivityLimit     = 1000
+)
+
+// ActivityLog stores the file audit log. BigQuery and in-memory
+// implementations live in the audit package.
+type ActivityLog interface {
+	Record(ctx context.Context, entries []FileActivity) error
+	Find(ctx context.Context, q ActivityQuery) ([]FileActivity, error)
+}
+
+// activityTimeout bounds how long recording one request's activity may take.
+const activityTimeout = 30 * time.Second
+
+// RecordActivity writes entries to activityLog after the request that made
+// them has returned, so a slow or failing log doesn't hold up file
+// operations. A nil log records nothing.
+func RecordActivity(activityLog ActivityLog, entries []FileActivity) {
+	if activityLog == nil || len(entries) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), activityTimeout)
+		defer cancel()
+		if err := activityLog.Record(ctx, entries); err != nil {
+			log.Printf("Failed to record %d file activity entries: %v", len(entries), err)
+		}
+	}()
+}
+
+// ArchiveActivity describes each file in an archive, for the log: one entry
+// per file, with Detail naming the archive it went into.
+func ArchiveActivity(action string, entries []ArchiveEntry, filename, target string) []FileActivity {
+	var activity []FileActivity
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name, "/") {
+			continue
+		}
+		activity = append(activity, FileActivity{Action: action, Path: e.Source.Name, Target: target, Detail: "in " + filename})
+	}
+	return activity
+}
+
+// PurgeActivity describes the purge of expired trash items, for the log.
+func PurgeActivity(purged []TrashItem) []FileActivity {
+	entries := make([]FileActivity, len(purged))
+	for n, item := range purged {
+		entries[n] = FileActivity{Action: ActivityPurge, Path: item.Path, Detail: "expired trash item " + item.Id}
+	}
+	return entries
+}
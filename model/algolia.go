@@ -1,8 +1,7 @@
 package model
 
 import (
-	"log"
-
+	"github.com/Proluxe/proluxe-common-api/salesforce"
 	"github.com/scottraio/simpleforce"
 )
 
@@ -11,72 +10,52 @@ type AlgoliaIndex struct {
 	Name     string `json:"Name"`
 }
 
-func FetchAlgoliaProducts(client *simpleforce.Client) []AlgoliaIndex {
+func FetchAlgoliaProducts(client *simpleforce.Client) ([]AlgoliaIndex, error) {
 	// Initial query
-	query := `
-		SELECT Id, Name
-		FROM rstk__soprod__c
-		WHERE rstk__soprod_activeind__c = true
-		ORDER BY CreatedDate DESC
-	`
+	query := salesforce.Select("Id", "Name").
+		From("rstk__soprod__c").
+		Where("rstk__soprod_activeind__c = ?", true).
+		OrderBy("CreatedDate DESC")
 
 	// Fetch records from Salesforce
-	records := FetchRecords(client, query)
-
-	// Return the records in AlgoliaIndex format
-	return records
+	return FetchRecords(client, query)
 }
 
-func FetchAlgoliaCustomers(client *simpleforce.Client) []AlgoliaIndex {
+func FetchAlgoliaCustomers(client *simpleforce.Client) ([]AlgoliaIndex, error) {
 	// Initial query
-	query := `
-		SELECT Id, Name
-		FROM rstk__socust__c
-		ORDER BY CreatedDate DESC
-	`
+	query := salesforce.Select("Id", "Name").
+		From("rstk__socust__c").
+		OrderBy("CreatedDate DESC")
 	// Fetch records from Salesforce
-	records := FetchRecords(client, query)
-
-	// Return the records in AlgoliaIndex format
-	return records
+	return FetchRecords(client, query)
 }
 
-func FetchAlgoliaContacts(client *simpleforce.Client) []AlgoliaIndex {
+func FetchAlgoliaContacts(client *simpleforce.Client) ([]AlgoliaIndex, error) {
 	// Initial query
-	query := `
-		SELECT Id, Display_Name__c
-		FROM Contact
-		ORDER BY CreatedDate DESC
-	`
+	query := salesforce.Select("Id", "Display_Name__c").
+		From("Contact").
+		OrderBy("CreatedDate DESC")
 
 	// Fetch records from Salesforce
-	records := FetchRecords(client, query, "Display_Name__c")
-
-	// Return the records in AlgoliaIndex format
-	return records
+	return FetchRecords(client, query, "Display_Name__c")
 }
 
-func FetchAlgoliaParts(client *simpleforce.Client) []AlgoliaIndex {
+func FetchAlgoliaParts(client *simpleforce.Client) ([]AlgoliaIndex, error) {
 	// Initial query
-	query := `
-		SELECT Id, Name
-		FROM rstk__icitem__c
-		ORDER BY CreatedDate DESC
-	`
+	query := salesforce.Select("Id", "Name").
+		From("rstk__icitem__c").
+		OrderBy("CreatedDate DESC")
 
 	// Fetch records from Salesforce
-	records := FetchRecords(client, query)
-
-	// Return the records in AlgoliaIndex format
-	return records
+	return FetchRecords(client, query)
 }
 
-func FetchRecords(client *simpleforce.Client, query string, nameField ...string) []AlgoliaIndex {
+func FetchRecords(client *simpleforce.Client, query salesforce.Query, nameField ...string) ([]AlgoliaIndex, error) {
 	var allRecords []simpleforce.SObject
 
-	result, err := client.Query(query)
+	result, err := query.Run(client)
 	if err != nil {
-		log.Fatalf("Error executing initial query: %v", err)
+		return nil, err
 	}
 	allRecords = append(allRecords, result.Records...)
 
@@ -84,7 +63,7 @@ func FetchRecords(client *simpleforce.Client, query string, nameField ...string)
 	for !result.Done && result.NextRecordsURL != "" {
 		result, err = client.QueryMore(result.NextRecordsURL)
 		if err != nil {
-			log.Fatalf("Error during QueryMore: %v", err)
+			return nil, salesforce.WrapError(err, "Salesforce query failed")
 		}
 		allRecords = append(allRecords, result.Records...)
 	}
@@ -96,15 +75,15 @@ func FetchRecords(client *simpleforce.Client, query string, nameField ...string)
 	}
 
 	// Convert to AlgoliaIndex format
-	return setAlgoliaIndexFromSObjectsWithField(allRecords, fieldName)
+	return setAlgoliaIndexFromSObjectsWithField(allRecords, fieldName), nil
 }
 
 func setAlgoliaIndexFromSObjectsWithField(records []simpleforce.SObject, nameField string) []AlgoliaIndex {
 	var products []AlgoliaIndex
 	for _, r := range records {
 		products = append(products, AlgoliaIndex{
-			ObjectId: r["Id"].(string),
-			Name:     r[nameField].(string),
+			ObjectId: getStringField("Id", r),
+			Name:     getStringField(nameField, r),
 		})
 	}
 	return products
@@ -0,0 +1,162 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/salesforce"
+	"github.com/scottraio/simpleforce"
+)
+
+// APIKeyPrefix starts every key we issue, so keys can be told apart from JWTs.
+const APIKeyPrefix = "plx_"
+
+// APIKey is a named credential for machine callers. Only a hash of the key is
+// stored; the plaintext is shown once when the key is minted.
+type APIKey struct {
+	Id         string    `json:"Id"`
+	Name       string    `json:"Name"`
+	Hash       string    `json:"-"`
+	Prefix     string    `json:"Prefix"`
+	Scopes     []string  `json:"Scopes"`
+	ExpiresAt  time.Time `json:"ExpiresAt"`
+	LastUsedAt time.Time `json:"LastUsedAt"`
+	Revoked    bool      `json:"Revoked"`
+	CreatedBy  string    `json:"CreatedBy"`
+}
+
+// NewAPIKey generates a key and returns it with its plaintext secret.
+func NewAPIKey(name string, scopes []string, expiresAt time.Time, createdBy string) (APIKey, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return APIKey{}, "", err
+	}
+	secret := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	return APIKey{
+		Name:      name,
+		Hash:      HashAPIKey(secret),
+		Prefix:    secret[:len(APIKeyPrefix)+6],
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedBy: createdBy,
+	}, secret, nil
+}
+
+func HashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Usable reports whether the key is neither revoked nor expired at now.
+func (k *APIKey) Usable(now time.Time) bool {
+	return !k.Revoked && (k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt))
+}
+
+// HasScopes reports whether the key holds every one of scopes.
+func (k *APIKey) HasScopes(scopes ...string) bool {
+	for _, want := range scopes {
+		found := false
+		for _, have := range k.Scopes {
+			if have == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func FetchAPIKeys(client *simpleforce.Client, q salesforce.Query) ([]APIKey, error) {
+	q = q.Select("Id", "Name", "Key_Hash__c", "Key_Prefix__c", "Scopes__c", "Expires_At__c", "Last_Used_At__c", "Revoked__c", "Created_By__c").
+		From("API_Key__c")
+
+	result, err := q.Run(client)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []APIKey
+	for _, record := range result.Records {
+		expiresAt, err := convertToTime(getStringField("Expires_At__c", record))
+		if err != nil {
+			return nil, err
+		}
+
+		lastUsedAt, err := convertToTime(getStringField("Last_Used_At__c", record))
+		if err != nil {
+			return nil, err
+		}
+
+		var scopes []string
+		if s := getStringField("Scopes__c", record); s != "" {
+			scopes = strings.Split(s, ";")
+		}
+
+		keys = append(keys, APIKey{
+			Id:         getStringField("Id", record),
+			Name:       getStringField("Name", record),
+			Hash:       getStringField("Key_Hash__c", record),
+			Prefix:     getStringField("Key_Prefix__c", record),
+			Scopes:     scopes,
+			ExpiresAt:  expiresAt,
+			LastUsedAt: lastUsedAt,
+			Revoked:    getBoolField("Revoked__c", record),
+			CreatedBy:  getStringField("Created_By__c", record),
+		})
+	}
+
+	return keys, nil
+}
+
+// CreateAPIKey saves key and returns its Id.
+func CreateAPIKey(client *simpleforce.Client, key APIKey) (string, error) {
+	sobj := client.SObject("API_Key__c").
+		Set("Name", key.Name).
+		Set("Key_Hash__c", key.Hash).
+		Set("Key_Prefix__c", key.Prefix).
+		Set("Scopes__c", strings.Join(key.Scopes, ";")).
+		Set("Created_By__c", key.CreatedBy)
+
+	if !key.ExpiresAt.IsZero() {
+		sobj.Set("Expires_At__c", key.ExpiresAt)
+	}
+
+	created := sobj.Create()
+	if created == nil || created.ID() == "" {
+		return "", apperror.Unavailable(nil, "Failed to create API key")
+	}
+	return created.ID(), nil
+}
+
+func RevokeAPIKey(client *simpleforce.Client, id string) error {
+	sobj := client.SObject("API_Key__c").
+		Set("Id", id).
+		Set("Revoked__c", true).
+		Update()
+
+	if sobj == nil {
+		return apperror.Unavailable(nil, "Failed to revoke API key %s", id)
+	}
+	return nil
+}
+
+func TouchAPIKey(client *simpleforce.Client, id string, at time.Time) error {
+	sobj := client.SObject("API_Key__c").
+		Set("Id", id).
+		Set("Last_Used_At__c", at).
+		Update()
+
+	if sobj == nil {
+		return apperror.Unavailable(nil, "Failed to update API key %s", id)
+	}
+	return nil
+}
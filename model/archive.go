@@ -0,0 +1,216 @@
+package model
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+)
+
+// ArchivesPrefix holds archives built to be sent as links. They are hidden
+// from listings and purged once their links expire.
+const ArchivesPrefix = ".archives/"
+
+const archiveExpiresKey = "archive-expires"
+
+// ArchiveLimits caps what goes into one archive. Zero means no limit.
+type ArchiveLimits struct {
+	MaxBytes int64
+	MaxFiles int
+}
+
+// ArchiveEntry is one object going into an archive, under Name.
+type ArchiveEntry struct {
+	Name   string
+	Source BlobAttrs
+}
+
+// PlanArchive works out what an archive of paths holds. A path ending in
+// "/" adds that folder and everything in it under the folder's own name;
+// any other path adds the file at the top of the archive. The archive is
+// turned down up front if it would break the limits.
+func (f *File) PlanArchive(paths []string, limits ArchiveLimits) ([]ArchiveEntry, error) {
+	if len(paths) == 0 {
+		return nil, apperror.Validation("Paths are required")
+	}
+
+	var entries []ArchiveEntry
+	sources := make(map[string]string)
+	var files int
+	var total int64
+
+	add := func(name string, a BlobAttrs) error {
+		if source, ok := sources[name]; ok {
+			if source == a.Name {
+				return nil
+			}
+			return apperror.Validation("%s and %s would both be stored as %s", source, a.Name, name)
+		}
+		sources[name] = a.Name
+		entries = append(entries, ArchiveEntry{Name: name, Source: a})
+		if !strings.HasSuffix(name, "/") {
+			files++
+		}
+		total += a.Size
+
+		if limits.MaxFiles > 0 && files > limits.MaxFiles {
+			return apperror.Validation("Archives may hold at most %d files", limits.MaxFiles)
+		}
+		if limits.MaxBytes > 0 && total > limits.MaxBytes {
+			return apperror.Validation("Archives may hold at most %d bytes", limits.MaxBytes)
+		}
+		return nil
+	}
+
+	for _, p := range paths {
+		if p == "" || p == "/" || IsHidden(p) {
+			return nil, apperror.Validation("Cannot archive %q", p)
+		}
+
+		if !strings.HasSuffix(p, "/") {
+			a, err := f.Store.Attrs(f.Context, p)
+			if err != nil {
+				return nil, storageError(err, "Failed to read %s", p)
+			}
+			if err := add(path.Base(p), *a); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		list, err := f.Store.List(f.Context, BlobQuery{Prefix: p})
+		if err != nil {
+			return nil, storageError(err, "Failed to list %s", p)
+		}
+		if len(list) == 0 {
+			return nil, apperror.NotFound("Folder %s not found", p)
+		}
+		root := path.Base(p) + "/"
+		for _, a := range list {
+			if IsHidden(a.Name) {
+				continue
+			}
+			if err := add(root+strings.TrimPrefix(a.Name, p), a); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entries, nil
+}
+
+// WriteArchive streams a ZIP of entries to w, reading each object as it
+// goes. Each file is read at the generation that was planned, so the
+// archive matches what was checked against the limits.
+func (f *File) WriteArchive(w io.Writer, entries []ArchiveEntry) error {
+	zw := zip.NewWriter(w)
+
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.Name, Modified: entry.Source.Updated}
+		if strings.HasSuffix(entry.Name, "/") {
+			if _, err := zw.CreateHeader(header); err != nil {
+				return err
+			}
+			continue
+		}
+
+		header.Method = zip.Store
+		if compressible(entry.Source.ContentType) {
+			header.Method = zip.Deflate
+		}
+		dst, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		src, _, err := f.Store.NewVersionReader(f.Context, entry.Source.Name, entry.Source.Generation, 0, -1)
+		if err != nil {
+			return storageError(err, "Failed to read %s", entry.Source.Name)
+		}
+		_, err = io.Copy(dst, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// compressible reports whether deflating content of the type is worth it.
+// Media and archives are already compressed.
+func compressible(contentType string) bool {
+	if strings.HasPrefix(contentType, "image/svg+xml") || strings.HasPrefix(contentType, "image/bmp") {
+		return true
+	}
+	for _, prefix := range []string{"image/", "video/", "audio/", "application/zip", "application/gzip", "application/x-7z", "application/x-rar"} {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// StoreArchive writes a ZIP of entries into the bucket as filename, to be
+// handed out as a link, and keeps it until expires. Every file in it must
+// have been scanned clean. It returns the archive's object name.
+func (f *File) StoreArchive(entries []ArchiveEntry, filename string, expires time.Time) (string, error) {
+	for _, entry := range entries {
+		if err := CheckScanned(&entry.Source); err != nil {
+			return "", err
+		}
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	objectName := ArchivesPrefix + hex.EncodeToString(b) + "/" + filename
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(f.WriteArchive(pw, entries))
+	}()
+
+	_, err := f.Store.Write(f.Context, objectName, pr, BlobWriteOptions{
+		ContentType: "application/zip",
+		Metadata:    map[string]string{archiveExpiresKey: expires.UTC().Format(time.RFC3339)},
+	})
+	// Unblock the writer if the store gave up first.
+	pr.CloseWithError(err)
+	if err != nil {
+		return "", storageError(err, "Failed to build archive")
+	}
+	return objectName, nil
+}
+
+// PurgeExpiredArchives deletes stored archives whose links have expired and
+// returns how many it removed.
+func (f *File) PurgeExpiredArchives(now time.Time) (int, error) {
+	list, err := f.Store.List(f.Context, BlobQuery{Prefix: ArchivesPrefix})
+	if err != nil {
+		return 0, storageError(err, "Failed to list archives")
+	}
+
+	purged := 0
+	for _, a := range list {
+		expires, err := time.Parse(time.RFC3339, a.Metadata[archiveExpiresKey])
+		if err != nil {
+			// No links outlive MaxLinkTTL.
+			expires = a.Created.Add(MaxLinkTTL)
+		}
+		if expires.After(now) {
+			continue
+		}
+		if err := f.Store.Delete(f.Context, a.Name); err != nil {
+			log.Printf("Failed to purge archive %s: %v", a.Name, err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
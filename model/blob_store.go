@@ -0,0 +1,122 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// BlobStore is the object storage behind the files API. Cloud Storage and
+// local-disk implementations live in the blob package. Methods that look up
+// a missing object return an apperror.CodeNotFound error.
+type BlobStore interface {
+	// Bucket names the bucket or directory objects are stored in.
+	Bucket() string
+	// List returns the objects under q.Prefix in name order. With a
+	// Delimiter, names that continue past it are rolled up into a single
+	// entry whose Prefix is set, like folders.
+	List(ctx context.Context, q BlobQuery) ([]BlobAttrs, error)
+	Attrs(ctx context.Context, name string) (*BlobAttrs, error)
+	// NewRangeReader reads length bytes from offset, or to the end of the
+	// object if length is negative.
+	NewRangeReader(ctx context.Context, name string, offset, length int64) (io.ReadCloser, *BlobAttrs, error)
+	// Write replaces the object with the contents of r.
+	Write(ctx context.Context, name string, r io.Reader, opts BlobWriteOptions) (*BlobAttrs, error)
+	Delete(ctx context.Context, name string) error
+	// Copy copies src to dst, metadata included.
+	Copy(ctx context.Context, src, dst string) (*BlobAttrs, error)
+	// Versions returns every stored generation of the object, newest first.
+	// Generations that were overwritten or deleted are only there if the
+	// bucket keeps them; they have Archived set.
+	Versions(ctx context.Context, name string) ([]BlobAttrs, error)
+	// NewVersionReader is NewRangeReader for one generation, live or not.
+	NewVersionReader(ctx context.Context, name string, generation, offset, length int64) (io.ReadCloser, *BlobAttrs, error)
+	// CopyVersion copies one generation of src to dst, metadata included.
+	CopyVersion(ctx context.Context, src string, generation int64, dst string) (*BlobAttrs, error)
+	// UpdateMetadata merges metadata into the object's custom metadata. An
+	// empty value removes the key.
+	UpdateMetadata(ctx context.Context, name string, metadata map[string]string) (*BlobAttrs, error)
+	// SetPublic grants or removes anonymous read access to the object.
+	SetPublic(ctx context.Context, name string, public bool) error
+	// PublicURL is where the object can be read once it is public.
+	PublicURL(name string) string
+	// SignedURL returns a URL anyone can GET the object from until
+	// opts.Expires, without making the object public.
+	SignedURL(name string, opts SignedURLOptions) (string, error)
+}
+
+type BlobQuery struct {
+	Prefix    string
+	Delimiter string
+	// StartAfter skips entries named up to and including it.
+	StartAfter string
+	// Limit caps the entries returned. Zero means no limit.
+	Limit int
+}
+
+// BlobAttrs describes an object, or a folder when only Prefix is set.
+type BlobAttrs struct {
+	Name        string
+	Prefix      string
+	Size        int64
+	ContentType string
+	Created     time.Time
+	Updated     time.Time
+	Generation  int64
+	MD5         []byte
+	CRC32C      uint32
+	Metadata    map[string]string
+	Public      bool
+	// Archived is when a past generation was overwritten or deleted. It is
+	// zero for the live one.
+	Archived time.Time
+}
+
+type SignedURLOptions struct {
+	Expires time.Time
+	// Filename, when set, makes the URL download the object under that name.
+	Filename string
+}
+
+type BlobWriteOptions struct {
+	// ContentType is detected from the name and content when empty.
+	ContentType string
+	Metadata    map[string]string
+	// MD5 and CRC32C, when set, are checked by the store before the write
+	// takes effect; a mismatch leaves any existing object in place.
+	MD5    []byte
+	CRC32C *uint32
+}
+
+// ContentDisposition builds a Content-Disposition header value with an ASCII
+// fallback filename and the exact name encoded per RFC 5987.
+func ContentDisposition(disposition, filename string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+
+	var encoded strings.Builder
+	for _, b := range []byte(filename) {
+		if isAttrChar(b) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, disposition, fallback, encoded.String())
+}
+
+// isAttrChar reports whether b may appear unescaped in an RFC 5987 value.
+func isAttrChar(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
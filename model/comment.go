@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/salesforce"
 	services "github.com/Proluxe/proluxe-common-api/services"
 	"github.com/scottraio/simpleforce"
 )
@@ -22,17 +24,14 @@ type Comment struct {
 }
 
 // Fetch cases from Salesforce
-func FetchComments(client *simpleforce.Client, whereCondition string) []Comment {
+func FetchComments(client *simpleforce.Client, q salesforce.Query) ([]Comment, error) {
 	// Construct the query to fetch cases
-	q := fmt.Sprintf(`
-		SELECT Id, Created_By__c, Message__c, Name, Record_ID__c, Avatar__c, Record_Type__c, Record_Name__c, Created_By_Name__c
-		FROM Comment__c
-		WHERE %s
-	`, whereCondition)
+	q = q.Select("Id", "Created_By__c", "Message__c", "Name", "Record_ID__c", "Avatar__c", "Record_Type__c", "Record_Name__c", "Created_By_Name__c").
+		From("Comment__c")
 
-	result, err := client.Query(q)
+	result, err := q.Run(client)
 	if err != nil {
-		log.Fatal("Error fetching comments: ", err)
+		return nil, err
 	}
 
 	var comments []Comment
@@ -54,11 +53,11 @@ func FetchComments(client *simpleforce.Client, whereCondition string) []Comment
 		comments = append(comments, c)
 	}
 
-	return comments
+	return comments, nil
 }
 
-func (c *Comment) Create(client *simpleforce.Client) {
-	sobj := client.SObject("Comment__c").
+func (c *Comment) Create(client *simpleforce.Client) error {
+	created := client.SObject("Comment__c").
 		Set("Created_By__c", c.CreatedBy).
 		Set("Message__c", c.Message).
 		Set("Name", truncateString(c.Message, 50)).
@@ -66,7 +65,14 @@ func (c *Comment) Create(client *simpleforce.Client) {
 		Set("Record_Type__c", c.RecordType).
 		Set("Avatar__c", c.Avatar).
 		Set("Created_By_Name__c", c.CreatedByName).
-		Create().Get()
+		Create()
+
+	if created == nil {
+		return apperror.Unavailable(nil, "Failed to create comment on %s", c.RecordID)
+	}
+
+	sobj := created.Get()
+	c.Id = sobj.ID()
 
 	// Add mentions
 	mentions := []CommentMention{}
@@ -85,7 +91,7 @@ func (c *Comment) Create(client *simpleforce.Client) {
 		})
 	}
 
-	AddMentions(client, mentions)
+	return AddMentions(client, mentions)
 }
 
 func truncateString(str string, num int) string {
@@ -95,8 +101,11 @@ func truncateString(str string, num int) string {
 	return str
 }
 
-func (c *Comment) SendNotificationEmail(client *simpleforce.Client) {
-	name := c.GetRecordName(client)
+func (c *Comment) SendNotificationEmail(comments CommentRepository, mentions MentionRepository) {
+	name, err := comments.RecordName(c.RecordType, c.RecordID)
+	if err != nil {
+		log.Println("Error fetching record name: ", err)
+	}
 
 	knock := services.Knock{
 		WorkFlowId: "new-comment",
@@ -107,21 +116,24 @@ func (c *Comment) SendNotificationEmail(client *simpleforce.Client) {
 
 	knock.Identify()
 
-	users := FetchMentions(client, fmt.Sprintf("Record_ID__c = '%s' AND Record_Type__c = '%s'", c.RecordID, c.RecordType))
+	users, err := mentions.ForRecordOfType(c.RecordID, c.RecordType)
+	if err != nil {
+		log.Println("Error fetching mentions: ", err)
+	}
 
 	recipients := make([]string, len(users))
 	for i, user := range users {
 		recipients[i] = user.Email
 	}
 
-	err := knock.Trigger(recipients, map[string]any{
+	err = knock.Trigger(recipients, map[string]any{
 		"Name":       name,
 		"Message":    c.Message,
 		"From":       c.CreatedBy,
 		"FromName":   c.CreatedByName,
 		"Object":     c.normalizeObjectName(),
 		"ObjectName": c.RecordName,
-		"Url":        c.LinkToRecord(client),
+		"Url":        c.LinkToRecord(),
 		"Domain":     "https://crm.proluxe.com",
 	})
 
@@ -132,21 +144,23 @@ func (c *Comment) SendNotificationEmail(client *simpleforce.Client) {
 
 func (c *Comment) Delete(client *simpleforce.Client) error {
 	err := client.SObject("Comment__c").Set("Id", c.Id).Delete()
-	return err
+	return salesforce.WrapError(err, "Failed to delete comment")
 }
 
-func (c *Comment) GetRecordName(client *simpleforce.Client) string {
-	q := fmt.Sprintf(`
-		SELECT Name
-		FROM %s
-		WHERE Id = '%s'
-	`, c.RecordType, c.RecordID)
-
-	result, err := client.Query(q)
+func (c *Comment) GetRecordName(client *simpleforce.Client) (string, error) {
+	result, err := salesforce.Select("Name").
+		From(c.RecordType).
+		Where("Id = ?", c.RecordID).
+		Run(client)
 	if err != nil {
-		log.Fatal("Error fetching record name: ", err)
+		return "", err
 	}
-	return getStringField("Name", result.Records[0])
+
+	if len(result.Records) == 0 {
+		return "", apperror.NotFound("%s %s not found", c.RecordType, c.RecordID)
+	}
+
+	return getStringField("Name", result.Records[0]), nil
 }
 
 func (c *Comment) normalizeObjectName() string {
@@ -167,7 +181,7 @@ func (c *Comment) normalizeObjectName() string {
 	return "Record"
 }
 
-func (c *Comment) LinkToRecord(client *simpleforce.Client) string {
+func (c *Comment) LinkToRecord() string {
 	recordLinks := map[string]string{
 		"Lead":            "leads",
 		"Opportunity":     "opportunities",
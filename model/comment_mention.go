@@ -5,6 +5,8 @@ import (
 	"log"
 	"strings"
 
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/salesforce"
 	"github.com/scottraio/simpleforce"
 )
 
@@ -17,17 +19,14 @@ type CommentMention struct {
 }
 
 // Fetch cases from Salesforce
-func FetchMentions(client *simpleforce.Client, whereCondition string) []CommentMention {
+func FetchMentions(client *simpleforce.Client, q salesforce.Query) ([]CommentMention, error) {
 	// Construct the query to fetch cases
-	q := fmt.Sprintf(`
-		SELECT Id, Email__c, Record_ID__c, Record_Type__c
-		FROM Comment_Mentioned_User__c
-		WHERE %s
-	`, whereCondition)
+	q = q.Select("Id", "Email__c", "Record_ID__c", "Record_Type__c").
+		From("Comment_Mentioned_User__c")
 
-	result, err := client.Query(q)
+	result, err := q.Run(client)
 	if err != nil {
-		log.Fatal("Error fetching mentions: ", err)
+		return nil, err
 	}
 
 	var mentions []CommentMention
@@ -45,7 +44,7 @@ func FetchMentions(client *simpleforce.Client, whereCondition string) []CommentM
 		mentions = append(mentions, c)
 	}
 
-	return mentions
+	return mentions, nil
 }
 
 // AddMentions adds multiple mentions, ensuring they don't already exist based on RecordType and RecordID.
@@ -56,21 +55,19 @@ func AddMentions(client *simpleforce.Client, mentions []CommentMention) error {
 
 	// Constructing a WHERE clause for batch checking
 	var conditions []string
+	var args []interface{}
 	for _, mention := range mentions {
-		conditions = append(conditions, fmt.Sprintf("(Record_ID__c = '%s' AND Record_Type__c = '%s' AND Email__c = '%s')",
-			mention.RecordID, mention.RecordType, mention.Email))
+		conditions = append(conditions, "(Record_ID__c = ? AND Record_Type__c = ? AND Email__c = ?)")
+		args = append(args, mention.RecordID, mention.RecordType, mention.Email)
 	}
 
 	// Query to check existing mentions in batch
-	query := fmt.Sprintf(`
-		SELECT Record_ID__c, Record_Type__c, Email__c 
-		FROM Comment_Mentioned_User__c
-		WHERE %s
-	`, strings.Join(conditions, " OR "))
-
-	existingRecords, err := client.Query(query)
+	existingRecords, err := salesforce.Select("Record_ID__c", "Record_Type__c", "Email__c").
+		From("Comment_Mentioned_User__c").
+		Where(strings.Join(conditions, " OR "), args...).
+		Run(client)
 	if err != nil {
-		return fmt.Errorf("error checking existing mentions: %v", err)
+		return err
 	}
 
 	// Create a map of existing mentions for quick lookup
@@ -93,13 +90,17 @@ func AddMentions(client *simpleforce.Client, mentions []CommentMention) error {
 		}
 
 		// Create a new mention
-		client.SObject("Comment_Mentioned_User__c").
+		created := client.SObject("Comment_Mentioned_User__c").
 			Set("Record_ID__c", mention.RecordID).
 			Set("Record_Type__c", mention.RecordType).
 			Set("Email__c", mention.Email).
 			Set("Comment__c", mention.CommentId).
 			Create()
 
+		if created == nil {
+			return apperror.Unavailable(nil, "Failed to add mention for %s", mention.Email)
+		}
+
 		log.Printf("Mention added successfully: RecordID %s, RecordType %s, Email %s Comment Id %s", mention.RecordID, mention.RecordType, mention.Email, mention.CommentId)
 	}
 
@@ -108,8 +109,5 @@ func AddMentions(client *simpleforce.Client, mentions []CommentMention) error {
 
 func (c *CommentMention) Delete(client *simpleforce.Client) error {
 	err := client.SObject("Comment_Mentioned_User__c").Set("Id", c.Id).Delete()
-	if err != nil {
-		return fmt.Errorf("failed to delete comment mention: %v", err)
-	}
-	return nil
+	return salesforce.WrapError(err, "Failed to delete comment mention")
 }
@@ -1,7 +1,7 @@
 package model
 
 import (
-	"log"
+	"fmt"
 	"regexp"
 	"time"
 
@@ -115,13 +115,17 @@ func getDateField(fieldName string, record map[string]interface{}) civil.Date {
 	return civil.Date{}
 }
 
-func convertToTime(date string) time.Time {
+func convertToTime(date string) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, nil
+	}
+
 	layout := "2006-01-02T15:04:05.000-0700"
 	t, err := time.Parse(layout, date)
 	if err != nil {
-		log.Fatal("Error: ", err)
+		return time.Time{}, fmt.Errorf("invalid datetime %q: %w", date, err)
 	}
-	return t
+	return t, nil
 }
 
 func isSalesforceID(id string) bool {
@@ -150,15 +154,15 @@ func parseFloat(value interface{}) float64 {
 	return 0
 }
 
-func convertToDate(date string) time.Time {
+func convertToDate(date string) (time.Time, error) {
 	if date == "" {
-		return time.Time{}
+		return time.Time{}, nil
 	}
 
 	layout := "2006-01-02"
 	t, err := time.Parse(layout, date)
 	if err != nil {
-		log.Fatal("Error: ", err)
+		return time.Time{}, fmt.Errorf("invalid date %q: %w", date, err)
 	}
-	return t
+	return t, nil
 }
@@ -2,10 +2,11 @@ package model
 
 import (
 	"fmt"
-	"log"
 	"sort"
 	"time"
 
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/salesforce"
 	"github.com/scottraio/simpleforce"
 )
 
@@ -29,23 +30,30 @@ type Event struct {
 	TextAddress      string    `json:"TextAddress"`
 }
 
-func FetchEvents(client *simpleforce.Client, whereCondition string) []Event {
+func FetchEvents(client *simpleforce.Client, q salesforce.Query) ([]Event, error) {
 	// Construct the query to fetch events
-	q := fmt.Sprintf(`
-		SELECT Id, Name, Address_Line_1__c, Address_Line_2__c, City__c, State_Province__c, Zip_Code__c, Description__c, 
-		Start_Date_Time__c, End_Date_Time__c, Type__c, CreatedById, LastModifiedById, OwnerId, Travel__c, Informational__c, TextAddress__c
-		FROM Events__c
-		WHERE %s
-	`, whereCondition)
-
-	result, err := client.Query(q)
+	q = q.Select("Id", "Name", "Address_Line_1__c", "Address_Line_2__c", "City__c", "State_Province__c", "Zip_Code__c", "Description__c",
+		"Start_Date_Time__c", "End_Date_Time__c", "Type__c", "CreatedById", "LastModifiedById", "OwnerId", "Travel__c", "Informational__c", "TextAddress__c").
+		From("Events__c")
+
+	result, err := q.Run(client)
 	if err != nil {
-		log.Fatal("Error: ", err)
+		return nil, err
 	}
 
 	var events []Event
 
 	for _, record := range result.Records {
+		start, err := convertToTime(getStringField("Start_Date_Time__c", record))
+		if err != nil {
+			return nil, err
+		}
+
+		end, err := convertToTime(getStringField("End_Date_Time__c", record))
+		if err != nil {
+			return nil, err
+		}
+
 		// Populate the Event struct
 		event := Event{
 			Id:               getStringField("Id", record),
@@ -56,8 +64,8 @@ func FetchEvents(client *simpleforce.Client, whereCondition string) []Event {
 			StateProvince:    getStringField("State_Province__c", record),
 			ZipCode:          getStringField("Zip_Code__c", record),
 			Description:      getStringField("Description__c", record),
-			StartDateTime:    convertToTime(record["Start_Date_Time__c"].(string)),
-			EndDateTime:      convertToTime(record["End_Date_Time__c"].(string)),
+			StartDateTime:    start,
+			EndDateTime:      end,
 			Type:             getStringField("Type__c", record),
 			CreatedById:      getStringField("CreatedById", record),
 			LastModifiedById: getStringField("LastModifiedById", record),
@@ -70,20 +78,36 @@ func FetchEvents(client *simpleforce.Client, whereCondition string) []Event {
 		events = append(events, event)
 	}
 
-	return events
+	return events, nil
 }
 
-func CreateEvent(client *simpleforce.Client, newEvent Event) *simpleforce.SObject {
+// CreateEvent saves newEvent and returns its Id.
+func CreateEvent(client *simpleforce.Client, newEvent Event) (string, error) {
 	fmt.Printf("Creating new event: %v\n", newEvent)
-	return SetEvent(client, newEvent).
+	sobj := SetEvent(client, newEvent).
 		Create()
+
+	if sobj == nil {
+		return "", apperror.Unavailable(nil, "Failed to create event")
+	}
+	return sobj.ID(), nil
 }
 
-func UpdateEvent(client *simpleforce.Client, event Event) *simpleforce.SObject {
+func UpdateEvent(client *simpleforce.Client, event Event) error {
 	fmt.Printf("Updating event: %v\n", event)
-	return SetEvent(client, event).
+	sobj := SetEvent(client, event).
 		Set("Id", event.Id).
 		Update()
+
+	if sobj == nil {
+		return apperror.Unavailable(nil, "Failed to update event %s", event.Id)
+	}
+	return nil
+}
+
+func DeleteEvent(client *simpleforce.Client, id string) error {
+	err := client.SObject("Events__c").Set("Id", id).Delete()
+	return salesforce.WrapError(err, "Failed to delete event")
 }
 
 func SetEvent(client *simpleforce.Client, event Event) *simpleforce.SObject {
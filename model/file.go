@@ -2,25 +2,23 @@ package model
 
 import (
 	"context"
-	"fmt"
 	"log"
-	"net/http"
-	"net/url"
 	"path/filepath"
+	"strings"
+	"time"
 
-	"cloud.google.com/go/storage"
-	"google.golang.org/api/iterator"
-
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/salesforce"
 	"github.com/Proluxe/proluxe-common-api/services"
-	"github.com/Proluxe/proluxe-common-api/util"
 	"github.com/gin-gonic/gin"
 	"github.com/scottraio/simpleforce"
 )
 
 type File struct {
-	Client     *storage.Client
+	Store      BlobStore
 	Context    context.Context
-	SF         *simpleforce.Client
+	Shares     SharedFileRepository
+	Links      FileLinkRepository
 	GinContext *gin.Context
 }
 
@@ -37,38 +35,47 @@ type SharedItem struct {
 	ObjectName string `json:"ObjectName"`
 }
 
-func New(c *gin.Context, App *util.App) *File {
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
-
-	if err != nil {
-		log.Printf("Failed to create client: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to storage client"})
-		return nil
-	}
-
-	defer client.Close()
-
+func New(c *gin.Context, store BlobStore, repos Repositories) *File {
 	return &File{
-		Client:     client,
-		Context:    ctx,
-		SF:         App.SF.Client,
+		Store:      store,
+		Context:    c.Request.Context(),
+		Shares:     repos.SharedFiles,
+		Links:      repos.FileLinks,
 		GinContext: c,
 	}
 }
 
+// storageError passes typed errors such as not found through and wraps the
+// rest as upstream failures.
+func storageError(err error, format string, args ...interface{}) error {
+	if _, ok := err.(*apperror.Error); ok {
+		return err
+	}
+	return apperror.Unavailable(err, format, args...)
+}
+
 // SF Functions
 
-func FetchFiles(client *simpleforce.Client, whereCondition string) []SharedFile {
-	q := fmt.Sprintf(`
-		SELECT Object__c, Object_Id__c, Path__c, Object_Name__c
-		FROM CXP_File__c
-		WHERE %s
-	`, whereCondition)
+// sharedFileObject holds the shares of bucket files with Salesforce records.
+const sharedFileObject = "CXP_File__c"
 
-	result, err := client.Query(q)
+// legacySharedFileObject is where /files/share used to create shares, though
+// they were only ever read from sharedFileObject. Its records are moved over
+// by SharedFileRepository.MigrateLegacy.
+const legacySharedFileObject = "common_File__c"
+
+// MaxShareMigration is the most shares MigrateLegacy moves in one call. Each
+// takes an upsert and a delete, so a batch stays inside a request's budget
+// of Salesforce calls.
+const MaxShareMigration = 40
+
+func FetchFiles(client *simpleforce.Client, q salesforce.Query) ([]SharedFile, error) {
+	q = q.Select("Object__c", "Object_Id__c", "Path__c", "Object_Name__c").
+		From(sharedFileObject)
+
+	result, err := q.Run(client)
 	if err != nil {
-		log.Fatal("Error: ", err)
+		return nil, err
 	}
 
 	var files []SharedFile
@@ -83,139 +90,86 @@ func FetchFiles(client *simpleforce.Client, whereCondition string) []SharedFile
 		files = append(files, f)
 	}
 
-	return files
+	return files, nil
 }
 
 // Instance Functions
 
-func (f *File) DeleteFile(bucketName, objectName string) error {
-	o := f.Client.Bucket(bucketName).Object(objectName)
-	if err := o.Delete(f.Context); err != nil {
-		return fmt.Errorf("failed to delete object: %v", err)
+func (f *File) DeleteFile(objectName string) error {
+	if err := f.Store.Delete(f.Context, objectName); err != nil {
+		return storageError(err, "Failed to delete file")
 	}
 
-	err := f.deleteSharedFile(objectName)
+	err := f.Shares.DeleteByPath(objectName)
 	if err != nil {
 		log.Printf("Error Deleting from Salesforce: %v\n", err)
-		f.GinContext.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete files from Salesforce"})
+		return err
 	}
 
 	return nil
 }
 
-func (f *File) deleteSharedFile(path string) error {
-	// Delete the corresponding files from Salesforce
-	q := fmt.Sprintf(`
-		SELECT Id
-		FROM CXP_File__c
-		WHERE Path__c = '%s'
-	`, path)
+// DeletePermanently deletes the file at objectName, or the folder and
+// everything under it when objectName ends in "/", along with their shares.
+func (f *File) DeletePermanently(objectName string) ([]ObjectResult, error) {
+	if !strings.HasSuffix(objectName, "/") {
+		if err := f.DeleteFile(objectName); err != nil {
+			return nil, err
+		}
+		return []ObjectResult{{Source: objectName}}, nil
+	}
 
-	result, err := f.SF.Query(q)
+	list, err := f.Store.List(f.Context, BlobQuery{Prefix: objectName})
 	if err != nil {
-		return err
+		return nil, storageError(err, "Failed to list %s", objectName)
+	}
+	if len(list) == 0 {
+		return nil, apperror.NotFound("Folder %s not found", objectName)
 	}
 
-	for _, record := range result.Records {
-		if err := f.SF.SObject("CXP_File__c").Set("Id", record["Id"].(string)).Delete(); err != nil {
-			return err
+	results := make([]ObjectResult, len(list))
+	for n, obj := range list {
+		results[n] = ObjectResult{Source: obj.Name}
+		if err := f.DeleteFile(obj.Name); err != nil {
+			results[n].Err = err
 		}
 	}
-
-	return nil
+	return results, nil
 }
 
-// Helper functions
-func (f *File) ListBucketContents(bucketName, path string) ([]map[string]interface{}, error) {
-	it := f.Client.Bucket(bucketName).Objects(f.Context, &storage.Query{Prefix: path, Delimiter: "/"})
-
-	var contents []map[string]interface{}
-	dirMap := make(map[string]struct{}) // To keep track of unique directories
-
-	for {
-		objAttrs, err := it.Next()
-
-		var isPublic bool
-		if objAttrs != nil {
-			for _, acl := range objAttrs.ACL {
-				if acl.Entity == "allUsers" {
-					isPublic = true
-					break
-				}
-			}
-		}
-
-		if err == iterator.Done {
-			break
-		}
-
-		if err != nil {
-			return nil, fmt.Errorf("failed to iterate bucket contents: %v", err)
-		}
-
-		// Add files to the list
-		if objAttrs.Name != "" && objAttrs.Name != path {
-			content := map[string]interface{}{
-				"name":     objAttrs.Name,
-				"size":     objAttrs.Size,
-				"updated":  objAttrs.Updated,
-				"isPublic": isPublic,
-			}
-			contents = append(contents, content)
-		}
+// hiddenPrefixes hold the API's own bookkeeping and are left out of listings.
+var hiddenPrefixes = []string{UploadsPrefix, TrashPrefix, ArchivesPrefix, DerivativesPrefix, HashesPrefix, QuarantinePrefix}
 
-		// Capture directories by checking for prefixes within object names
-		if len(objAttrs.Prefix) > 0 {
-			if _, exists := dirMap[objAttrs.Prefix]; !exists {
-				dirMap[objAttrs.Prefix] = struct{}{}
-				contents = append(contents, map[string]interface{}{
-					"name":    objAttrs.Prefix,
-					"size":    nil, // Directories don't have a size
-					"updated": nil, // Directories don't have an updated timestamp
-				})
-			}
-		}
-	}
+// IsHidden reports whether name is in one of the API's bookkeeping prefixes.
+func IsHidden(name string) bool {
+	return hiddenPrefix(name) != ""
+}
 
-	return contents, nil
+// pastHidden is where a name-ordered listing that ran into the hidden
+// prefix of name picks up again: "/" sorts just before "0".
+func pastHidden(name string) string {
+	return strings.TrimSuffix(hiddenPrefix(name), "/") + "0"
 }
 
-func (f *File) CreateFolder(bucketName, folderName string) error {
-	wc := f.Client.Bucket(bucketName).Object(folderName).NewWriter(f.Context)
-	if err := wc.Close(); err != nil {
-		return fmt.Errorf("failed to create folder: %v", err)
+func hiddenPrefix(name string) string {
+	for _, prefix := range hiddenPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return prefix
+		}
 	}
-
-	return nil
+	return ""
 }
 
-func (f *File) FetchFilesForPath(folderPath string) []SharedFile {
-	escapedFolderPath := url.PathEscape(folderPath)
-
-	q := fmt.Sprintf(`
-		SELECT Object__c, Object_Id__c, Path__c, Object_Name__c
-		FROM CXP_File__c
-		WHERE Path__c LIKE '%%%s%%'
-	`, escapedFolderPath)
-
-	result, err := f.SF.Query(q)
-	if err != nil {
-		log.Fatal("Error: ", err)
+// Helper functions
+func (f *File) CreateFolder(folderName string) error {
+	if IsHidden(folderName) {
+		return apperror.Validation("Path is reserved")
 	}
-
-	var files []SharedFile
-	for _, record := range result.Records {
-		f := SharedFile{
-			Object:     getStringField("Object__c", record),
-			ObjectId:   getStringField("Object_Id__c", record),
-			ObjectName: getStringField("Object_Name__c", record),
-			Path:       getStringField("Path__c", record),
-		}
-
-		files = append(files, f)
+	if _, err := f.Store.Write(f.Context, folderName, strings.NewReader(""), BlobWriteOptions{}); err != nil {
+		return storageError(err, "Failed to create folder")
 	}
 
-	return files
+	return nil
 }
 
 func (f *File) SendFileShareConfirmationEmail(from, path string, sharedItems []SharedItem) error {
@@ -238,7 +192,48 @@ func (f *File) SendFileShareConfirmationEmail(from, path string, sharedItems []S
 	})
 }
 
-func (f *File) SendFile(from, address, path, url string) error {
+// MaxLinkTTL is the longest a signed link can stay valid, the V4 signing limit.
+const MaxLinkTTL = 7 * 24 * time.Hour
+
+// IssueLink signs a download URL for the object and records who it was
+// issued by and to.
+func (f *File) IssueLink(objectName, recipient, filename, issuedBy string, ttl time.Duration) (string, *FileLink, error) {
+	if ttl <= 0 || ttl > MaxLinkTTL {
+		return "", nil, apperror.Validation("Links must expire within %s", MaxLinkTTL)
+	}
+
+	attrs, err := f.Store.Attrs(f.Context, objectName)
+	if err != nil {
+		return "", nil, storageError(err, "Failed to read file")
+	}
+	// Archives were checked file by file as they were built.
+	if !strings.HasPrefix(objectName, ArchivesPrefix) {
+		if err := CheckScanned(attrs); err != nil {
+			return "", nil, err
+		}
+	}
+
+	link := &FileLink{
+		Path:      objectName,
+		Recipient: recipient,
+		Filename:  filename,
+		ExpiresAt: time.Now().Add(ttl).UTC(),
+		IssuedBy:  issuedBy,
+	}
+
+	url, err := f.Store.SignedURL(objectName, SignedURLOptions{Expires: link.ExpiresAt, Filename: filename})
+	if err != nil {
+		return "", nil, apperror.Unavailable(err, "Failed to sign file link")
+	}
+
+	if err := f.Links.Create(link); err != nil {
+		return "", nil, err
+	}
+
+	return url, link, nil
+}
+
+func (f *File) SendFile(from, address, path, url string, expiresAt time.Time) error {
 	fileName := filepath.Base(path)
 
 	knock := services.Knock{
@@ -250,34 +245,33 @@ func (f *File) SendFile(from, address, path, url string) error {
 	recipients := []string{address}
 
 	return knock.Trigger(recipients, map[string]interface{}{
-		"Name": fileName,
-		"To":   address,
-		"URL":  url,
+		"Name":      fileName,
+		"To":        address,
+		"URL":       url,
+		"ExpiresAt": expiresAt,
 	})
 }
 
-func (f *File) MarkPublic(bucketName, objectName string) (string, error) {
-	// Get a reference to the object
-	obj := f.Client.Bucket(bucketName).Object(objectName)
-
-	// Set the ACL (Access Control List) to make the object publicly readable
-	if err := obj.ACL().Set(f.Context, storage.AllUsers, storage.RoleReader); err != nil {
-		return "", fmt.Errorf("failed to set object as public: %v", err)
+// MarkPublic lets anyone read the file, once it has been scanned clean.
+func (f *File) MarkPublic(objectName string) (string, error) {
+	attrs, err := f.Store.Attrs(f.Context, objectName)
+	if err != nil {
+		return "", storageError(err, "Failed to read file")
+	}
+	if err := CheckScanned(attrs); err != nil {
+		return "", err
 	}
 
-	// Construct and return the public URL for the object
-	publicURL := fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucketName, objectName)
-	return publicURL, nil
+	if err := f.Store.SetPublic(f.Context, objectName, true); err != nil {
+		return "", storageError(err, "Failed to mark file as public")
+	}
 
+	return f.Store.PublicURL(objectName), nil
 }
 
-func (f *File) MarkPrivate(bucketName, objectName string) error {
-	// Get a reference to the object
-	obj := f.Client.Bucket(bucketName).Object(objectName)
-
-	// Set the ACL (Access Control List) to make the object private
-	if err := obj.ACL().Delete(f.Context, storage.AllUsers); err != nil {
-		return fmt.Errorf("failed to set object as private: %v", err)
+func (f *File) MarkPrivate(objectName string) error {
+	if err := f.Store.SetPublic(f.Context, objectName, false); err != nil {
+		return storageError(err, "Failed to mark file as private")
 	}
 
 	return nil
@@ -0,0 +1,197 @@
+package model
+
+import (
+	"encoding/base64"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+)
+
+// contentHashKey holds the hex SHA-256 of a file's content in its metadata.
+const contentHashKey = "sha256"
+
+// HashesPrefix indexes files by content, with an empty marker per file at
+// .hashes/<sha256>/<file>, so copies of an upload can be found without
+// scanning the bucket. Markers can outlive their file; they are checked
+// against it when read.
+const HashesPrefix = ".hashes/"
+
+// maxDuplicateScan caps how many files one duplicate report reads.
+const maxDuplicateScan = 200000
+
+// ContentHash returns the hex SHA-256 recorded for a file, if any.
+func ContentHash(a *BlobAttrs) string {
+	return a.Metadata[contentHashKey]
+}
+
+func hashMarker(hash, objectName string) string {
+	return HashesPrefix + hash + "/" + objectName
+}
+
+// indexHash records where a file with the given hash lives.
+func (f *File) indexHash(objectName, hash string) {
+	if hash == "" || IsHidden(objectName) {
+		return
+	}
+	if _, err := f.Store.Write(f.Context, hashMarker(hash, objectName), strings.NewReader(""), BlobWriteOptions{}); err != nil {
+		log.Printf("Failed to index %s by content: %v", objectName, err)
+	}
+}
+
+// FindCopies returns the other files with the same content as hash,
+// dropping index markers whose file has since changed or gone.
+func (f *File) FindCopies(hash, except string) ([]string, error) {
+	if hash == "" {
+		return nil, nil
+	}
+	prefix := HashesPrefix + hash + "/"
+	list, err := f.Store.List(f.Context, BlobQuery{Prefix: prefix})
+	if err != nil {
+		return nil, storageError(err, "Failed to look up copies")
+	}
+
+	var paths []string
+	for _, marker := range list {
+		objectName := strings.TrimPrefix(marker.Name, prefix)
+		if objectName == except {
+			continue
+		}
+		a, err := f.Store.Attrs(f.Context, objectName)
+		switch {
+		case err == nil && ContentHash(a) == hash:
+			paths = append(paths, objectName)
+			continue
+		case err != nil && !apperror.Is(err, apperror.CodeNotFound):
+			return nil, storageError(err, "Failed to read %s", objectName)
+		}
+		if err := f.Store.Delete(f.Context, marker.Name); err != nil {
+			log.Printf("Failed to drop stale content marker %s: %v", marker.Name, err)
+		}
+	}
+	return paths, nil
+}
+
+// DuplicateGroup is a set of files with the same content.
+type DuplicateGroup struct {
+	Hash  string   `json:"Hash"`
+	Size  int64    `json:"Size"`
+	Paths []string `json:"Paths"`
+	// WastedBytes is what all but one of the copies take up.
+	WastedBytes int64 `json:"WastedBytes"`
+}
+
+type DuplicateReport struct {
+	Groups      []DuplicateGroup `json:"Groups"`
+	WastedBytes int64            `json:"WastedBytes"`
+	Scanned     int              `json:"Scanned"`
+	// Unhashed counts files with neither a SHA-256 nor an MD5, which
+	// can't be compared.
+	Unhashed int `json:"Unhashed"`
+	// Truncated is set when the scan stopped before reaching every file.
+	Truncated bool `json:"Truncated"`
+}
+
+// Duplicates finds the files under prefix that share their content, most
+// wasted space first. Files are matched by their recorded SHA-256, or by
+// MD5 for files uploaded before hashes were recorded.
+func (f *File) Duplicates(prefix string) (*DuplicateReport, error) {
+	report := &DuplicateReport{Groups: []DuplicateGroup{}}
+
+	var files []BlobAttrs
+	var startAfter string
+	for {
+		list, err := f.Store.List(f.Context, BlobQuery{Prefix: prefix, StartAfter: startAfter, Limit: 1000})
+		if err != nil {
+			return nil, storageError(err, "Failed to list files")
+		}
+		skipped := false
+		for _, a := range list {
+			if IsHidden(a.Name) {
+				startAfter, skipped = pastHidden(a.Name), true
+				break
+			}
+			startAfter = a.Name
+			if !strings.HasSuffix(a.Name, "/") && a.Size > 0 {
+				files = append(files, a)
+			}
+		}
+		if !skipped && len(list) < 1000 {
+			break
+		}
+		if len(files) >= maxDuplicateScan {
+			report.Truncated = true
+			break
+		}
+	}
+	report.Scanned = len(files)
+
+	// Files hashed both ways let older MD5-only copies join their group.
+	byMD5 := make(map[string]string)
+	for _, a := range files {
+		if hash := ContentHash(&a); hash != "" && len(a.MD5) > 0 {
+			byMD5[base64.StdEncoding.EncodeToString(a.MD5)] = hash
+		}
+	}
+
+	groups := make(map[string]*DuplicateGroup)
+	var keys []string
+	for _, a := range files {
+		key := ContentHash(&a)
+		if key == "" && len(a.MD5) > 0 {
+			md5 := base64.StdEncoding.EncodeToString(a.MD5)
+			if key = byMD5[md5]; key == "" {
+				key = "md5:" + md5
+			}
+		}
+		if key == "" {
+			report.Unhashed++
+			continue
+		}
+
+		g, ok := groups[key]
+		if !ok {
+			g = &DuplicateGroup{Hash: key, Size: a.Size}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		g.Paths = append(g.Paths, a.Name)
+	}
+
+	for _, key := range keys {
+		g := groups[key]
+		if len(g.Paths) < 2 {
+			continue
+		}
+		g.WastedBytes = g.Size * int64(len(g.Paths)-1)
+		report.WastedBytes += g.WastedBytes
+		report.Groups = append(report.Groups, *g)
+	}
+	sort.SliceStable(report.Groups, func(i, j int) bool {
+		return report.Groups[i].WastedBytes > report.Groups[j].WastedBytes
+	})
+	return report, nil
+}
+
+// Readable narrows the report to the files canRead allows, dropping groups
+// left with a single file.
+func (r *DuplicateReport) Readable(canRead func(path string) bool) *DuplicateReport {
+	narrowed := *r
+	narrowed.Groups, narrowed.WastedBytes = []DuplicateGroup{}, 0
+	for _, g := range r.Groups {
+		var paths []string
+		for _, p := range g.Paths {
+			if canRead(p) {
+				paths = append(paths, p)
+			}
+		}
+		if len(paths) < 2 {
+			continue
+		}
+		g.Paths, g.WastedBytes = paths, g.Size*int64(len(paths)-1)
+		narrowed.WastedBytes += g.WastedBytes
+		narrowed.Groups = append(narrowed.Groups, g)
+	}
+	return &narrowed
+}
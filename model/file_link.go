@@ -0,0 +1,73 @@
+package model
+
+import (
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/salesforce"
+	"github.com/scottraio/simpleforce"
+)
+
+// FileLink records a signed URL issued for a file: who asked for it, who it
+// was sent to and when it stops working. The URL itself is not kept.
+type FileLink struct {
+	Id   string `json:"Id"`
+	Path string `json:"Path"`
+	// Recipient is the address the link was emailed to, if it was sent.
+	Recipient string    `json:"Recipient"`
+	Filename  string    `json:"Filename"`
+	ExpiresAt time.Time `json:"ExpiresAt"`
+	IssuedBy  string    `json:"IssuedBy"`
+	IssuedAt  time.Time `json:"IssuedAt"`
+}
+
+func FetchFileLinks(client *simpleforce.Client, q salesforce.Query) ([]FileLink, error) {
+	q = q.Select("Id", "Path__c", "Recipient__c", "Filename__c", "Expires_At__c", "Issued_By__c", "CreatedDate").
+		From("CXP_File_Link__c")
+
+	result, err := q.Run(client)
+	if err != nil {
+		return nil, err
+	}
+
+	var links []FileLink
+	for _, record := range result.Records {
+		expiresAt, err := convertToTime(getStringField("Expires_At__c", record))
+		if err != nil {
+			return nil, err
+		}
+
+		issuedAt, err := convertToTime(getStringField("CreatedDate", record))
+		if err != nil {
+			return nil, err
+		}
+
+		links = append(links, FileLink{
+			Id:        getStringField("Id", record),
+			Path:      getStringField("Path__c", record),
+			Recipient: getStringField("Recipient__c", record),
+			Filename:  getStringField("Filename__c", record),
+			ExpiresAt: expiresAt,
+			IssuedBy:  getStringField("Issued_By__c", record),
+			IssuedAt:  issuedAt,
+		})
+	}
+
+	return links, nil
+}
+
+// CreateFileLink saves link and returns its Id.
+func CreateFileLink(client *simpleforce.Client, link FileLink) (string, error) {
+	created := client.SObject("CXP_File_Link__c").
+		Set("Path__c", link.Path).
+		Set("Recipient__c", link.Recipient).
+		Set("Filename__c", link.Filename).
+		Set("Expires_At__c", link.ExpiresAt).
+		Set("Issued_By__c", link.IssuedBy).
+		Create()
+
+	if created == nil || created.ID() == "" {
+		return "", apperror.Unavailable(nil, "Failed to record file link")
+	}
+	return created.ID(), nil
+}
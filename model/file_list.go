@@ -0,0 +1,262 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+)
+
+// Listing pages hold DefaultPageSize entries unless asked otherwise.
+const (
+	DefaultPageSize = 200
+	MaxPageSize     = 1000
+)
+
+// maxChildCount caps how far a folder's children are counted.
+const maxChildCount = 1000
+
+// BucketEntry is a file or folder in a listing. Folders have no size or
+// update time, but carry how many entries they hold.
+type BucketEntry struct {
+	Name        string            `json:"name"`
+	IsFolder    bool              `json:"isFolder"`
+	Size        *int64            `json:"size"`
+	Updated     *time.Time        `json:"updated"`
+	IsPublic    bool              `json:"isPublic"`
+	ContentType string            `json:"contentType,omitempty"`
+	UploadedBy  string            `json:"uploadedBy,omitempty"`
+	Generation  int64             `json:"generation,omitempty,string"`
+	MD5         []byte            `json:"md5,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	// Previewable is set on files GET /files/preview has a thumbnail for.
+	Previewable bool `json:"previewable,omitempty"`
+	Children    *int `json:"children,omitempty"`
+	// MoreChildren is set when a folder holds more than Children entries.
+	MoreChildren bool `json:"moreChildren,omitempty"`
+}
+
+func (e *BucketEntry) size() int64 {
+	if e.Size == nil {
+		return 0
+	}
+	return *e.Size
+}
+
+func (e *BucketEntry) updated() time.Time {
+	if e.Updated == nil {
+		return time.Time{}
+	}
+	return *e.Updated
+}
+
+// ListQuery selects a page of one folder level.
+type ListQuery struct {
+	Path string
+	// Sort is "name" (the default), "size" or "updated".
+	Sort      string
+	Desc      bool
+	PageSize  int
+	PageToken string
+}
+
+type ListResult struct {
+	Entries []BucketEntry
+	// NextPageToken is set when there are more entries.
+	NextPageToken string
+}
+
+// pageToken is where a page ended, in the sort order it was listed in.
+type pageToken struct {
+	Sort    string    `json:"s"`
+	Desc    bool      `json:"d,omitempty"`
+	Name    string    `json:"n"`
+	Size    int64     `json:"z,omitempty"`
+	Updated time.Time `json:"u,omitempty"`
+}
+
+// ListBucketContents returns a page of the files and folders directly under
+// q.Path. Pages in name order read only as much of the folder as they
+// return; other orders read the whole folder level to sort it.
+func (f *File) ListBucketContents(q ListQuery) (*ListResult, error) {
+	if q.PageSize <= 0 {
+		q.PageSize = DefaultPageSize
+	}
+	q.PageSize = min(q.PageSize, MaxPageSize)
+	if q.Sort == "" {
+		q.Sort = "name"
+	}
+	if q.Sort != "name" && q.Sort != "size" && q.Sort != "updated" {
+		return nil, apperror.Validation("sort must be name, size or updated")
+	}
+
+	var after *pageToken
+	if q.PageToken != "" {
+		after = &pageToken{}
+		b, err := base64.RawURLEncoding.DecodeString(q.PageToken)
+		if err == nil {
+			err = json.Unmarshal(b, after)
+		}
+		if err != nil {
+			return nil, apperror.Validation("Invalid page token")
+		}
+		if after.Sort != q.Sort || after.Desc != q.Desc {
+			return nil, apperror.Validation("Page token was issued for a different sort order")
+		}
+	}
+
+	var entries []BucketEntry
+	if q.Sort == "name" && !q.Desc {
+		// The store lists in name order already: read just past the page.
+		var startAfter string
+		if after != nil {
+			startAfter = after.Name
+		}
+		for len(entries) <= q.PageSize {
+			list, err := f.Store.List(f.Context, BlobQuery{Prefix: q.Path, Delimiter: "/", StartAfter: startAfter, Limit: q.PageSize + 1})
+			if err != nil {
+				return nil, storageError(err, "Failed to list bucket contents")
+			}
+			for n := range list {
+				startAfter = list[n].Name + list[n].Prefix
+				if entry, ok := newBucketEntry(q.Path, &list[n]); ok {
+					entries = append(entries, entry)
+				}
+			}
+			if len(list) <= q.PageSize {
+				break
+			}
+		}
+	} else {
+		list, err := f.Store.List(f.Context, BlobQuery{Prefix: q.Path, Delimiter: "/"})
+		if err != nil {
+			return nil, storageError(err, "Failed to list bucket contents")
+		}
+		for n := range list {
+			if entry, ok := newBucketEntry(q.Path, &list[n]); ok {
+				entries = append(entries, entry)
+			}
+		}
+
+		less := func(a *BucketEntry, b *pageToken) bool {
+			switch {
+			case q.Sort == "size" && a.size() != b.Size:
+				return a.size() < b.Size
+			case q.Sort == "updated" && !a.updated().Equal(b.Updated):
+				return a.updated().Before(b.Updated)
+			}
+			return a.Name < b.Name
+		}
+		before := func(a, b *BucketEntry) bool {
+			if q.Desc {
+				a, b = b, a
+			}
+			return less(a, tokenFor(q, b))
+		}
+		sort.Slice(entries, func(i, j int) bool { return before(&entries[i], &entries[j]) })
+
+		if after != nil {
+			n := sort.Search(len(entries), func(i int) bool {
+				if q.Desc {
+					return less(&entries[i], after)
+				}
+				return !less(&entries[i], after) && entries[i].Name != after.Name
+			})
+			entries = entries[n:]
+		}
+	}
+
+	result := &ListResult{Entries: entries}
+	if len(entries) > q.PageSize {
+		result.Entries = entries[:q.PageSize]
+		b, _ := json.Marshal(tokenFor(q, &result.Entries[q.PageSize-1]))
+		result.NextPageToken = base64.RawURLEncoding.EncodeToString(b)
+	}
+	if result.Entries == nil {
+		result.Entries = []BucketEntry{}
+	}
+
+	if err := f.describeFolders(result.Entries); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func tokenFor(q ListQuery, e *BucketEntry) *pageToken {
+	return &pageToken{Sort: q.Sort, Desc: q.Desc, Name: e.Name, Size: e.size(), Updated: e.updated()}
+}
+
+// newBucketEntry turns a listed object or prefix into an entry, leaving out
+// the folder's own placeholder and anything hidden.
+func newBucketEntry(folder string, a *BlobAttrs) (BucketEntry, bool) {
+	if IsHidden(a.Name+a.Prefix) || a.Prefix == "" && a.Name == folder {
+		return BucketEntry{}, false
+	}
+	if a.Prefix != "" {
+		return BucketEntry{Name: a.Prefix, IsFolder: true}, true
+	}
+	size, updated := a.Size, a.Updated
+	return BucketEntry{
+		Name:        a.Name,
+		Size:        &size,
+		Updated:     &updated,
+		IsPublic:    a.Public,
+		ContentType: a.ContentType,
+		UploadedBy:  a.Metadata[uploadedByKey],
+		Generation:  a.Generation,
+		MD5:         a.MD5,
+		Metadata:    a.Metadata,
+		Previewable: Previewable(a.ContentType),
+	}, true
+}
+
+// describeFolders counts each folder's children and reads whether its
+// placeholder is public, a few folders at a time.
+func (f *File) describeFolders(entries []BucketEntry) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	sem := make(chan struct{}, 8)
+
+	for n := range entries {
+		if !entries[n].IsFolder {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(e *BucketEntry) {
+			defer func() { <-sem; wg.Done() }()
+
+			// One extra for the placeholder and one to tell if there are more.
+			list, err := f.Store.List(f.Context, BlobQuery{Prefix: e.Name, Delimiter: "/", Limit: maxChildCount + 2})
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = storageError(err, "Failed to read folder %s", e.Name)
+				}
+				mu.Unlock()
+				return
+			}
+
+			children := 0
+			for _, a := range list {
+				if a.Name == e.Name {
+					e.IsPublic = a.Public
+					continue
+				}
+				if !IsHidden(a.Name + a.Prefix) {
+					children++
+				}
+			}
+			if children > maxChildCount {
+				children, e.MoreChildren = maxChildCount, true
+			}
+			e.Children = &children
+		}(&entries[n])
+	}
+	wg.Wait()
+	return firstErr
+}
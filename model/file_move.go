@@ -0,0 +1,203 @@
+package model
+
+import (
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+)
+
+// ObjectResult reports what happened to one object of a bulk operation.
+type ObjectResult struct {
+	Source      string `json:"Source"`
+	Destination string `json:"Destination,omitempty"`
+	// Err is set when the operation failed for this object.
+	Err error `json:"-"`
+
+	// replaced is the generation of the file that was at Destination when
+	// the operation was planned, or zero if there was none.
+	replaced int64
+}
+
+// Failed counts the results that carry an error.
+func Failed(results []ObjectResult) int {
+	n := 0
+	for _, r := range results {
+		if r.Err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Copy copies src to dst. A src ending in "/" copies everything under that
+// prefix into the dst folder; a dst ending in "/" keeps the file's name.
+// Existing objects are only replaced when overwrite is set. Shares are not
+// copied: the copy is a new file.
+func (f *File) Copy(src, dst string, overwrite bool) ([]ObjectResult, error) {
+	if IsHidden(src) || IsHidden(dst) {
+		return nil, apperror.Validation("Path is reserved")
+	}
+
+	results, err := f.plan(src, dst, overwrite)
+	if err != nil {
+		return nil, err
+	}
+	f.copyAll(results)
+	return results, nil
+}
+
+// Move moves src to dst like Copy, then repoints the Salesforce shares of
+// every moved file and removes the originals. If the shares can't be moved
+// nothing is: the copies are taken back, any files they overwrote are put
+// back as they were, and the originals stay put.
+func (f *File) Move(src, dst string, overwrite bool) ([]ObjectResult, error) {
+	if IsHidden(src) || IsHidden(dst) {
+		return nil, apperror.Validation("Path is reserved")
+	}
+	return f.move(src, dst, overwrite)
+}
+
+// move is Move without the guard on hidden paths, for moving objects in and
+// out of the trash.
+func (f *File) move(src, dst string, overwrite bool) ([]ObjectResult, error) {
+	results, err := f.plan(src, dst, overwrite)
+	if err != nil {
+		return nil, err
+	}
+	f.copyAll(results)
+
+	moves := make(map[string]string)
+	for _, r := range results {
+		if r.Err == nil {
+			moves[r.Source] = r.Destination
+		}
+	}
+	if len(moves) == 0 {
+		return results, nil
+	}
+
+	if err := f.Shares.Move(moves); err != nil {
+		for _, r := range results {
+			if r.Err == nil {
+				f.undoCopy(r)
+			}
+		}
+		return nil, err
+	}
+
+	for n, r := range results {
+		if r.Err != nil {
+			continue
+		}
+		if err := f.Store.Delete(f.Context, r.Source); err != nil {
+			results[n].Err = storageError(err, "Copied to %s but failed to remove the original", r.Destination)
+		}
+	}
+	return results, nil
+}
+
+// undoCopy takes back the copy made for r: the file it overwrote, if any,
+// is put back, and otherwise the copy is removed.
+func (f *File) undoCopy(r ObjectResult) {
+	if r.replaced == 0 {
+		if err := f.Store.Delete(f.Context, r.Destination); err != nil {
+			log.Printf("Failed to remove copy %s after a failed move: %v", r.Destination, err)
+		}
+		return
+	}
+
+	attrs, err := f.Store.CopyVersion(f.Context, r.Destination, r.replaced, r.Destination)
+	if err != nil {
+		log.Printf("Failed to restore %s to generation %d after a failed move: %v", r.Destination, r.replaced, err)
+		return
+	}
+	f.indexHash(r.Destination, ContentHash(attrs))
+}
+
+// plan lists the objects to copy and where each goes. Objects whose
+// destination is taken get an error up front unless overwrite is set.
+func (f *File) plan(src, dst string, overwrite bool) ([]ObjectResult, error) {
+	if src == "" || dst == "" {
+		return nil, apperror.Validation("Source and Destination are required")
+	}
+
+	var results []ObjectResult
+	// existing holds the generation of each destination already taken.
+	existing := make(map[string]int64)
+
+	if strings.HasSuffix(src, "/") {
+		if !strings.HasSuffix(dst, "/") {
+			return nil, apperror.Validation("A folder can only be moved or copied to a folder")
+		}
+		if strings.HasPrefix(dst, src) {
+			return nil, apperror.Validation("Cannot move or copy a folder into itself")
+		}
+
+		list, err := f.Store.List(f.Context, BlobQuery{Prefix: src})
+		if err != nil {
+			return nil, storageError(err, "Failed to list %s", src)
+		}
+		if len(list) == 0 {
+			return nil, apperror.NotFound("Folder %s not found", src)
+		}
+		for _, obj := range list {
+			results = append(results, ObjectResult{Source: obj.Name, Destination: dst + strings.TrimPrefix(obj.Name, src)})
+		}
+
+		taken, err := f.Store.List(f.Context, BlobQuery{Prefix: dst})
+		if err != nil {
+			return nil, storageError(err, "Failed to list %s", dst)
+		}
+		for _, obj := range taken {
+			existing[obj.Name] = obj.Generation
+		}
+	} else {
+		if _, err := f.Store.Attrs(f.Context, src); err != nil {
+			return nil, storageError(err, "Failed to read %s", src)
+		}
+		if strings.HasSuffix(dst, "/") {
+			dst += path.Base(src)
+		}
+		if dst == src {
+			return nil, apperror.Validation("Source and Destination are the same")
+		}
+		results = append(results, ObjectResult{Source: src, Destination: dst})
+
+		attrs, err := f.Store.Attrs(f.Context, dst)
+		switch {
+		case err == nil:
+			existing[dst] = attrs.Generation
+		case !apperror.Is(err, apperror.CodeNotFound):
+			return nil, storageError(err, "Failed to read %s", dst)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Source < results[j].Source })
+	for n, r := range results {
+		generation, taken := existing[r.Destination]
+		switch {
+		case taken && !overwrite:
+			results[n].Err = apperror.Conflict("%s already exists", r.Destination)
+		case taken:
+			results[n].replaced = generation
+		}
+	}
+	return results, nil
+}
+
+func (f *File) copyAll(results []ObjectResult) {
+	for n, r := range results {
+		if r.Err != nil {
+			continue
+		}
+		attrs, err := f.Store.Copy(f.Context, r.Source, r.Destination)
+		if err != nil {
+			results[n].Err = storageError(err, "Failed to copy %s", r.Source)
+			continue
+		}
+		f.indexHash(r.Destination, ContentHash(attrs))
+	}
+}
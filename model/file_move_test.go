@@ -0,0 +1,106 @@
+package model_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Proluxe/proluxe-common-api/blob"
+	"github.com/Proluxe/proluxe-common-api/model"
+	"github.com/Proluxe/proluxe-common-api/model/memory"
+)
+
+// brokenShares can't move shares, as when Salesforce is down.
+type brokenShares struct {
+	model.SharedFileRepository
+}
+
+func (brokenShares) Move(map[string]string) error {
+	return errors.New("salesforce unavailable")
+}
+
+func newTestFile(t *testing.T) *model.File {
+	t.Helper()
+	store, err := blob.NewLocal(t.TempDir(), "http://files.test", []byte("test-signing-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := memory.New().Repositories()
+	return &model.File{Store: store, Context: context.Background(), Shares: repos.SharedFiles, Links: repos.FileLinks}
+}
+
+func write(t *testing.T, f *model.File, name, content string) {
+	t.Helper()
+	if _, err := f.Store.Write(f.Context, name, strings.NewReader(content), model.BlobWriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, f *model.File, name string) string {
+	t.Helper()
+	r, _, err := f.Store.NewRangeReader(f.Context, name, 0, -1)
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestMoveRollbackRestoresOverwritten(t *testing.T) {
+	f := newTestFile(t)
+	write(t, f, "docs/a.txt", "new a")
+	write(t, f, "docs/b.txt", "new b")
+	write(t, f, "old/a.txt", "old a")
+	f.Shares = brokenShares{f.Shares}
+
+	if _, err := f.Move("docs/", "old/", true); err == nil {
+		t.Fatal("Move succeeded without moving the shares")
+	}
+
+	want := map[string]string{
+		"docs/a.txt": "new a",
+		"docs/b.txt": "new b",
+		// Overwritten by the copy, then put back.
+		"old/a.txt": "old a",
+	}
+	for name, content := range want {
+		if got := read(t, f, name); got != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+	// Not there before the move, so removed again.
+	if _, err := f.Store.Attrs(f.Context, "old/b.txt"); err == nil {
+		t.Error("old/b.txt was left behind")
+	}
+}
+
+func TestMoveOverwrite(t *testing.T) {
+	f := newTestFile(t)
+	write(t, f, "docs/a.txt", "new a")
+	write(t, f, "old/a.txt", "old a")
+
+	results, err := f.Move("docs/a.txt", "old/a.txt", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Failed(results) != 1 || read(t, f, "old/a.txt") != "old a" {
+		t.Errorf("Move without overwrite replaced old/a.txt: %+v", results)
+	}
+
+	results, err = f.Move("docs/a.txt", "old/a.txt", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Failed(results) != 0 || read(t, f, "old/a.txt") != "new a" {
+		t.Errorf("Move with overwrite kept old/a.txt: %+v", results)
+	}
+	if _, err := f.Store.Attrs(f.Context, "docs/a.txt"); err == nil {
+		t.Error("docs/a.txt is still there")
+	}
+}
@@ -0,0 +1,258 @@
+package model
+
+import (
+	"encoding/base64"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+)
+
+// tagsKey holds a file's tags in its metadata, comma separated.
+const tagsKey = "tags"
+
+// Search pages hold at most MaxSearchLimit files.
+const (
+	DefaultSearchLimit = 50
+	MaxSearchLimit     = 200
+)
+
+// searchScanBudget caps the objects one search request looks at, so a
+// search over a large bucket returns a cursor instead of running long.
+const searchScanBudget = 10000
+
+// SearchQuery selects files. Empty fields match anything.
+type SearchQuery struct {
+	// Prefix limits the search to a folder and everything under it.
+	Prefix string
+	// Name matches a case-insensitive substring of the file name.
+	Name string
+	// Extension matches the file extension, with or without its dot.
+	Extension string
+	MinSize   int64
+	// MaxSize of zero means no upper bound.
+	MaxSize       int64
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	UploadedBy    string
+	// Tags must all be present on a file.
+	Tags []string
+	// Object and ObjectId match files shared with Salesforce records.
+	Object   string
+	ObjectId string
+	// Cursor continues from where a previous page ended.
+	Cursor string
+	Limit  int
+}
+
+// FileEntry describes a file in search results.
+type FileEntry struct {
+	Name        string    `json:"Name"`
+	Size        int64     `json:"Size"`
+	ContentType string    `json:"ContentType"`
+	Updated     time.Time `json:"Updated"`
+	UploadedBy  string    `json:"UploadedBy,omitempty"`
+	Tags        []string  `json:"Tags,omitempty"`
+	Public      bool      `json:"Public"`
+}
+
+type SearchResult struct {
+	Files []FileEntry `json:"Files"`
+	// NextCursor is set while there may be more results. A page can come
+	// back short, even empty, with a cursor when the search had a lot of
+	// the bucket to go through.
+	NextCursor string `json:"NextCursor,omitempty"`
+}
+
+func newFileEntry(a *BlobAttrs) FileEntry {
+	return FileEntry{
+		Name:        a.Name,
+		Size:        a.Size,
+		ContentType: a.ContentType,
+		Updated:     a.Updated,
+		UploadedBy:  a.Metadata[uploadedByKey],
+		Tags:        parseTags(a.Metadata[tagsKey]),
+		Public:      a.Public,
+	}
+}
+
+// Search returns the files matching q in name order. Results are paged by
+// name, so a cursor stays valid while files are added or removed.
+func (f *File) Search(q SearchQuery) (*SearchResult, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultSearchLimit
+	}
+	q.Limit = min(q.Limit, MaxSearchLimit)
+	q.Extension = strings.TrimPrefix(q.Extension, ".")
+	q.Tags = normalizeTags(q.Tags)
+
+	var startAfter string
+	if q.Cursor != "" {
+		var err error
+		if startAfter, err = decodeCursor(q.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	if q.Object != "" || q.ObjectId != "" {
+		return f.searchShared(q, startAfter)
+	}
+
+	result := &SearchResult{Files: []FileEntry{}}
+	for scanned := 0; scanned < searchScanBudget; {
+		list, err := f.Store.List(f.Context, BlobQuery{Prefix: q.Prefix, StartAfter: startAfter, Limit: 1000})
+		if err != nil {
+			return nil, storageError(err, "Failed to search files")
+		}
+		skipped := false
+		for n := range list {
+			if IsHidden(list[n].Name) {
+				startAfter, skipped = pastHidden(list[n].Name), true
+				break
+			}
+			startAfter = list[n].Name
+			if q.matches(&list[n]) {
+				result.Files = append(result.Files, newFileEntry(&list[n]))
+				if len(result.Files) == q.Limit {
+					result.NextCursor = cursorAfter(startAfter)
+					return result, nil
+				}
+			}
+		}
+		if !skipped && len(list) < 1000 {
+			return result, nil
+		}
+		scanned += len(list)
+	}
+	result.NextCursor = cursorAfter(startAfter)
+	return result, nil
+}
+
+// searchShared searches the files shared with the records q names. It looks
+// up each shared path rather than scanning the bucket.
+func (f *File) searchShared(q SearchQuery, startAfter string) (*SearchResult, error) {
+	shares, err := f.Shares.ForRecord(q.Object, q.ObjectId)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	seen := make(map[string]bool)
+	for _, share := range shares {
+		if !seen[share.Path] && strings.HasPrefix(share.Path, q.Prefix) && share.Path > startAfter {
+			seen[share.Path] = true
+			paths = append(paths, share.Path)
+		}
+	}
+	sort.Strings(paths)
+
+	result := &SearchResult{Files: []FileEntry{}}
+	for _, name := range paths {
+		attrs, err := f.Store.Attrs(f.Context, name)
+		if apperror.Is(err, apperror.CodeNotFound) {
+			continue // the share outlived its file
+		}
+		if err != nil {
+			return nil, storageError(err, "Failed to search files")
+		}
+		if !q.matches(attrs) {
+			continue
+		}
+		result.Files = append(result.Files, newFileEntry(attrs))
+		if len(result.Files) == q.Limit {
+			result.NextCursor = cursorAfter(name)
+			break
+		}
+	}
+	return result, nil
+}
+
+func (q *SearchQuery) matches(a *BlobAttrs) bool {
+	if a.Name == "" || strings.HasSuffix(a.Name, "/") || IsHidden(a.Name) {
+		return false
+	}
+	if q.Name != "" && !strings.Contains(strings.ToLower(path.Base(a.Name)), strings.ToLower(q.Name)) {
+		return false
+	}
+	if q.Extension != "" && !strings.EqualFold(strings.TrimPrefix(path.Ext(a.Name), "."), q.Extension) {
+		return false
+	}
+	if a.Size < q.MinSize || q.MaxSize > 0 && a.Size > q.MaxSize {
+		return false
+	}
+	if !q.UpdatedAfter.IsZero() && a.Updated.Before(q.UpdatedAfter) {
+		return false
+	}
+	if !q.UpdatedBefore.IsZero() && !a.Updated.Before(q.UpdatedBefore) {
+		return false
+	}
+	if q.UploadedBy != "" && !strings.EqualFold(a.Metadata[uploadedByKey], q.UploadedBy) {
+		return false
+	}
+	if len(q.Tags) > 0 {
+		have := make(map[string]bool)
+		for _, tag := range parseTags(a.Metadata[tagsKey]) {
+			have[tag] = true
+		}
+		for _, tag := range q.Tags {
+			if !have[tag] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func cursorAfter(name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(name))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", apperror.Validation("Invalid cursor")
+	}
+	return string(b), nil
+}
+
+// SetTags replaces the tags on a file and returns them as stored: trimmed,
+// lower-cased and sorted.
+func (f *File) SetTags(objectName string, tags []string) ([]string, error) {
+	if IsHidden(objectName) || strings.HasSuffix(objectName, "/") {
+		return nil, apperror.Validation("Path must name a file")
+	}
+	for _, tag := range tags {
+		if strings.Contains(tag, ",") {
+			return nil, apperror.Validation("Tags cannot contain commas")
+		}
+	}
+	tags = normalizeTags(tags)
+
+	if _, err := f.Store.UpdateMetadata(f.Context, objectName, map[string]string{tagsKey: strings.Join(tags, ",")}); err != nil {
+		return nil, storageError(err, "Failed to tag %s", objectName)
+	}
+	return tags, nil
+}
+
+func parseTags(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized
+}
@@ -0,0 +1,42 @@
+package model_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Proluxe/proluxe-common-api/model"
+)
+
+// TestSearchSkipsHidden searches past more hidden objects than one search
+// request looks at, which must not cost the request its budget.
+func TestSearchSkipsHidden(t *testing.T) {
+	f := newTestFile(t)
+	for n := 0; n < 10001; n++ {
+		write(t, f, fmt.Sprintf(".hashes/%05d", n), "")
+	}
+	write(t, f, ".trash/x/docs/a.txt", "a")
+	write(t, f, "docs/a.txt", "a")
+	write(t, f, "docs/b.txt", "b")
+
+	var names []string
+	q := model.SearchQuery{Limit: 1}
+	for {
+		result, err := f.Search(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range result.Files {
+			names = append(names, file.Name)
+		}
+		if result.NextCursor == "" {
+			break
+		}
+		if len(result.Files) == 0 {
+			t.Fatalf("search returned an empty page after %v", names)
+		}
+		q.Cursor = result.NextCursor
+	}
+	if fmt.Sprint(names) != "[docs/a.txt docs/b.txt]" {
+		t.Errorf("search found %v, want [docs/a.txt docs/b.txt]", names)
+	}
+}
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+)
+
+// Metadata keys recording who wrote each generation of a file.
+const (
+	uploadedByKey   = "uploaded-by"
+	promotedFromKey = "promoted-from"
+)
+
+// FileVersion is one generation of a file. Generations are sent as strings
+// since they don't fit in a JavaScript number.
+type FileVersion struct {
+	Generation  int64      `json:"Generation,string"`
+	Size        int64      `json:"Size"`
+	ContentType string     `json:"ContentType"`
+	UploadedBy  string     `json:"UploadedBy"`
+	UploadedAt  time.Time  `json:"UploadedAt"`
+	Live        bool       `json:"Live"`
+	ArchivedAt  *time.Time `json:"ArchivedAt,omitempty"`
+	// PromotedFrom is the generation this one was restored from, if any.
+	PromotedFrom int64 `json:"PromotedFrom,omitempty,string"`
+}
+
+// Versions lists the generations of objectName, newest first.
+func (f *File) Versions(objectName string) ([]FileVersion, error) {
+	if IsHidden(objectName) {
+		return nil, apperror.NotFound("File %s not found", objectName)
+	}
+
+	list, err := f.Store.Versions(f.Context, objectName)
+	if err != nil {
+		return nil, storageError(err, "Failed to list versions of %s", objectName)
+	}
+	if len(list) == 0 {
+		return nil, apperror.NotFound("File %s not found", objectName)
+	}
+
+	versions := make([]FileVersion, len(list))
+	for n, a := range list {
+		versions[n] = FileVersion{
+			Generation:  a.Generation,
+			Size:        a.Size,
+			ContentType: a.ContentType,
+			UploadedBy:  a.Metadata[uploadedByKey],
+			UploadedAt:  a.Created,
+			Live:        a.Archived.IsZero(),
+		}
+		if !a.Archived.IsZero() {
+			archived := a.Archived
+			versions[n].ArchivedAt = &archived
+		}
+		versions[n].PromotedFrom, _ = strconv.ParseInt(a.Metadata[promotedFromKey], 10, 64)
+	}
+	return versions, nil
+}
+
+// Promote makes a past generation of objectName live again by writing it
+// back as a new generation, so the version it replaces stays in the
+// history too.
+func (f *File) Promote(objectName string, generation int64, promotedBy string) (*BlobAttrs, error) {
+	if IsHidden(objectName) {
+		return nil, apperror.Validation("Path is reserved")
+	}
+
+	version, err := FindVersion(f.Context, f.Store, objectName, generation)
+	if err != nil {
+		return nil, err
+	}
+	if version.Archived.IsZero() {
+		return nil, apperror.Conflict("Version %d of %s is already live", generation, objectName)
+	}
+
+	if _, err := f.Store.CopyVersion(f.Context, objectName, generation, objectName); err != nil {
+		return nil, storageError(err, "Failed to restore version %d of %s", generation, objectName)
+	}
+	attrs, err := f.Store.UpdateMetadata(f.Context, objectName, map[string]string{
+		uploadedByKey:   promotedBy,
+		promotedFromKey: strconv.FormatInt(generation, 10),
+	})
+	if err != nil {
+		return nil, storageError(err, "Restored version %d of %s but failed to record it", generation, objectName)
+	}
+	f.indexHash(objectName, ContentHash(attrs))
+	return attrs, nil
+}
+
+// FindVersion returns the attributes of one generation of an object.
+func FindVersion(ctx context.Context, store BlobStore, objectName string, generation int64) (*BlobAttrs, error) {
+	list, err := store.Versions(ctx, objectName)
+	if err != nil {
+		return nil, storageError(err, "Failed to list versions of %s", objectName)
+	}
+	for _, a := range list {
+		if a.Generation == generation {
+			return &a, nil
+		}
+	}
+	return nil, apperror.NotFound("Version %d of %s not found", generation, objectName)
+}
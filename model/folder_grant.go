@@ -0,0 +1,232 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/salesforce"
+	"github.com/scottraio/simpleforce"
+)
+
+// Permission is what a folder grant allows. Each includes the ones before
+// it: read, then write, then share.
+type Permission string
+
+const (
+	PermissionRead  Permission = "read"
+	PermissionWrite Permission = "write"
+	// PermissionShare covers making files public, sharing them with
+	// records and sending links.
+	PermissionShare Permission = "share"
+)
+
+// Valid reports whether p is read, write or share.
+func (p Permission) Valid() bool {
+	return p.rank() > 0
+}
+
+func (p Permission) rank() int {
+	switch p {
+	case PermissionRead:
+		return 1
+	case PermissionWrite:
+		return 2
+	case PermissionShare:
+		return 3
+	}
+	return 0
+}
+
+// Grantee types: a user by email, everyone with a role, every user of a
+// Salesforce account, or an API key by Id.
+const (
+	GranteeUser    = "user"
+	GranteeRole    = "role"
+	GranteeAccount = "account"
+	GranteeAPIKey  = "api_key"
+)
+
+// RootFolder in a grant covers the whole bucket.
+const RootFolder = "/"
+
+// FolderGrant gives a grantee a permission on a folder and everything
+// beneath it.
+type FolderGrant struct {
+	Id          string     `json:"Id"`
+	Folder      string     `json:"Folder"`
+	GranteeType string     `json:"GranteeType"`
+	Grantee     string     `json:"Grantee"`
+	Permission  Permission `json:"Permission"`
+	GrantedBy   string     `json:"GrantedBy"`
+	GrantedAt   time.Time  `json:"GrantedAt"`
+}
+
+// Validate checks the grant and normalizes its grantee.
+func (g *FolderGrant) Validate() error {
+	if g.Folder != RootFolder && (g.Folder == "" || !strings.HasSuffix(g.Folder, "/") || IsHidden(g.Folder)) {
+		return apperror.Validation("Folder must be a folder path ending in /, or / for the whole bucket")
+	}
+	if !g.Permission.Valid() {
+		return apperror.Validation("Permission must be read, write or share")
+	}
+	g.Grantee = strings.TrimSpace(g.Grantee)
+	if g.Grantee == "" {
+		return apperror.Validation("Grantee is required")
+	}
+	switch g.GranteeType {
+	case GranteeUser, GranteeRole:
+		g.Grantee = strings.ToLower(g.Grantee)
+	case GranteeAccount, GranteeAPIKey:
+	default:
+		return apperror.Validation("GranteeType must be user, role, account or api_key")
+	}
+	return nil
+}
+
+// Grantee is who a caller is for the purpose of folder grants.
+type Grantee struct {
+	Email    string
+	Roles    []string
+	Accounts []string
+	APIKey   string
+}
+
+func (who Grantee) matches(g FolderGrant) bool {
+	switch g.GranteeType {
+	case GranteeUser:
+		return who.Email != "" && strings.EqualFold(who.Email, g.Grantee)
+	case GranteeRole:
+		for _, role := range who.Roles {
+			if role == g.Grantee {
+				return true
+			}
+		}
+	case GranteeAccount:
+		for _, account := range who.Accounts {
+			if account == g.Grantee {
+				return true
+			}
+		}
+	case GranteeAPIKey:
+		return who.APIKey != "" && who.APIKey == g.Grantee
+	}
+	return false
+}
+
+// covers reports whether the grant reaches name.
+func (g FolderGrant) covers(name string) bool {
+	return g.Folder == RootFolder || strings.HasPrefix(name, g.Folder)
+}
+
+// FolderAccess answers what a caller may do in the bucket. A nil
+// FolderAccess allows everything.
+type FolderAccess struct {
+	grants []FolderGrant
+}
+
+// NewFolderAccess keeps the grants that apply to who.
+func NewFolderAccess(grants []FolderGrant, who Grantee) *FolderAccess {
+	a := &FolderAccess{}
+	for _, g := range grants {
+		if who.matches(g) {
+			a.grants = append(a.grants, g)
+		}
+	}
+	return a
+}
+
+// Can reports whether the caller has perm on name, through a grant on its
+// folder or any folder above it.
+func (a *FolderAccess) Can(name string, perm Permission) bool {
+	if a == nil {
+		return true
+	}
+	for _, g := range a.grants {
+		if g.covers(name) && g.Permission.rank() >= perm.rank() {
+			return true
+		}
+	}
+	return false
+}
+
+// CanSeeInto reports whether the caller holds a grant at or beneath folder,
+// and so may see the folder to navigate to it.
+func (a *FolderAccess) CanSeeInto(folder string) bool {
+	if a == nil {
+		return true
+	}
+	for _, g := range a.grants {
+		if g.Folder == RootFolder || strings.HasPrefix(g.Folder, folder) {
+			return true
+		}
+	}
+	return false
+}
+
+// folderAncestors returns the folders name sits in, outermost first: for
+// "a/b/c.txt", "a/" and "a/b/". A folder counts as its own ancestor.
+func folderAncestors(name string) []string {
+	var folders []string
+	for i, r := range name {
+		if r == '/' {
+			folders = append(folders, name[:i+1])
+		}
+	}
+	return folders
+}
+
+// SF Functions
+
+func FetchFolderGrants(client *simpleforce.Client, q salesforce.Query) ([]FolderGrant, error) {
+	q = q.Select("Id", "Folder__c", "Grantee_Type__c", "Grantee__c", "Permission__c", "Granted_By__c", "CreatedDate").
+		From("CXP_Folder_Grant__c")
+
+	result, err := q.Run(client)
+	if err != nil {
+		return nil, err
+	}
+
+	var grants []FolderGrant
+	for _, record := range result.Records {
+		grantedAt, err := convertToTime(getStringField("CreatedDate", record))
+		if err != nil {
+			return nil, err
+		}
+
+		grants = append(grants, FolderGrant{
+			Id:          getStringField("Id", record),
+			Folder:      getStringField("Folder__c", record),
+			GranteeType: getStringField("Grantee_Type__c", record),
+			Grantee:     getStringField("Grantee__c", record),
+			Permission:  Permission(getStringField("Permission__c", record)),
+			GrantedBy:   getStringField("Granted_By__c", record),
+			GrantedAt:   grantedAt,
+		})
+	}
+
+	return grants, nil
+}
+
+// CreateFolderGrant saves grant and returns its Id.
+func CreateFolderGrant(client *simpleforce.Client, grant FolderGrant) (string, error) {
+	created := client.SObject("CXP_Folder_Grant__c").
+		Set("Folder__c", grant.Folder).
+		Set("Grantee_Type__c", grant.GranteeType).
+		Set("Grantee__c", grant.Grantee).
+		Set("Permission__c", string(grant.Permission)).
+		Set("Granted_By__c", grant.GrantedBy).
+		Create()
+
+	if created == nil || created.ID() == "" {
+		return "", apperror.Unavailable(nil, "Failed to save folder grant")
+	}
+	return created.ID(), nil
+}
+
+func DeleteFolderGrant(client *simpleforce.Client, id string) error {
+	if err := client.SObject("CXP_Folder_Grant__c").Set("Id", id).Delete(); err != nil {
+		return salesforce.WrapError(err, "Failed to delete folder grant")
+	}
+	return nil
+}
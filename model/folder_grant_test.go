@@ -0,0 +1,138 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/Proluxe/proluxe-common-api/model"
+	"github.com/Proluxe/proluxe-common-api/model/memory"
+)
+
+// access grants, through the memory repository, and returns what who may do
+// around path.
+func access(t *testing.T, path string, who model.Grantee, grants ...model.FolderGrant) *model.FolderAccess {
+	t.Helper()
+	repo := memory.New().Repositories().FolderGrants
+	for n := range grants {
+		if err := grants[n].Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Create(&grants[n]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	found, err := repo.ForPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return model.NewFolderAccess(found, who)
+}
+
+func grant(folder, granteeType, grantee string, perm model.Permission) model.FolderGrant {
+	return model.FolderGrant{Folder: folder, GranteeType: granteeType, Grantee: grantee, Permission: perm}
+}
+
+func TestFolderAccessCan(t *testing.T) {
+	who := model.Grantee{Email: "sam@proluxe.test", Roles: []string{"staff"}, Accounts: []string{"001A"}}
+	tests := []struct {
+		name  string
+		grant model.FolderGrant
+		path  string
+		perm  model.Permission
+		want  bool
+	}{
+		{"user", grant("docs/", model.GranteeUser, "sam@proluxe.test", model.PermissionRead), "docs/a.txt", model.PermissionRead, true},
+		{"user in another case", grant("docs/", model.GranteeUser, "Sam@Proluxe.test", model.PermissionRead), "docs/a.txt", model.PermissionRead, true},
+		{"other user", grant("docs/", model.GranteeUser, "ada@proluxe.test", model.PermissionShare), "docs/a.txt", model.PermissionRead, false},
+		{"role", grant("docs/", model.GranteeRole, "staff", model.PermissionRead), "docs/a.txt", model.PermissionRead, true},
+		{"other role", grant("docs/", model.GranteeRole, "admin", model.PermissionShare), "docs/a.txt", model.PermissionRead, false},
+		{"account", grant("docs/", model.GranteeAccount, "001A", model.PermissionRead), "docs/a.txt", model.PermissionRead, true},
+		{"other account", grant("docs/", model.GranteeAccount, "001B", model.PermissionShare), "docs/a.txt", model.PermissionRead, false},
+		{"api key the caller isn't", grant("docs/", model.GranteeAPIKey, "a0K1", model.PermissionShare), "docs/a.txt", model.PermissionRead, false},
+
+		{"inherited from a parent", grant("docs/", model.GranteeRole, "staff", model.PermissionRead), "docs/2024/q1/a.txt", model.PermissionRead, true},
+		{"inherited by a subfolder", grant("docs/", model.GranteeRole, "staff", model.PermissionRead), "docs/2024/", model.PermissionRead, true},
+		{"on the folder itself", grant("docs/", model.GranteeRole, "staff", model.PermissionRead), "docs/", model.PermissionRead, true},
+		{"root", grant(model.RootFolder, model.GranteeRole, "staff", model.PermissionRead), "docs/a.txt", model.PermissionRead, true},
+		{"root covers top level files", grant(model.RootFolder, model.GranteeRole, "staff", model.PermissionRead), "a.txt", model.PermissionRead, true},
+		{"not the parent", grant("docs/2024/", model.GranteeRole, "staff", model.PermissionRead), "docs/a.txt", model.PermissionRead, false},
+		{"sibling", grant("docs/", model.GranteeRole, "staff", model.PermissionShare), "reports/a.txt", model.PermissionRead, false},
+
+		{"read allows read", grant("docs/", model.GranteeRole, "staff", model.PermissionRead), "docs/a.txt", model.PermissionRead, true},
+		{"read denies write", grant("docs/", model.GranteeRole, "staff", model.PermissionRead), "docs/a.txt", model.PermissionWrite, false},
+		{"read denies share", grant("docs/", model.GranteeRole, "staff", model.PermissionRead), "docs/a.txt", model.PermissionShare, false},
+		{"write allows read", grant("docs/", model.GranteeRole, "staff", model.PermissionWrite), "docs/a.txt", model.PermissionRead, true},
+		{"write allows write", grant("docs/", model.GranteeRole, "staff", model.PermissionWrite), "docs/a.txt", model.PermissionWrite, true},
+		{"write denies share", grant("docs/", model.GranteeRole, "staff", model.PermissionWrite), "docs/a.txt", model.PermissionShare, false},
+		{"share allows read", grant("docs/", model.GranteeRole, "staff", model.PermissionShare), "docs/a.txt", model.PermissionRead, true},
+		{"share allows write", grant("docs/", model.GranteeRole, "staff", model.PermissionShare), "docs/a.txt", model.PermissionWrite, true},
+		{"share allows share", grant("docs/", model.GranteeRole, "staff", model.PermissionShare), "docs/a.txt", model.PermissionShare, true},
+
+		{"a/ doesn't cover ab/", grant("a/", model.GranteeRole, "staff", model.PermissionShare), "ab/x.txt", model.PermissionRead, false},
+		{"a/ doesn't cover the folder ab/", grant("a/", model.GranteeRole, "staff", model.PermissionShare), "ab/", model.PermissionRead, false},
+		{"a/ doesn't cover the file a", grant("a/", model.GranteeRole, "staff", model.PermissionShare), "a", model.PermissionRead, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := access(t, tt.path, who, tt.grant).Can(tt.path, tt.perm); got != tt.want {
+				t.Errorf("Can(%q, %s) = %v with %+v, want %v", tt.path, tt.perm, got, tt.grant, tt.want)
+			}
+		})
+	}
+}
+
+func TestFolderAccessCanMixedGrants(t *testing.T) {
+	who := model.Grantee{Email: "sam@proluxe.test", Roles: []string{"staff"}, APIKey: "a0K1"}
+	a := access(t, "docs/2024/a.txt", who,
+		grant("docs/", model.GranteeRole, "staff", model.PermissionRead),
+		grant("docs/2024/", model.GranteeAPIKey, "a0K1", model.PermissionWrite),
+		grant("docs/2024/", model.GranteeUser, "ada@proluxe.test", model.PermissionShare),
+	)
+	if !a.Can("docs/2024/a.txt", model.PermissionWrite) {
+		t.Error("the API key's write grant on docs/2024/ didn't apply")
+	}
+	if a.Can("docs/2024/a.txt", model.PermissionShare) {
+		t.Error("another user's share grant applied")
+	}
+
+	a = access(t, "docs/b.txt", who,
+		grant("docs/", model.GranteeRole, "staff", model.PermissionRead),
+		grant("docs/2024/", model.GranteeAPIKey, "a0K1", model.PermissionWrite),
+	)
+	if a.Can("docs/b.txt", model.PermissionWrite) {
+		t.Error("the write grant on docs/2024/ reached docs/b.txt")
+	}
+	if !a.Can("docs/b.txt", model.PermissionRead) {
+		t.Error("the read grant on docs/ didn't reach docs/b.txt")
+	}
+
+	var none *model.FolderAccess
+	if !none.Can("anything", model.PermissionShare) || !none.CanSeeInto("anything/") {
+		t.Error("a nil FolderAccess didn't allow everything")
+	}
+}
+
+func TestFolderAccessCanSeeInto(t *testing.T) {
+	who := model.Grantee{Roles: []string{"staff"}}
+	tests := []struct {
+		name   string
+		grant  model.FolderGrant
+		folder string
+		want   bool
+	}{
+		{"above the grant", grant("docs/2024/", model.GranteeRole, "staff", model.PermissionRead), "docs/", true},
+		{"the granted folder", grant("docs/2024/", model.GranteeRole, "staff", model.PermissionRead), "docs/2024/", true},
+		{"bucket root", grant("docs/2024/", model.GranteeRole, "staff", model.PermissionRead), "", true},
+		{"sibling", grant("docs/2024/", model.GranteeRole, "staff", model.PermissionRead), "docs/2025/", false},
+		{"a/ can't see into ab/", grant("a/", model.GranteeRole, "staff", model.PermissionRead), "ab/", false},
+		{"ab/ can't see into a/", grant("ab/", model.GranteeRole, "staff", model.PermissionRead), "a/", false},
+		{"root grant", grant(model.RootFolder, model.GranteeRole, "staff", model.PermissionRead), "docs/", true},
+		{"someone else's grant", grant("docs/2024/", model.GranteeRole, "admin", model.PermissionRead), "docs/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := access(t, tt.folder, who, tt.grant).CanSeeInto(tt.folder); got != tt.want {
+				t.Errorf("CanSeeInto(%q) = %v with %+v, want %v", tt.folder, got, tt.grant, tt.want)
+			}
+		})
+	}
+}
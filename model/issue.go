@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/salesforce"
 	services "github.com/Proluxe/proluxe-common-api/services"
 	"github.com/scottraio/simpleforce"
 )
@@ -18,16 +20,13 @@ type Issue struct {
 	Comments []Comment    `json:"Comments"`
 }
 
-func FetchIssues(client *simpleforce.Client, whereCondition string) []Issue {
-	q := fmt.Sprintf(`
-		SELECT Id, Name, Description__c, Closed__c
-		FROM Issue__c
-		WHERE %s
-	`, whereCondition)
+func FetchIssues(client *simpleforce.Client, q salesforce.Query) ([]Issue, error) {
+	q = q.Select("Id", "Name", "Description__c", "Closed__c").
+		From("Issue__c")
 
-	result, err := client.Query(q)
+	result, err := q.Run(client)
 	if err != nil {
-		log.Fatal("Error fetching issues: ", err)
+		return nil, err
 	}
 
 	var issues []Issue
@@ -43,47 +42,69 @@ func FetchIssues(client *simpleforce.Client, whereCondition string) []Issue {
 		issues = append(issues, i)
 	}
 
-	return issues
+	return issues, nil
 }
 
-func (i *Issue) Create(client *simpleforce.Client) *simpleforce.SObject {
-	return client.SObject("Issue__c").
+// Create saves the issue and sets its Id.
+func (i *Issue) Create(client *simpleforce.Client) error {
+	sobj := client.SObject("Issue__c").
 		Set("Name", i.Name).
 		Set("Description__c", i.Description).
 		Set("Closed__c", false).
 		Create()
+
+	if sobj == nil {
+		return apperror.Unavailable(nil, "Failed to create issue")
+	}
+
+	i.Id = sobj.ID()
+	return nil
 }
 
-func (i *Issue) Close(client *simpleforce.Client) *simpleforce.SObject {
-	return client.SObject("Issue__c").
+func (i *Issue) Close(client *simpleforce.Client) error {
+	sobj := client.SObject("Issue__c").
 		Set("Id", i.Id).
 		Set("Closed__c", true).
 		Update()
+
+	if sobj == nil {
+		return apperror.Unavailable(nil, "Failed to close issue %s", i.Id)
+	}
+	return nil
 }
 
 func (i *Issue) Delete(client *simpleforce.Client) error {
-	return client.SObject("Issue__c").
+	err := client.SObject("Issue__c").
 		Set("Id", i.Id).
 		Delete()
+	return salesforce.WrapError(err, "Failed to delete issue")
 }
 
-func (i *Issue) Update(client *simpleforce.Client) *simpleforce.SObject {
-	return client.SObject("Issue__c").
+func (i *Issue) Update(client *simpleforce.Client) error {
+	sobj := client.SObject("Issue__c").
 		Set("Id", i.Id).
 		Set("Name", i.Name).
 		Set("Description__c", i.Description).
 		Update()
+
+	if sobj == nil {
+		return apperror.Unavailable(nil, "Failed to update issue %s", i.Id)
+	}
+	return nil
 }
 
-func (i *Issue) SendClosedIssueNotification(client *simpleforce.Client) {
+func (i *Issue) SendClosedIssueNotification(users UserRepository) {
 	knock := services.Knock{
 		WorkFlowId: "closed-issue",
 	}
 
-	users := FetchUsers(client, "Issue_Notifications__c = true")
+	subscribers, err := users.IssueSubscribers()
+	if err != nil {
+		log.Println("Error fetching issue subscribers: ", err)
+	}
 
-	recipients := make([]string, len(users))
-	for i, user := range users {
+	recipients := make([]string, len(subscribers))
+	for i, user := range subscribers {
 		recipients[i] = user.Email
 	}
 
@@ -104,15 +125,18 @@ func (i *Issue) SendClosedIssueNotification(client *simpleforce.Client) {
 	})
 }
 
-func (i *Issue) SendNewIssueNotification(client *simpleforce.Client) {
+func (i *Issue) SendNewIssueNotification(users UserRepository) {
 	knock := services.Knock{
 		WorkFlowId: "new-issue",
 	}
 
-	users := FetchUsers(client, "Issue_Notifications__c = true")
+	subscribers, err := users.IssueSubscribers()
+	if err != nil {
+		log.Println("Error fetching issue subscribers: ", err)
+	}
 
-	recipients := make([]string, len(users))
-	for i, user := range users {
+	recipients := make([]string, len(subscribers))
+	for i, user := range subscribers {
 		recipients[i] = user.Email
 	}
 
@@ -133,6 +157,12 @@ func (i *Issue) SendNewIssueNotification(client *simpleforce.Client) {
 	})
 }
 
-func (i *Issue) AttachRelatedObjects(client *simpleforce.Client) {
-	i.Files = FetchFiles(client, "Object_Id__c = '"+i.Id+"' AND Object__c = 'Issue__c'")
+func (i *Issue) AttachRelatedObjects(files SharedFileRepository) error {
+	shared, err := files.ForRecord("Issue__c", i.Id)
+	if err != nil {
+		return err
+	}
+
+	i.Files = shared
+	return nil
 }
@@ -0,0 +1,693 @@
+// Package memory implements model.Repositories in process memory, so the API
+// can run without a Salesforce org.
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/model"
+)
+
+// Store holds every record in memory. It is safe for concurrent use.
+type Store struct {
+	mu  sync.Mutex
+	seq int
+
+	comments  []model.Comment
+	mentions  []model.CommentMention
+	issues    []model.Issue
+	events    []model.Event
+	users     []model.User
+	links     []model.PinnedLink
+	shares    []model.SharedFile
+	legacy    []model.SharedFile
+	fileLinks []model.FileLink
+	grants    []model.FolderGrant
+	keys      []model.APIKey
+	names     map[string]string
+}
+
+func New() *Store {
+	return &Store{names: make(map[string]string)}
+}
+
+// Repositories returns the store's per-entity repositories.
+func (s *Store) Repositories() model.Repositories {
+	return model.Repositories{
+		Comments:     &comments{s},
+		Mentions:     &mentions{s},
+		Issues:       &issues{s},
+		Events:       &events{s},
+		Users:        &users{s},
+		PinnedLinks:  &pinnedLinks{s},
+		SharedFiles:  &sharedFiles{s},
+		FileLinks:    &fileLinks{s},
+		FolderGrants: &folderGrants{s},
+		APIKeys:      &apiKeys{s},
+	}
+}
+
+// AddUser seeds a user and returns it with its Id set.
+func (s *Store) AddUser(u model.User) model.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u.Id == "" {
+		u.Id = s.nextID("a9W")
+	}
+	s.users = append(s.users, u)
+	return u
+}
+
+// AddLegacyShare seeds a share in the legacy object, for
+// SharedFileRepository.MigrateLegacy to move.
+func (s *Store) AddLegacyShare(share model.SharedFile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.legacy = append(s.legacy, share)
+}
+
+// SetRecordName sets the Name returned by CommentRepository.RecordName.
+func (s *Store) SetRecordName(recordType, recordID, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.names[recordType+"|"+recordID] = name
+}
+
+// nextID returns an 18 character Id with the given key prefix. s.mu must be held.
+func (s *Store) nextID(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s%015d", prefix, s.seq)
+}
+
+// Comments
+
+type comments struct{ *Store }
+
+func (r *comments) ForRecord(recordID string) ([]model.Comment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []model.Comment
+	for _, c := range r.comments {
+		if c.RecordID == recordID {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+func (r *comments) Get(id string) (*model.Comment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.comments {
+		if c.Id == id {
+			return &c, nil
+		}
+	}
+	return nil, apperror.NotFound("Comment %s not found", id)
+}
+
+func (r *comments) Create(c *model.Comment) error {
+	r.mu.Lock()
+	c.Id = r.nextID("a0C")
+	stored := *c
+	stored.MentionedUsers = nil
+	r.comments = append(r.comments, stored)
+	r.mu.Unlock()
+
+	mentioned := append([]model.CommentMention{{Email: c.CreatedBy}}, c.MentionedUsers...)
+	for i := range mentioned {
+		mentioned[i].CommentId = c.Id
+		mentioned[i].RecordID = c.RecordID
+		mentioned[i].RecordType = c.RecordType
+	}
+
+	return (&mentions{r.Store}).Add(mentioned)
+}
+
+func (r *comments) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, c := range r.comments {
+		if c.Id == id {
+			r.comments = append(r.comments[:i], r.comments[i+1:]...)
+			return nil
+		}
+	}
+	return apperror.NotFound("Comment %s not found", id)
+}
+
+func (r *comments) RecordName(recordType, recordID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.names[recordType+"|"+recordID], nil
+}
+
+// Mentions
+
+type mentions struct{ *Store }
+
+func (r *mentions) ForRecord(recordID string) ([]model.CommentMention, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []model.CommentMention
+	for _, m := range r.mentions {
+		if m.RecordID == recordID {
+			result = append(result, m)
+		}
+	}
+	return result, nil
+}
+
+func (r *mentions) ForRecordOfType(recordID, recordType string) ([]model.CommentMention, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []model.CommentMention
+	for _, m := range r.mentions {
+		if m.RecordID == recordID && m.RecordType == recordType {
+			result = append(result, m)
+		}
+	}
+	return result, nil
+}
+
+func (r *mentions) Add(mentions []model.CommentMention) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing := make(map[string]bool)
+	for _, m := range r.mentions {
+		existing[m.RecordID+"|"+m.RecordType+"|"+m.Email] = true
+	}
+
+	for _, m := range mentions {
+		key := m.RecordID + "|" + m.RecordType + "|" + m.Email
+		if existing[key] {
+			continue
+		}
+		existing[key] = true
+		m.Id = r.nextID("a0M")
+		r.mentions = append(r.mentions, m)
+	}
+	return nil
+}
+
+// Issues
+
+type issues struct{ *Store }
+
+func (r *issues) Open() ([]model.Issue, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []model.Issue
+	for i := len(r.issues) - 1; i >= 0; i-- {
+		if !r.issues[i].Closed {
+			result = append(result, r.issues[i])
+		}
+	}
+	return result, nil
+}
+
+func (r *issues) Get(id string) (*model.Issue, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, i := range r.issues {
+		if i.Id == id {
+			return &i, nil
+		}
+	}
+	return nil, apperror.NotFound("Issue %s not found", id)
+}
+
+func (r *issues) Create(i *model.Issue) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i.Id = r.nextID("a0I")
+	i.Closed = false
+	r.issues = append(r.issues, model.Issue{Id: i.Id, Name: i.Name, Description: i.Description})
+	return nil
+}
+
+func (r *issues) Update(i *model.Issue) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for n := range r.issues {
+		if r.issues[n].Id == i.Id {
+			r.issues[n].Name = i.Name
+			r.issues[n].Description = i.Description
+			return nil
+		}
+	}
+	return apperror.NotFound("Issue %s not found", i.Id)
+}
+
+func (r *issues) Close(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for n := range r.issues {
+		if r.issues[n].Id == id {
+			r.issues[n].Closed = true
+			return nil
+		}
+	}
+	return apperror.NotFound("Issue %s not found", id)
+}
+
+// Events
+
+type events struct{ *Store }
+
+func (r *events) All() ([]model.Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []model.Event
+	for _, e := range r.events {
+		if e.Name != "" {
+			result = append(result, e)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].StartDateTime.Before(result[j].StartDateTime)
+	})
+	return result, nil
+}
+
+func (r *events) Upcoming() ([]model.Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	endOfToday := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+
+	var result []model.Event
+	for _, e := range r.events {
+		if e.Name != "" && !e.EndDateTime.Before(endOfToday) {
+			result = append(result, e)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].EndDateTime.Before(result[j].EndDateTime)
+	})
+	return result, nil
+}
+
+func (r *events) Get(id string) (*model.Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, e := range r.events {
+		if e.Id == id {
+			return &e, nil
+		}
+	}
+	return nil, apperror.NotFound("Event %s not found", id)
+}
+
+func (r *events) Create(e *model.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e.Id = r.nextID("a0E")
+	r.events = append(r.events, *e)
+	return nil
+}
+
+func (r *events) Update(e *model.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for n := range r.events {
+		if r.events[n].Id == e.Id {
+			r.events[n] = *e
+			return nil
+		}
+	}
+	return apperror.NotFound("Event %s not found", e.Id)
+}
+
+func (r *events) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for n, e := range r.events {
+		if e.Id == id {
+			r.events = append(r.events[:n], r.events[n+1:]...)
+			return nil
+		}
+	}
+	return apperror.NotFound("Event %s not found", id)
+}
+
+// Users
+
+type users struct{ *Store }
+
+func (r *users) FindByEmail(email string) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if strings.EqualFold(u.Email, email) {
+			return &u, nil
+		}
+	}
+	return nil, apperror.NotFound("User %s not found", email)
+}
+
+func (r *users) IssueSubscribers() ([]model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []model.User
+	for _, u := range r.users {
+		if u.IssueNotifications {
+			result = append(result, u)
+		}
+	}
+	return result, nil
+}
+
+func (r *users) Mentionable() ([]model.MentionableUser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []model.MentionableUser
+	for _, u := range r.users {
+		result = append(result, model.MentionableUser{Id: u.Email, Display: u.Name})
+	}
+	return result, nil
+}
+
+func (r *users) Update(u *model.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for n := range r.users {
+		if r.users[n].Id == u.Id {
+			r.users[n].Phone = u.Phone
+			r.users[n].IssueNotifications = u.IssueNotifications
+			r.users[n].NewLeadNotification = u.NewLeadNotification
+			r.users[n].NewOpportunityNotification = u.NewOpportunityNotification
+			return nil
+		}
+	}
+	return apperror.NotFound("User %s not found", u.Id)
+}
+
+// Pinned links
+
+type pinnedLinks struct{ *Store }
+
+func (r *pinnedLinks) ForUser(email, app string) ([]model.PinnedLink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []model.PinnedLink
+	for _, l := range r.links {
+		if l.Email == email && (app == "" || l.App == app) {
+			result = append(result, l)
+		}
+	}
+	return result, nil
+}
+
+func (r *pinnedLinks) Get(id string) (*model.PinnedLink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, l := range r.links {
+		if l.Id == id {
+			return &l, nil
+		}
+	}
+	return nil, apperror.NotFound("Pinned link %s not found", id)
+}
+
+func (r *pinnedLinks) Create(link model.PinnedLink) (model.PinnedLink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	link.Id = r.nextID("a0P")
+	r.links = append(r.links, link)
+	return link, nil
+}
+
+func (r *pinnedLinks) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for n, l := range r.links {
+		if l.Id == id {
+			r.links = append(r.links[:n], r.links[n+1:]...)
+			return nil
+		}
+	}
+	return apperror.NotFound("Pinned link %s not found", id)
+}
+
+// Shared files
+
+type sharedFiles struct{ *Store }
+
+func (r *sharedFiles) ForRecord(object, objectID string) ([]model.SharedFile, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []model.SharedFile
+	for _, f := range r.shares {
+		if (object == "" || f.Object == object) && (objectID == "" || f.ObjectId == objectID) {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+func (r *sharedFiles) UnderPath(path string) ([]model.SharedFile, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []model.SharedFile
+	for _, f := range r.shares {
+		if strings.Contains(f.Path, path) {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+func (r *sharedFiles) Share(path string, item model.SharedItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	share := model.SharedFile{
+		Object:     item.Object,
+		ObjectId:   item.ObjectId,
+		ObjectName: item.ObjectName,
+		Path:       path,
+	}
+
+	for n, f := range r.shares {
+		if f.Object == item.Object && f.ObjectId == item.ObjectId && f.Path == path {
+			r.shares[n] = share
+			return nil
+		}
+	}
+	r.shares = append(r.shares, share)
+	return nil
+}
+
+func (r *sharedFiles) DeleteByPath(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.shares[:0]
+	for _, f := range r.shares {
+		if f.Path != path {
+			kept = append(kept, f)
+		}
+	}
+	r.shares = kept
+	return nil
+}
+
+func (r *sharedFiles) Move(moves map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for n, f := range r.shares {
+		if to, ok := moves[f.Path]; ok {
+			r.shares[n].Path = to
+		}
+	}
+	return nil
+}
+
+func (r *sharedFiles) MigrateLegacy(limit int) (int, bool, error) {
+	if limit <= 0 || limit > model.MaxShareMigration {
+		limit = model.MaxShareMigration
+	}
+
+	r.mu.Lock()
+	batch := r.legacy[:min(limit, len(r.legacy))]
+	r.legacy = r.legacy[len(batch):]
+	more := len(r.legacy) > 0
+	r.mu.Unlock()
+
+	for _, f := range batch {
+		item := model.SharedItem{Object: f.Object, ObjectId: f.ObjectId, ObjectName: f.ObjectName}
+		if err := r.Share(f.Path, item); err != nil {
+			return 0, true, err
+		}
+	}
+	return len(batch), more, nil
+}
+
+// File links
+
+type fileLinks struct{ *Store }
+
+func (r *fileLinks) Find(path, recipient string) ([]model.FileLink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []model.FileLink
+	for i := len(r.fileLinks) - 1; i >= 0; i-- {
+		l := r.fileLinks[i]
+		if (path == "" || l.Path == path) && (recipient == "" || strings.EqualFold(l.Recipient, recipient)) {
+			result = append(result, l)
+		}
+	}
+	return result, nil
+}
+
+func (r *fileLinks) Create(l *model.FileLink) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l.Id = r.nextID("a0L")
+	l.IssuedAt = time.Now()
+	r.fileLinks = append(r.fileLinks, *l)
+	return nil
+}
+
+// Folder grants
+
+type folderGrants struct{ *Store }
+
+func (r *folderGrants) ForPath(path string) ([]model.FolderGrant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	folder := path[:strings.LastIndex(path, "/")+1]
+	var result []model.FolderGrant
+	for _, g := range r.grants {
+		if g.Folder == model.RootFolder || strings.HasPrefix(folder, g.Folder) || strings.HasPrefix(g.Folder, folder) {
+			result = append(result, g)
+		}
+	}
+	return result, nil
+}
+
+func (r *folderGrants) Create(g *model.FolderGrant) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g.Id = r.nextID("a0G")
+	g.GrantedAt = time.Now()
+	r.grants = append(r.grants, *g)
+	return nil
+}
+
+func (r *folderGrants) Delete(id string) (*model.FolderGrant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for n, g := range r.grants {
+		if g.Id == id {
+			r.grants = append(r.grants[:n], r.grants[n+1:]...)
+			return &g, nil
+		}
+	}
+	return nil, apperror.NotFound("Folder grant %s not found", id)
+}
+
+// API keys
+
+type apiKeys struct{ *Store }
+
+func (r *apiKeys) All() ([]model.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]model.APIKey, 0, len(r.keys))
+	for i := len(r.keys) - 1; i >= 0; i-- {
+		result = append(result, r.keys[i])
+	}
+	return result, nil
+}
+
+func (r *apiKeys) FindByHash(hash string) (*model.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, k := range r.keys {
+		if k.Hash == hash {
+			return &k, nil
+		}
+	}
+	return nil, apperror.NotFound("API key not found")
+}
+
+func (r *apiKeys) Create(k *model.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k.Id = r.nextID("a0K")
+	r.keys = append(r.keys, *k)
+	return nil
+}
+
+func (r *apiKeys) Revoke(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for n := range r.keys {
+		if r.keys[n].Id == id {
+			r.keys[n].Revoked = true
+			return nil
+		}
+	}
+	return apperror.NotFound("API key %s not found", id)
+}
+
+func (r *apiKeys) Touch(id string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for n := range r.keys {
+		if r.keys[n].Id == id {
+			r.keys[n].LastUsedAt = at
+			return nil
+		}
+	}
+	return apperror.NotFound("API key %s not found", id)
+}
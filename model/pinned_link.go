@@ -1,9 +1,10 @@
 package model
 
 import (
-	"fmt"
 	"log"
 
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/salesforce"
 	"github.com/scottraio/simpleforce"
 )
 
@@ -33,7 +34,7 @@ func CreatePinnedLink(client *simpleforce.Client, link PinnedLink) (PinnedLink,
 	// 🚨 Handle failure case properly
 	if createdLink == nil || createdLink.ID() == "" {
 		log.Println("❌ Failed to create pinned link: No ID returned from Salesforce")
-		return link, fmt.Errorf("failed to create pinned link: No ID returned from Salesforce")
+		return link, apperror.Unavailable(nil, "Failed to create pinned link: No ID returned from Salesforce")
 	}
 
 	log.Printf("✅ Pinned Link Created in Salesforce with ID: %s\n", createdLink.ID())
@@ -45,24 +46,35 @@ func CreatePinnedLink(client *simpleforce.Client, link PinnedLink) (PinnedLink,
 
 // FetchPinnedLinks retrieves all pinned links for a given user from Salesforce
 func FetchPinnedLinks(client *simpleforce.Client, email string, app ...string) ([]PinnedLink, error) {
-	query := fmt.Sprintf(`
-		SELECT Id, Name, Path__c, Email__c, App__c
-		FROM Pinned_Link__c 
-		WHERE Email__c = '%s'`, email)
+	query := salesforce.Where("Email__c = ?", email)
 
 	// Add app filter if provided
 	if len(app) > 0 && app[0] != "" {
-		query += fmt.Sprintf(" AND App__c = '%s'", app[0])
+		query = query.Where("App__c = ?", app[0])
 	}
 
-	log.Println("🔍 Fetching pinned links with query:", query)
+	log.Println("🔍 Fetching pinned links for:", email)
 
-	result, err := client.Query(query)
+	links, err := FetchPinnedLinksWhere(client, query)
 	if err != nil {
 		log.Println("❌ Salesforce Query Error:", err)
 		return nil, err
 	}
 
+	log.Println("✅ Retrieved pinned links:", links)
+	return links, nil
+}
+
+// FetchPinnedLinksWhere retrieves the pinned links matching q.
+func FetchPinnedLinksWhere(client *simpleforce.Client, q salesforce.Query) ([]PinnedLink, error) {
+	q = q.Select("Id", "Name", "Path__c", "Email__c", "App__c").
+		From("Pinned_Link__c")
+
+	result, err := q.Run(client)
+	if err != nil {
+		return nil, err
+	}
+
 	var links []PinnedLink
 	for _, record := range result.Records {
 		link := PinnedLink{
@@ -75,7 +87,6 @@ func FetchPinnedLinks(client *simpleforce.Client, email string, app ...string) (
 		links = append(links, link)
 	}
 
-	log.Println("✅ Retrieved pinned links:", links)
 	return links, nil
 }
 
@@ -85,7 +96,7 @@ func DeletePinnedLink(client *simpleforce.Client, id string) error {
 	err := client.SObject("Pinned_Link__c").Set("Id", id).Delete()
 	if err != nil {
 		log.Println("❌ Failed to delete pinned link:", err)
-		return err
+		return salesforce.WrapError(err, "Failed to delete pinned link")
 	}
 
 	log.Println("✅ Pinned Link Deleted Successfully")
@@ -0,0 +1,454 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"golang.org/x/sync/singleflight"
+)
+
+// DerivativesPrefix holds the previews made from files, as
+// .derivatives/<file>/<generation>/<size>.jpg.
+const DerivativesPrefix = ".derivatives/"
+
+// PreviewSizes are the previews made of each file, by the longest side
+// they are scaled to.
+var PreviewSizes = map[string]int{
+	"thumb":   256,
+	"preview": 1024,
+}
+
+// maxPreviewPixels keeps a decoded image to a few hundred MB.
+const maxPreviewPixels = 64 << 20
+
+// DefaultPreviewRenderTimeout is how long a file's previews may take to
+// make when PreviewSettings doesn't say.
+const DefaultPreviewRenderTimeout = 2 * time.Minute
+
+// PreviewSettings configures how previews are made.
+type PreviewSettings struct {
+	// MaxSourceBytes skips files larger than this. Zero means no limit.
+	MaxSourceBytes int64
+	// PDFRenderer is the pdftoppm binary PDF previews are rendered with.
+	// Empty turns PDF previews off.
+	PDFRenderer string
+	// RenderTimeout bounds making a file's previews, pdftoppm included.
+	// Zero means DefaultPreviewRenderTimeout.
+	RenderTimeout time.Duration
+}
+
+// Previewable reports whether previews are made for content of the type.
+func Previewable(contentType string) bool {
+	switch mediaType(contentType) {
+	case "image/jpeg", "image/png", "image/gif", "application/pdf":
+		return true
+	}
+	return false
+}
+
+func mediaType(contentType string) string {
+	t, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+func derivativeFolder(objectName string) string {
+	return DerivativesPrefix + objectName + "/"
+}
+
+func derivativeName(objectName string, generation int64, size string) string {
+	return derivativeFolder(objectName) + strconv.FormatInt(generation, 10) + "/" + size + ".jpg"
+}
+
+// Preview returns the size preview of a file at generation, the live one
+// when generation is zero, making it first if it hasn't been yet.
+func (f *File) Preview(objectName string, generation int64, size string, settings PreviewSettings) (*BlobAttrs, error) {
+	if _, ok := PreviewSizes[size]; !ok {
+		return nil, apperror.Validation("size must be thumb or preview")
+	}
+	if IsHidden(objectName) || objectName == "" || strings.HasSuffix(objectName, "/") {
+		return nil, apperror.NotFound("File %s not found", objectName)
+	}
+
+	var source *BlobAttrs
+	var err error
+	if generation != 0 {
+		source, err = FindVersion(f.Context, f.Store, objectName, generation)
+	} else {
+		source, err = f.Store.Attrs(f.Context, objectName)
+	}
+	if err != nil {
+		return nil, storageError(err, "Failed to read %s", objectName)
+	}
+
+	name := derivativeName(objectName, source.Generation, size)
+	attrs, err := f.Store.Attrs(f.Context, name)
+	if err == nil {
+		return attrs, nil
+	}
+	if !apperror.Is(err, apperror.CodeNotFound) {
+		return nil, storageError(err, "Failed to read preview of %s", objectName)
+	}
+
+	if err := f.makePreviews(*source, settings, generation == 0); err != nil {
+		return nil, err
+	}
+	attrs, err = f.Store.Attrs(f.Context, name)
+	if err != nil {
+		return nil, storageError(err, "Failed to read preview of %s", objectName)
+	}
+	return attrs, nil
+}
+
+// previewRenders shares one render among everyone asking for the previews
+// of the same generation, and previewSlots caps how many renders run at
+// once, since each PDF takes a pdftoppm process.
+var (
+	previewRenders singleflight.Group
+	previewSlots   = make(chan struct{}, max(2, runtime.NumCPU()))
+)
+
+// makePreviews renders every preview size of source. When source is the
+// live generation, previews of older generations are dropped. The render
+// carries on if the caller gives up waiting, so the next asking finds it,
+// until settings.RenderTimeout runs out.
+func (f *File) makePreviews(source BlobAttrs, settings PreviewSettings, live bool) error {
+	key := derivativeFolder(source.Name) + strconv.FormatInt(source.Generation, 10)
+	done := previewRenders.DoChan(key, func() (interface{}, error) {
+		previewSlots <- struct{}{}
+		defer func() { <-previewSlots }()
+
+		timeout := settings.RenderTimeout
+		if timeout <= 0 {
+			timeout = DefaultPreviewRenderTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		background := &File{Store: f.Store, Context: ctx}
+		if err := background.renderPreviews(source, settings, live); err != nil {
+			if ctx.Err() != nil {
+				return nil, apperror.Unavailable(err, "Timed out making the preview of %s", source.Name)
+			}
+			return nil, err
+		}
+		return nil, nil
+	})
+
+	select {
+	case result := <-done:
+		return result.Err
+	case <-f.Context.Done():
+		return apperror.Unavailable(f.Context.Err(), "Gave up waiting for the preview of %s", source.Name)
+	}
+}
+
+func (f *File) renderPreviews(source BlobAttrs, settings PreviewSettings, live bool) error {
+	if !Previewable(source.ContentType) {
+		return apperror.NotFound("No preview is made for %s files", mediaType(source.ContentType))
+	}
+	if settings.MaxSourceBytes > 0 && source.Size > settings.MaxSourceBytes {
+		return apperror.NotFound("%s is too large to preview", source.Name)
+	}
+
+	largest := 0
+	for _, side := range PreviewSizes {
+		largest = max(largest, side)
+	}
+	img, err := f.renderSource(source, largest, settings)
+	if err != nil {
+		return err
+	}
+
+	for size, side := range PreviewSizes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, scaleDown(img, side), &jpeg.Options{Quality: 80}); err != nil {
+			return apperror.Unavailable(err, "Failed to encode preview of %s", source.Name)
+		}
+		_, err := f.Store.Write(f.Context, derivativeName(source.Name, source.Generation, size), &buf, BlobWriteOptions{
+			ContentType: "image/jpeg",
+		})
+		if err != nil {
+			return storageError(err, "Failed to save preview of %s", source.Name)
+		}
+	}
+
+	if live {
+		f.dropPreviews(source.Name, source.Generation)
+	}
+	return nil
+}
+
+// renderSource decodes source. PDFs are rendered from their first page with
+// a longest side of longest.
+func (f *File) renderSource(source BlobAttrs, longest int, settings PreviewSettings) (image.Image, error) {
+	rc, _, err := f.Store.NewVersionReader(f.Context, source.Name, source.Generation, 0, -1)
+	if err != nil {
+		return nil, storageError(err, "Failed to read %s", source.Name)
+	}
+	defer rc.Close()
+
+	if mediaType(source.ContentType) == "application/pdf" {
+		return renderPDF(f.Context, rc, longest, settings.PDFRenderer)
+	}
+
+	var buf bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(rc, &buf))
+	if err != nil {
+		return nil, apperror.Validation("%s is not a readable image", source.Name)
+	}
+	if config.Width*config.Height > maxPreviewPixels {
+		return nil, apperror.NotFound("%s is too large to preview", source.Name)
+	}
+	img, _, err := image.Decode(io.MultiReader(&buf, rc))
+	if err != nil {
+		return nil, apperror.Validation("%s is not a readable image", source.Name)
+	}
+	return img, nil
+}
+
+// renderPDF renders the first page of a PDF with pdftoppm.
+func renderPDF(ctx context.Context, r io.Reader, longest int, renderer string) (image.Image, error) {
+	if renderer == "" {
+		return nil, apperror.NotFound("PDF previews are turned off")
+	}
+
+	dir, err := os.MkdirTemp("", "preview")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in, err := os.Create(filepath.Join(dir, "in.pdf"))
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(in, r)
+	if closeErr := in.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, storageError(err, "Failed to read PDF")
+	}
+
+	out := filepath.Join(dir, "page")
+	cmd := exec.CommandContext(ctx, renderer, "-f", "1", "-l", "1", "-singlefile", "-jpeg",
+		"-scale-to", strconv.Itoa(longest), in.Name(), out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		reason := strings.TrimSpace(string(output))
+		if reason == "" {
+			reason = err.Error()
+		}
+		return nil, apperror.Unavailable(err, "Failed to render PDF: %s", reason)
+	}
+
+	page, err := os.Open(out + ".jpg")
+	if err != nil {
+		return nil, apperror.Unavailable(err, "Failed to render PDF")
+	}
+	defer page.Close()
+	img, err := jpeg.Decode(page)
+	if err != nil {
+		return nil, apperror.Unavailable(err, "Failed to render PDF")
+	}
+	return img, nil
+}
+
+// scaleDown shrinks img so its longest side is at most side, averaging the
+// pixels each output pixel covers, and flattens it onto white.
+func scaleDown(img image.Image, side int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if longest := max(w, h); longest > side {
+		w, h = max(1, w*side/longest), max(1, h*side/longest)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+(y+1)*b.Dy()/h
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+(x+1)*b.Dx()/w
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < max(y1, y0+1); sy++ {
+				for sx := x0; sx < max(x1, x0+1); sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			// Premultiplied, so white shows through what's transparent.
+			white := n*0xffff - a
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(((r + white) / n) >> 8),
+				G: uint8(((g + white) / n) >> 8),
+				B: uint8(((bl + white) / n) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
+
+// dropPreviews deletes the previews of objectName's generations other
+// than keep.
+func (f *File) dropPreviews(objectName string, keep int64) {
+	list, err := f.Store.List(f.Context, BlobQuery{Prefix: derivativeFolder(objectName)})
+	if err != nil {
+		log.Printf("Failed to list previews of %s: %v", objectName, err)
+		return
+	}
+	current := derivativeFolder(objectName) + strconv.FormatInt(keep, 10) + "/"
+	for _, a := range list {
+		rest := strings.TrimPrefix(a.Name, derivativeFolder(objectName))
+		// Previews of files nested under a folder named like the file
+		// belong to those files.
+		if strings.Count(rest, "/") != 1 || strings.HasPrefix(a.Name, current) {
+			continue
+		}
+		if err := f.Store.Delete(f.Context, a.Name); err != nil {
+			log.Printf("Failed to delete preview %s: %v", a.Name, err)
+		}
+	}
+}
+
+// PreviewInBackground makes the previews of a file just uploaded, after
+// the request that uploaded it has returned.
+func (f *File) PreviewInBackground(source BlobAttrs, settings PreviewSettings) {
+	if !Previewable(source.ContentType) {
+		return
+	}
+	background := &File{Store: f.Store, Context: context.Background()}
+	go func() {
+		if err := background.makePreviews(source, settings, true); err != nil {
+			log.Printf("Failed to make previews of %s: %v", source.Name, err)
+		}
+	}()
+}
+
+// BackfillResult reports a backfill batch.
+type BackfillResult struct {
+	Scanned   int
+	Generated int
+	Failed    []ObjectResult
+	// NextCursor is set while there are more files to look at.
+	NextCursor string
+}
+
+// BackfillPreviews makes the missing previews of the next limit files under
+// prefix, starting after cursor.
+func (f *File) BackfillPreviews(prefix, cursor string, limit int, settings PreviewSettings) (*BackfillResult, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	limit = min(limit, 1000)
+
+	var startAfter string
+	if cursor != "" {
+		var err error
+		if startAfter, err = decodeCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &BackfillResult{}
+	for result.Scanned < limit {
+		asked := limit - result.Scanned
+		list, err := f.Store.List(f.Context, BlobQuery{Prefix: prefix, StartAfter: startAfter, Limit: asked})
+		if err != nil {
+			return nil, storageError(err, "Failed to list files")
+		}
+
+		skipped := false
+		for _, a := range list {
+			if IsHidden(a.Name) {
+				startAfter, skipped = pastHidden(a.Name), true
+				break
+			}
+			startAfter = a.Name
+			result.Scanned++
+			if strings.HasSuffix(a.Name, "/") || !Previewable(a.ContentType) {
+				continue
+			}
+
+			missing := false
+			for size := range PreviewSizes {
+				if _, err := f.Store.Attrs(f.Context, derivativeName(a.Name, a.Generation, size)); err != nil {
+					missing = true
+					break
+				}
+			}
+			if !missing {
+				continue
+			}
+			if err := f.makePreviews(a, settings, true); err != nil {
+				result.Failed = append(result.Failed, ObjectResult{Source: a.Name, Err: err})
+				continue
+			}
+			result.Generated++
+		}
+		if !skipped && len(list) < asked {
+			return result, nil
+		}
+	}
+	result.NextCursor = cursorAfter(startAfter)
+	return result, nil
+}
+
+// PruneOrphanedPreviews deletes the previews of files that were deleted,
+// moved or replaced, and returns how many it removed.
+func (f *File) PruneOrphanedPreviews() (int, error) {
+	list, err := f.Store.List(f.Context, BlobQuery{Prefix: DerivativesPrefix})
+	if err != nil {
+		return 0, storageError(err, "Failed to list previews")
+	}
+
+	live := make(map[string]int64)
+	pruned := 0
+	for _, a := range list {
+		rest := strings.TrimPrefix(a.Name, DerivativesPrefix)
+		// <file>/<generation>/<size>.jpg
+		parts := strings.Split(rest, "/")
+		if len(parts) < 3 {
+			continue
+		}
+		objectName := strings.Join(parts[:len(parts)-2], "/")
+		generation, _ := strconv.ParseInt(parts[len(parts)-2], 10, 64)
+
+		current, ok := live[objectName]
+		if !ok {
+			source, err := f.Store.Attrs(f.Context, objectName)
+			switch {
+			case err == nil:
+				current = source.Generation
+			case apperror.Is(err, apperror.CodeNotFound):
+				current = 0
+			default:
+				return pruned, storageError(err, "Failed to read %s", objectName)
+			}
+			live[objectName] = current
+		}
+		if generation == current {
+			continue
+		}
+		if err := f.Store.Delete(f.Context, a.Name); err != nil {
+			log.Printf("Failed to prune preview %s: %v", a.Name, err)
+			continue
+		}
+		pruned++
+	}
+	return pruned, nil
+}
@@ -0,0 +1,36 @@
+package model_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/model"
+)
+
+// TestPreviewRenderTimeout renders a PDF with a pdftoppm that never
+// finishes, which must be killed once the render runs out of time.
+func TestPreviewRenderTimeout(t *testing.T) {
+	renderer := filepath.Join(t.TempDir(), "pdftoppm")
+	if err := os.WriteFile(renderer, []byte("#!/bin/sh\nexec sleep 30\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newTestFile(t)
+	if _, err := f.Store.Write(f.Context, "docs/a.pdf", strings.NewReader("%PDF-1.4"), model.BlobWriteOptions{ContentType: "application/pdf"}); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := model.PreviewSettings{PDFRenderer: renderer, RenderTimeout: 200 * time.Millisecond}
+	start := time.Now()
+	_, err := f.Preview("docs/a.pdf", 0, "thumb", settings)
+	if !apperror.Is(err, apperror.CodeUnavailable) {
+		t.Fatalf("Preview = %v, want it unavailable", err)
+	}
+	if took := time.Since(start); took > 10*time.Second {
+		t.Errorf("Preview took %s, want it cut off after %s", took, settings.RenderTimeout)
+	}
+}
@@ -0,0 +1,134 @@
+package model
+
+import "time"
+
+// Repositories groups the per-entity stores used by the API handlers. The
+// Salesforce-backed set is built with NewSalesforceRepositories; an in-memory
+// set for local runs lives in model/memory.
+type Repositories struct {
+	Comments     CommentRepository
+	Mentions     MentionRepository
+	Issues       IssueRepository
+	Events       EventRepository
+	Users        UserRepository
+	PinnedLinks  PinnedLinkRepository
+	SharedFiles  SharedFileRepository
+	FileLinks    FileLinkRepository
+	FolderGrants FolderGrantRepository
+	APIKeys      APIKeyRepository
+}
+
+type CommentRepository interface {
+	// ForRecord returns the comments on a record, oldest first.
+	ForRecord(recordID string) ([]Comment, error)
+	// Get returns the comment with the given Id, or an apperror.CodeNotFound error.
+	Get(id string) (*Comment, error)
+	// Create saves the comment and a mention for its author and every
+	// mentioned user. The new Id is set on c.
+	Create(c *Comment) error
+	Delete(id string) error
+	// RecordName looks up the Name of the record a comment is attached to.
+	RecordName(recordType, recordID string) (string, error)
+}
+
+type MentionRepository interface {
+	// ForRecord returns every mention on a record, oldest first.
+	ForRecord(recordID string) ([]CommentMention, error)
+	// ForRecordOfType returns the mentions on a record of the given object type.
+	ForRecordOfType(recordID, recordType string) ([]CommentMention, error)
+	// Add saves the mentions that don't already exist for their record.
+	Add(mentions []CommentMention) error
+}
+
+type IssueRepository interface {
+	// Open returns the issues that are not closed, newest first.
+	Open() ([]Issue, error)
+	// Get returns the issue with the given Id, or an apperror.CodeNotFound error.
+	Get(id string) (*Issue, error)
+	Create(i *Issue) error
+	Update(i *Issue) error
+	Close(id string) error
+}
+
+type EventRepository interface {
+	// All returns every named event ordered by start time.
+	All() ([]Event, error)
+	// Upcoming returns the events that haven't ended yet ordered by end time.
+	Upcoming() ([]Event, error)
+	// Get returns the event with the given Id, or an apperror.CodeNotFound error.
+	Get(id string) (*Event, error)
+	Create(e *Event) error
+	Update(e *Event) error
+	Delete(id string) error
+}
+
+type UserRepository interface {
+	// FindByEmail returns the user with the given email, or an
+	// apperror.CodeNotFound error.
+	FindByEmail(email string) (*User, error)
+	// IssueSubscribers returns the users who receive issue notifications.
+	IssueSubscribers() ([]User, error)
+	// Mentionable returns the active users that can be @mentioned in comments.
+	Mentionable() ([]MentionableUser, error)
+	Update(u *User) error
+}
+
+type PinnedLinkRepository interface {
+	// ForUser returns the links pinned by email, optionally limited to one app.
+	ForUser(email, app string) ([]PinnedLink, error)
+	// Get returns the link with the given Id, or an apperror.CodeNotFound error.
+	Get(id string) (*PinnedLink, error)
+	Create(link PinnedLink) (PinnedLink, error)
+	Delete(id string) error
+}
+
+type SharedFileRepository interface {
+	// ForRecord returns the bucket files shared with a Salesforce record. An
+	// empty object or objectID matches any.
+	ForRecord(object, objectID string) ([]SharedFile, error)
+	// UnderPath returns the shares whose path contains path.
+	UnderPath(path string) ([]SharedFile, error)
+	// Share links the file at path to a Salesforce record.
+	Share(path string, item SharedItem) error
+	// DeleteByPath removes every share of the file at path.
+	DeleteByPath(path string) error
+	// Move repoints the shares of each old path in moves to its new path.
+	// If any share can't be moved, those already moved are put back; the
+	// error names any that couldn't be.
+	Move(moves map[string]string) error
+	// MigrateLegacy moves up to limit shares out of the legacy object into
+	// the one every other method uses, and reports how many it moved and
+	// whether any are left.
+	MigrateLegacy(limit int) (migrated int, more bool, err error)
+}
+
+type FolderGrantRepository interface {
+	// ForPath returns the grants that bear on path: those on the folders it
+	// sits in and, for listing, those on folders beneath them.
+	ForPath(path string) ([]FolderGrant, error)
+	// Create saves the grant and sets its Id and GrantedAt.
+	Create(g *FolderGrant) error
+	// Delete removes the grant and returns what it was.
+	Delete(id string) (*FolderGrant, error)
+}
+
+type FileLinkRepository interface {
+	// Find returns the links issued for path and sent to recipient, newest
+	// first. An empty path or recipient matches any.
+	Find(path, recipient string) ([]FileLink, error)
+	// Create saves the link and sets its Id and IssuedAt.
+	Create(l *FileLink) error
+}
+
+type APIKeyRepository interface {
+	// All returns every key, including revoked and expired ones.
+	All() ([]APIKey, error)
+	// FindByHash returns the key whose hash is hash, or an
+	// apperror.CodeNotFound error.
+	FindByHash(hash string) (*APIKey, error)
+	// Create saves the key and sets its Id.
+	Create(k *APIKey) error
+	Revoke(id string) error
+	// Touch records that the key was used at the given time.
+	Touch(id string, at time.Time) error
+}
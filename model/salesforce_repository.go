@@ -0,0 +1,636 @@
+package model
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/salesforce"
+	"github.com/scottraio/simpleforce"
+)
+
+// NewSalesforceRepositories returns repositories backed by the given Salesforce
+// session. Reads are retried on transient failures; writes only after the
+// session was rejected.
+func NewSalesforceRepositories(sf *salesforce.SF) Repositories {
+	return Repositories{
+		Comments:     &sfComments{sf: sf},
+		Mentions:     &sfMentions{sf: sf},
+		Issues:       &sfIssues{sf: sf},
+		Events:       &sfEvents{sf: sf},
+		Users:        &sfUsers{sf: sf},
+		PinnedLinks:  &sfPinnedLinks{sf: sf},
+		SharedFiles:  &sfSharedFiles{sf: sf},
+		FileLinks:    &sfFileLinks{sf: sf},
+		FolderGrants: &sfFolderGrants{sf: sf},
+		APIKeys:      &sfAPIKeys{sf: sf},
+	}
+}
+
+// Comments
+
+type sfComments struct {
+	sf *salesforce.SF
+}
+
+func (r *sfComments) ForRecord(recordID string) (comments []Comment, err error) {
+	err = r.sf.Read(func(client *simpleforce.Client) error {
+		comments, err = FetchComments(client, salesforce.Where("Record_ID__c = ?", recordID).OrderBy("CreatedDate ASC"))
+		return err
+	})
+	return comments, err
+}
+
+func (r *sfComments) Get(id string) (*Comment, error) {
+	var comments []Comment
+	err := r.sf.Read(func(client *simpleforce.Client) (err error) {
+		comments, err = FetchComments(client, salesforce.Where("Id = ?", id))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(comments) == 0 {
+		return nil, apperror.NotFound("Comment %s not found", id)
+	}
+	return &comments[0], nil
+}
+
+func (r *sfComments) Create(c *Comment) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		return c.Create(client)
+	})
+}
+
+func (r *sfComments) Delete(id string) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		c := Comment{Id: id}
+		return c.Delete(client)
+	})
+}
+
+func (r *sfComments) RecordName(recordType, recordID string) (name string, err error) {
+	err = r.sf.Read(func(client *simpleforce.Client) error {
+		c := Comment{RecordType: recordType, RecordID: recordID}
+		name, err = c.GetRecordName(client)
+		return err
+	})
+	return name, err
+}
+
+// Mentions
+
+type sfMentions struct {
+	sf *salesforce.SF
+}
+
+func (r *sfMentions) ForRecord(recordID string) (mentions []CommentMention, err error) {
+	err = r.sf.Read(func(client *simpleforce.Client) error {
+		mentions, err = FetchMentions(client, salesforce.Where("Record_ID__c = ?", recordID).OrderBy("CreatedDate ASC"))
+		return err
+	})
+	return mentions, err
+}
+
+func (r *sfMentions) ForRecordOfType(recordID, recordType string) (mentions []CommentMention, err error) {
+	err = r.sf.Read(func(client *simpleforce.Client) error {
+		mentions, err = FetchMentions(client, salesforce.Where("Record_ID__c = ? AND Record_Type__c = ?", recordID, recordType))
+		return err
+	})
+	return mentions, err
+}
+
+func (r *sfMentions) Add(mentions []CommentMention) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		return AddMentions(client, mentions)
+	})
+}
+
+// Issues
+
+type sfIssues struct {
+	sf *salesforce.SF
+}
+
+func (r *sfIssues) Open() (issues []Issue, err error) {
+	err = r.sf.Read(func(client *simpleforce.Client) error {
+		issues, err = FetchIssues(client, salesforce.Where("Closed__c = ?", false).OrderBy("CreatedDate DESC"))
+		return err
+	})
+	return issues, err
+}
+
+func (r *sfIssues) Get(id string) (*Issue, error) {
+	var issues []Issue
+	err := r.sf.Read(func(client *simpleforce.Client) (err error) {
+		issues, err = FetchIssues(client, salesforce.Where("Id = ?", id))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(issues) == 0 {
+		return nil, apperror.NotFound("Issue %s not found", id)
+	}
+	return &issues[0], nil
+}
+
+func (r *sfIssues) Create(i *Issue) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		return i.Create(client)
+	})
+}
+
+func (r *sfIssues) Update(i *Issue) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		return i.Update(client)
+	})
+}
+
+func (r *sfIssues) Close(id string) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		i := Issue{Id: id}
+		return i.Close(client)
+	})
+}
+
+// Events
+
+type sfEvents struct {
+	sf *salesforce.SF
+}
+
+func (r *sfEvents) All() (events []Event, err error) {
+	err = r.sf.Read(func(client *simpleforce.Client) error {
+		events, err = FetchEvents(client, salesforce.Where("Name != null").OrderBy("Start_Date_Time__c ASC"))
+		return err
+	})
+	return events, err
+}
+
+func (r *sfEvents) Upcoming() (events []Event, err error) {
+	err = r.sf.Read(func(client *simpleforce.Client) error {
+		events, err = FetchEvents(client, salesforce.Where("Name != null AND End_Date_Time__c > TODAY").OrderBy("End_Date_Time__c ASC"))
+		return err
+	})
+	return events, err
+}
+
+func (r *sfEvents) Get(id string) (*Event, error) {
+	var events []Event
+	err := r.sf.Read(func(client *simpleforce.Client) (err error) {
+		events, err = FetchEvents(client, salesforce.Where("Id = ?", id))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, apperror.NotFound("Event %s not found", id)
+	}
+	return &events[0], nil
+}
+
+func (r *sfEvents) Create(e *Event) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		id, err := CreateEvent(client, *e)
+		if err != nil {
+			return err
+		}
+		e.Id = id
+		return nil
+	})
+}
+
+func (r *sfEvents) Update(e *Event) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		return UpdateEvent(client, *e)
+	})
+}
+
+func (r *sfEvents) Delete(id string) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		return DeleteEvent(client, id)
+	})
+}
+
+// Users
+
+type sfUsers struct {
+	sf *salesforce.SF
+}
+
+func (r *sfUsers) FindByEmail(email string) (*User, error) {
+	var users []User
+	err := r.sf.Read(func(client *simpleforce.Client) (err error) {
+		users, err = FetchUsers(client, salesforce.Where("rstk__syusr_empl_email__c = ?", email).Limit(1))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, apperror.NotFound("User %s not found", email)
+	}
+	return &users[0], nil
+}
+
+func (r *sfUsers) IssueSubscribers() (users []User, err error) {
+	err = r.sf.Read(func(client *simpleforce.Client) error {
+		users, err = FetchUsers(client, salesforce.Where("Issue_Notifications__c = ?", true))
+		return err
+	})
+	return users, err
+}
+
+func (r *sfUsers) Mentionable() (users []MentionableUser, err error) {
+	err = r.sf.Read(func(client *simpleforce.Client) error {
+		users, err = MentionableUsers(client)
+		return err
+	})
+	return users, err
+}
+
+func (r *sfUsers) Update(u *User) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		return u.Update(client)
+	})
+}
+
+// Pinned links
+
+type sfPinnedLinks struct {
+	sf *salesforce.SF
+}
+
+func (r *sfPinnedLinks) ForUser(email, app string) (links []PinnedLink, err error) {
+	err = r.sf.Read(func(client *simpleforce.Client) error {
+		links, err = FetchPinnedLinks(client, email, app)
+		return err
+	})
+	return links, err
+}
+
+func (r *sfPinnedLinks) Get(id string) (*PinnedLink, error) {
+	var links []PinnedLink
+	err := r.sf.Read(func(client *simpleforce.Client) (err error) {
+		links, err = FetchPinnedLinksWhere(client, salesforce.Where("Id = ?", id))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		return nil, apperror.NotFound("Pinned link %s not found", id)
+	}
+	return &links[0], nil
+}
+
+func (r *sfPinnedLinks) Create(link PinnedLink) (created PinnedLink, err error) {
+	err = r.sf.Write(func(client *simpleforce.Client) error {
+		created, err = CreatePinnedLink(client, link)
+		return err
+	})
+	return created, err
+}
+
+func (r *sfPinnedLinks) Delete(id string) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		return DeletePinnedLink(client, id)
+	})
+}
+
+// Shared files
+
+type sfSharedFiles struct {
+	sf *salesforce.SF
+}
+
+func (r *sfSharedFiles) ForRecord(object, objectID string) (files []SharedFile, err error) {
+	q := salesforce.Query{}
+	if objectID != "" {
+		q = q.Where("Object_Id__c = ?", objectID)
+	}
+	if object != "" {
+		q = q.Where("Object__c = ?", object)
+	}
+	err = r.sf.Read(func(client *simpleforce.Client) error {
+		files, err = FetchFiles(client, q)
+		return err
+	})
+	return files, err
+}
+
+func (r *sfSharedFiles) UnderPath(path string) (files []SharedFile, err error) {
+	err = r.sf.Read(func(client *simpleforce.Client) error {
+		files, err = FetchFiles(client, salesforce.Where("Path__c LIKE ?", salesforce.Contains(path)))
+		return err
+	})
+	return files, err
+}
+
+// sharedFileExternalID keys a share on the record it's shared with and the
+// file's path.
+func sharedFileExternalID(object, objectID, path string) string {
+	externalID := fmt.Sprintf("%s-%s-%s", object, objectID, path)
+	return base64.StdEncoding.EncodeToString([]byte(externalID))
+}
+
+func (r *sfSharedFiles) Share(path string, item SharedItem) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		upserted := client.SObject(sharedFileObject).
+			Set("ExternalIDField", "ExternalId__c").
+			Set("ExternalId__c", sharedFileExternalID(item.Object, item.ObjectId, path)).
+			Set("Object__c", item.Object).
+			Set("Object_Id__c", item.ObjectId).
+			Set("Object_Name__c", item.ObjectName).
+			Set("Path__c", path).
+			Upsert()
+
+		if upserted == nil {
+			return apperror.Unavailable(nil, "Failed to share %s with %s %s", path, item.Object, item.ObjectId)
+		}
+		return nil
+	})
+}
+
+func (r *sfSharedFiles) MigrateLegacy(limit int) (int, bool, error) {
+	if limit <= 0 || limit > MaxShareMigration {
+		limit = MaxShareMigration
+	}
+
+	var result *simpleforce.QueryResult
+	err := r.sf.Read(func(client *simpleforce.Client) (err error) {
+		result, err = salesforce.Select("Id", "Object__c", "Object_Id__c", "Object_Name__c", "Path__c").
+			From(legacySharedFileObject).
+			OrderBy("CreatedDate", "Id").
+			Limit(limit + 1).
+			Run(client)
+		return err
+	})
+	if err != nil {
+		return 0, false, salesforce.WrapError(err, "Failed to list legacy shares")
+	}
+
+	records := result.Records
+	more := len(records) > limit
+	if more {
+		records = records[:limit]
+	}
+
+	// The upsert is keyed on the share, so a record that was copied but
+	// not deleted is copied again harmlessly next time.
+	for n, record := range records {
+		item := SharedItem{
+			Object:     getStringField("Object__c", record),
+			ObjectId:   getStringField("Object_Id__c", record),
+			ObjectName: getStringField("Object_Name__c", record),
+		}
+		if err := r.Share(getStringField("Path__c", record), item); err != nil {
+			return n, true, err
+		}
+		err := r.sf.Write(func(client *simpleforce.Client) error {
+			return client.SObject(legacySharedFileObject).Set("Id", getStringField("Id", record)).Delete()
+		})
+		if err != nil {
+			return n, true, salesforce.WrapError(err, "Failed to delete legacy share")
+		}
+	}
+	return len(records), more, nil
+}
+
+func (r *sfSharedFiles) DeleteByPath(path string) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		result, err := salesforce.Select("Id").
+			From(sharedFileObject).
+			Where("Path__c = ?", path).
+			Run(client)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range result.Records {
+			if err := client.SObject(sharedFileObject).Set("Id", getStringField("Id", record)).Delete(); err != nil {
+				return salesforce.WrapError(err, "Failed to delete shared file")
+			}
+		}
+
+		return nil
+	})
+}
+
+// File links
+
+type sfFileLinks struct {
+	sf *salesforce.SF
+}
+
+func (r *sfFileLinks) Find(path, recipient string) (links []FileLink, err error) {
+	q := salesforce.Where("Id != null")
+	if path != "" {
+		q = q.Where("Path__c = ?", path)
+	}
+	if recipient != "" {
+		q = q.Where("Recipient__c = ?", recipient)
+	}
+
+	err = r.sf.Read(func(client *simpleforce.Client) error {
+		links, err = FetchFileLinks(client, q.OrderBy("CreatedDate DESC"))
+		return err
+	})
+	return links, err
+}
+
+func (r *sfFileLinks) Create(l *FileLink) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		id, err := CreateFileLink(client, *l)
+		if err != nil {
+			return err
+		}
+		l.Id = id
+		l.IssuedAt = time.Now()
+		return nil
+	})
+}
+
+// Folder grants
+
+type sfFolderGrants struct {
+	sf *salesforce.SF
+}
+
+func (r *sfFolderGrants) ForPath(path string) (grants []FolderGrant, err error) {
+	folder := path[:strings.LastIndex(path, "/")+1]
+	ancestors := append([]string{RootFolder}, folderAncestors(folder)...)
+	q := salesforce.Where("Folder__c IN ? OR Folder__c LIKE ?", ancestors, salesforce.StartsWith(folder))
+
+	err = r.sf.Read(func(client *simpleforce.Client) error {
+		grants, err = FetchFolderGrants(client, q.OrderBy("Folder__c ASC"))
+		return err
+	})
+	return grants, err
+}
+
+func (r *sfFolderGrants) Create(g *FolderGrant) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		id, err := CreateFolderGrant(client, *g)
+		if err != nil {
+			return err
+		}
+		g.Id = id
+		g.GrantedAt = time.Now()
+		return nil
+	})
+}
+
+func (r *sfFolderGrants) Delete(id string) (grant *FolderGrant, err error) {
+	err = r.sf.Write(func(client *simpleforce.Client) error {
+		grants, err := FetchFolderGrants(client, salesforce.Where("Id = ?", id))
+		if err != nil {
+			return err
+		}
+		if len(grants) == 0 {
+			return apperror.NotFound("Folder grant %s not found", id)
+		}
+		grant = &grants[0]
+		return DeleteFolderGrant(client, id)
+	})
+	return grant, err
+}
+
+// movePathBatch keeps the IN list of a Move query well inside SOQL's
+// statement length limit.
+const movePathBatch = 200
+
+func (r *sfSharedFiles) Move(moves map[string]string) error {
+	type share struct {
+		id, object, objectID, from string
+	}
+
+	paths := make([]string, 0, len(moves))
+	for from := range moves {
+		paths = append(paths, from)
+	}
+
+	var shares []share
+	for start := 0; start < len(paths); start += movePathBatch {
+		batch := paths[start:min(start+movePathBatch, len(paths))]
+		err := r.sf.Read(func(client *simpleforce.Client) error {
+			result, err := salesforce.Select("Id", "Object__c", "Object_Id__c", "Path__c").
+				From(sharedFileObject).
+				Where("Path__c IN ?", batch).
+				Run(client)
+			if err != nil {
+				return err
+			}
+			for _, record := range result.Records {
+				shares = append(shares, share{
+					id:       getStringField("Id", record),
+					object:   getStringField("Object__c", record),
+					objectID: getStringField("Object_Id__c", record),
+					from:     getStringField("Path__c", record),
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	repoint := func(batch []share, to func(share) string) error {
+		records := make([]salesforce.Record, len(batch))
+		for n, s := range batch {
+			path := to(s)
+			records[n] = salesforce.Record{Id: s.id, Fields: map[string]interface{}{
+				"Path__c":       path,
+				"ExternalId__c": sharedFileExternalID(s.object, s.objectID, path),
+			}}
+		}
+		return r.sf.UpdateCollection(sharedFileObject, records)
+	}
+	moved := func(s share) string { return moves[s.from] }
+	back := func(s share) string { return s.from }
+
+	// Each batch is saved all or none. Past the first, a failure puts the
+	// batches already saved back.
+	for start := 0; start < len(shares); start += salesforce.CollectionLimit {
+		batch := shares[start:min(start+salesforce.CollectionLimit, len(shares))]
+		err := repoint(batch, moved)
+		if err == nil {
+			continue
+		}
+
+		var stuck []string
+		for done := 0; done < start; done += salesforce.CollectionLimit {
+			undo := shares[done:min(done+salesforce.CollectionLimit, start)]
+			if err := repoint(undo, back); err != nil {
+				log.Printf("Failed to restore %d shares: %v", len(undo), err)
+				for _, s := range undo {
+					stuck = append(stuck, s.id)
+				}
+			}
+		}
+		if len(stuck) > 0 {
+			return apperror.Unavailable(err, "Failed to move shares, and shares %s could not be put back", strings.Join(stuck, ", "))
+		}
+		return salesforce.WrapError(err, "Failed to move shares")
+	}
+
+	return nil
+}
+
+// API keys
+
+type sfAPIKeys struct {
+	sf *salesforce.SF
+}
+
+func (r *sfAPIKeys) All() (keys []APIKey, err error) {
+	err = r.sf.Read(func(client *simpleforce.Client) error {
+		keys, err = FetchAPIKeys(client, salesforce.Where("Id != null").OrderBy("CreatedDate DESC"))
+		return err
+	})
+	return keys, err
+}
+
+func (r *sfAPIKeys) FindByHash(hash string) (*APIKey, error) {
+	var keys []APIKey
+	err := r.sf.Read(func(client *simpleforce.Client) (err error) {
+		keys, err = FetchAPIKeys(client, salesforce.Where("Key_Hash__c = ?", hash).Limit(1))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, apperror.NotFound("API key not found")
+	}
+	return &keys[0], nil
+}
+
+func (r *sfAPIKeys) Create(k *APIKey) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		id, err := CreateAPIKey(client, *k)
+		if err != nil {
+			return err
+		}
+		k.Id = id
+		return nil
+	})
+}
+
+func (r *sfAPIKeys) Revoke(id string) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		return RevokeAPIKey(client, id)
+	})
+}
+
+func (r *sfAPIKeys) Touch(id string, at time.Time) error {
+	return r.sf.Write(func(client *simpleforce.Client) error {
+		return TouchAPIKey(client, id, at)
+	})
+}
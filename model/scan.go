@@ -0,0 +1,261 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/services"
+)
+
+// QuarantinePrefix holds uploads while they are scanned. Only clean files
+// leave it for the path they were uploaded to.
+const QuarantinePrefix = ".quarantine/"
+
+// quarantineTTL is how long an upload can sit in quarantine before it is
+// taken to be left over from a crash and purged.
+const quarantineTTL = 24 * time.Hour
+
+// Scan statuses, kept in a file's metadata under scanStatusKey. Files
+// uploaded before scanning was added have none, and count as unscanned.
+const (
+	ScanClean     = "clean"
+	ScanInfected  = "infected"
+	ScanUnscanned = "unscanned"
+)
+
+// noopScannerName is what the scanner package's no-op scanner calls itself.
+const noopScannerName = "none"
+
+const (
+	scanStatusKey    = "scan-status"
+	scannedByKey     = "scanned-by"
+	scannedAtKey     = "scanned-at"
+	scanSignatureKey = "scan-signature"
+)
+
+// Scanner checks file content for malware. ClamAV and no-op scanners live
+// in the scanner package.
+type Scanner interface {
+	// Name identifies the scanner in the files it scans.
+	Name() string
+	// Scan reads r to the end and reports what it found, or ErrScanSkipped
+	// if it can't judge the content.
+	Scan(ctx context.Context, r io.Reader) (*ScanResult, error)
+}
+
+type ScanResult struct {
+	Infected bool
+	// Signature names what was found.
+	Signature string
+}
+
+// ErrScanSkipped is returned by scanners for content they won't scan, such
+// as files over their size limit. Such files are stored as unscanned.
+var ErrScanSkipped = errors.New("file was not scanned")
+
+// ScanStatus returns a file's scan status. Files the no-op scanner
+// recorded as clean, before it recorded them as unscanned, count as
+// unscanned.
+func ScanStatus(a *BlobAttrs) string {
+	status := a.Metadata[scanStatusKey]
+	if status == "" || status == ScanClean && a.Metadata[scannedByKey] == noopScannerName {
+		return ScanUnscanned
+	}
+	return status
+}
+
+// CheckScanned refuses files that haven't been scanned clean, for anything
+// that hands them out beyond the bucket. Folders hold nothing to scan.
+func CheckScanned(a *BlobAttrs) error {
+	if strings.HasSuffix(a.Name, "/") {
+		return nil
+	}
+	switch ScanStatus(a) {
+	case ScanClean:
+		return nil
+	case ScanInfected:
+		return apperror.Forbidden("%s is infected with %s and cannot be shared", a.Name, a.Metadata[scanSignatureKey])
+	}
+	return apperror.Conflict("%s has not been scanned for malware and cannot be shared yet", a.Name)
+}
+
+func quarantineName(objectName string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return QuarantinePrefix + hex.EncodeToString(b) + "/" + objectName, nil
+}
+
+// scan runs scanner over a stored object and returns the metadata that
+// records the outcome. A nil scanner leaves the file unscanned.
+func (f *File) scan(name string, scanner Scanner) (map[string]string, *ScanResult, error) {
+	if scanner == nil {
+		return map[string]string{scanStatusKey: ScanUnscanned}, &ScanResult{}, nil
+	}
+
+	rc, _, err := f.Store.NewRangeReader(f.Context, name, 0, -1)
+	if err != nil {
+		return nil, nil, storageError(err, "Failed to read %s for scanning", name)
+	}
+	defer rc.Close()
+
+	metadata := map[string]string{
+		scannedByKey: scanner.Name(),
+		scannedAtKey: time.Now().UTC().Format(time.RFC3339),
+	}
+	result, err := scanner.Scan(f.Context, rc)
+	switch {
+	case errors.Is(err, ErrScanSkipped):
+		metadata[scanStatusKey] = ScanUnscanned
+		return metadata, &ScanResult{}, nil
+	case err != nil:
+		return nil, nil, apperror.Unavailable(err, "Failed to scan file for malware")
+	case result.Infected:
+		metadata[scanStatusKey] = ScanInfected
+		metadata[scanSignatureKey] = result.Signature
+	default:
+		metadata[scanStatusKey] = ScanClean
+	}
+	return metadata, result, nil
+}
+
+// notifyInfected tells whoever uploaded a file that it was found infected.
+func (f *File) notifyInfected(uploadedBy, objectName, signature string) {
+	if !strings.Contains(uploadedBy, "@") {
+		return
+	}
+
+	knock := services.Knock{
+		WorkFlowId: "infected-file",
+		Email:      uploadedBy,
+	}
+
+	knock.Identify()
+	err := knock.Trigger([]string{uploadedBy}, map[string]interface{}{
+		"Name":      objectName,
+		"Signature": signature,
+	})
+	if err != nil {
+		log.Printf("Failed to notify %s of infected file %s: %v", uploadedBy, objectName, err)
+	}
+}
+
+// ScanReport sums up a rescan batch.
+type ScanReport struct {
+	Scanned  int
+	Clean    int
+	Infected []string
+	Failed   []ObjectResult
+	// NextCursor is set while there are more files to look at.
+	NextCursor string
+}
+
+// needsScan reports whether a file is yet to be scanned by a real scanner.
+func needsScan(a *BlobAttrs) bool {
+	return ScanStatus(a) == ScanUnscanned
+}
+
+// ScanExisting scans the unscanned files among the next limit under prefix,
+// starting after cursor, such as those uploaded before scanning was added
+// or while it was off. Files found infected are made
+// private and their uploader told; they stay where they are for an admin
+// to deal with.
+func (f *File) ScanExisting(prefix, cursor string, limit int, scanner Scanner) (*ScanReport, error) {
+	if scanner == nil || scanner.Name() == noopScannerName {
+		return nil, apperror.Unavailable(nil, "No malware scanner is configured")
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+	limit = min(limit, 1000)
+
+	var startAfter string
+	if cursor != "" {
+		var err error
+		if startAfter, err = decodeCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &ScanReport{}
+	looked := 0
+	for looked < limit {
+		asked := limit - looked
+		list, err := f.Store.List(f.Context, BlobQuery{Prefix: prefix, StartAfter: startAfter, Limit: asked})
+		if err != nil {
+			return nil, storageError(err, "Failed to list files")
+		}
+
+		skipped := false
+		for _, a := range list {
+			if IsHidden(a.Name) {
+				startAfter, skipped = pastHidden(a.Name), true
+				break
+			}
+			startAfter = a.Name
+			looked++
+			if strings.HasSuffix(a.Name, "/") || !needsScan(&a) {
+				continue
+			}
+
+			report.Scanned++
+			metadata, result, err := f.scan(a.Name, scanner)
+			if err == nil {
+				_, err = f.Store.UpdateMetadata(f.Context, a.Name, metadata)
+			}
+			if err != nil {
+				report.Failed = append(report.Failed, ObjectResult{Source: a.Name, Err: storageError(err, "Failed to scan %s", a.Name)})
+				continue
+			}
+			if !result.Infected {
+				if metadata[scanStatusKey] == ScanClean {
+					report.Clean++
+				}
+				continue
+			}
+
+			report.Infected = append(report.Infected, a.Name)
+			if a.Public {
+				if err := f.Store.SetPublic(f.Context, a.Name, false); err != nil {
+					log.Printf("Failed to make infected file %s private: %v", a.Name, err)
+				}
+			}
+			f.notifyInfected(a.Metadata[uploadedByKey], a.Name, result.Signature)
+		}
+		if !skipped && len(list) < asked {
+			return report, nil
+		}
+	}
+	report.NextCursor = cursorAfter(startAfter)
+	return report, nil
+}
+
+// PurgeStaleQuarantine deletes uploads left in quarantine by scans that
+// never finished, and returns how many it removed.
+func (f *File) PurgeStaleQuarantine(now time.Time) (int, error) {
+	list, err := f.Store.List(f.Context, BlobQuery{Prefix: QuarantinePrefix})
+	if err != nil {
+		return 0, storageError(err, "Failed to list quarantine")
+	}
+
+	purged := 0
+	for _, a := range list {
+		if a.Created.Add(quarantineTTL).After(now) {
+			continue
+		}
+		if err := f.Store.Delete(f.Context, a.Name); err != nil {
+			log.Printf("Failed to purge quarantined upload %s: %v", a.Name, err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,40 @@
+package model_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/model"
+	"github.com/Proluxe/proluxe-common-api/scanner"
+)
+
+// TestNoopScanLeavesUnscanned checks that files the no-op scanner lets
+// through can't be handed out as if they had been scanned clean.
+func TestNoopScanLeavesUnscanned(t *testing.T) {
+	f := newTestFile(t)
+	attrs, err := f.Upload("docs/a.txt", strings.NewReader("hello"), model.UploadPolicy{Scanner: scanner.Noop{}}, nil, "staff@proluxe.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := model.ScanStatus(attrs); status != model.ScanUnscanned {
+		t.Errorf("scan status = %s, want %s", status, model.ScanUnscanned)
+	}
+	if err := model.CheckScanned(attrs); !apperror.Is(err, apperror.CodeConflict) {
+		t.Errorf("CheckScanned = %v, want a conflict", err)
+	}
+
+	// Files the no-op scanner recorded as clean before it stopped doing so.
+	write(t, f, "docs/b.txt", "hello")
+	legacy, err := f.Store.UpdateMetadata(f.Context, "docs/b.txt", map[string]string{"scan-status": model.ScanClean, "scanned-by": "none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := model.CheckScanned(legacy); !apperror.Is(err, apperror.CodeConflict) {
+		t.Errorf("CheckScanned of a file clean by the no-op scanner = %v, want a conflict", err)
+	}
+
+	if _, err := f.ScanExisting("docs/", "", 0, scanner.Noop{}); !apperror.Is(err, apperror.CodeUnavailable) {
+		t.Errorf("ScanExisting with the no-op scanner = %v, want it unavailable", err)
+	}
+}
@@ -0,0 +1,252 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+)
+
+// TrashPrefix holds deleted files until they are restored or purged. Each
+// delete gets its own folder, .trash/<id>/, under which the deleted objects
+// keep their full original names, so restoring is a move back out. Shares
+// follow the objects into the trash and out again.
+const TrashPrefix = ".trash/"
+
+// DefaultTrashRetention is how long deleted files are kept by default.
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// Metadata keys stamped on trashed objects.
+const (
+	trashPathKey      = "trash-path"
+	trashDeletedAtKey = "trash-deleted-at"
+	trashDeletedByKey = "trash-deleted-by"
+	trashExpiresKey   = "trash-expires"
+)
+
+// TrashItem is one delete: a file, or a folder and everything in it.
+type TrashItem struct {
+	Id        string    `json:"Id"`
+	Path      string    `json:"Path"`
+	Files     int       `json:"Files"`
+	Size      int64     `json:"Size"`
+	DeletedAt time.Time `json:"DeletedAt"`
+	DeletedBy string    `json:"DeletedBy"`
+	ExpiresAt time.Time `json:"ExpiresAt"`
+}
+
+func trashFolder(id string) string {
+	return TrashPrefix + id + "/"
+}
+
+// Trash moves the file at objectName, or the folder and everything under it
+// when objectName ends in "/", into the trash for retention.
+func (f *File) Trash(objectName, deletedBy string, retention time.Duration) (*TrashItem, []ObjectResult, error) {
+	if objectName == "" || IsHidden(objectName) {
+		return nil, nil, apperror.Validation("Path is reserved")
+	}
+
+	now := time.Now().UTC()
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return nil, nil, err
+	}
+	// Ids sort by deletion time.
+	id := now.Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+
+	results, err := f.move(objectName, trashFolder(id)+objectName, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	item := &TrashItem{
+		Id:        id,
+		Path:      objectName,
+		DeletedAt: now,
+		DeletedBy: deletedBy,
+		ExpiresAt: now.Add(retention),
+	}
+	metadata := map[string]string{
+		trashPathKey:      item.Path,
+		trashDeletedAtKey: item.DeletedAt.Format(time.RFC3339),
+		trashDeletedByKey: item.DeletedBy,
+		trashExpiresKey:   item.ExpiresAt.Format(time.RFC3339),
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			continue
+		}
+		attrs, err := f.Store.UpdateMetadata(f.Context, r.Destination, metadata)
+		if err != nil {
+			// Without it the item is still listed and restorable, only
+			// without its dates; it is then purged on the next sweep.
+			log.Printf("Failed to stamp %s: %v", r.Destination, err)
+			continue
+		}
+		item.Files++
+		item.Size += attrs.Size
+	}
+
+	return item, results, nil
+}
+
+// ListTrash returns the items in the trash, most recently deleted first.
+func (f *File) ListTrash() ([]TrashItem, error) {
+	list, err := f.Store.List(f.Context, BlobQuery{Prefix: TrashPrefix})
+	if err != nil {
+		return nil, storageError(err, "Failed to list trash")
+	}
+
+	items := make(map[string]*TrashItem)
+	// Items whose objects all lost their stamp are given the folder that
+	// holds them all as their path, which restores each object to where
+	// it was.
+	stamped := make(map[string]bool)
+	for _, obj := range list {
+		id, name, ok := strings.Cut(strings.TrimPrefix(obj.Name, TrashPrefix), "/")
+		if !ok {
+			continue
+		}
+
+		item, seen := items[id]
+		switch {
+		case !seen:
+			item = &TrashItem{Id: id, Path: name}
+			items[id] = item
+		case !stamped[id]:
+			item.Path = commonFolder(item.Path, name)
+		}
+		item.Files++
+		item.Size += obj.Size
+
+		if path := obj.Metadata[trashPathKey]; path != "" {
+			stamped[id] = true
+			item.Path = path
+			item.DeletedBy = obj.Metadata[trashDeletedByKey]
+			item.DeletedAt, _ = time.Parse(time.RFC3339, obj.Metadata[trashDeletedAtKey])
+			item.ExpiresAt, _ = time.Parse(time.RFC3339, obj.Metadata[trashExpiresKey])
+		}
+	}
+
+	result := make([]TrashItem, 0, len(items))
+	for _, item := range items {
+		result = append(result, *item)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Id > result[j].Id })
+	return result, nil
+}
+
+// Restore moves a trashed item back to where it was deleted from. Files
+// whose original path has since been taken stay in the trash.
+func (f *File) Restore(id string) ([]ObjectResult, error) {
+	item, err := f.TrashedItem(id)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := f.move(trashFolder(id)+item.Path, item.Path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	unstamp := map[string]string{trashPathKey: "", trashDeletedAtKey: "", trashDeletedByKey: "", trashExpiresKey: ""}
+	for _, r := range results {
+		if r.Err != nil {
+			continue
+		}
+		if _, err := f.Store.UpdateMetadata(f.Context, r.Destination, unstamp); err != nil {
+			log.Printf("Failed to clear trash metadata on %s: %v", r.Destination, err)
+		}
+	}
+	return results, nil
+}
+
+// Purge deletes a trashed item and its shares for good.
+func (f *File) Purge(id string) ([]ObjectResult, error) {
+	if _, err := f.TrashedItem(id); err != nil {
+		return nil, err
+	}
+	return f.DeletePermanently(trashFolder(id))
+}
+
+// PurgeExpired purges every item whose retention ended before now and
+// returns the items purged.
+func (f *File) PurgeExpired(now time.Time) ([]TrashItem, error) {
+	items, err := f.ListTrash()
+	if err != nil {
+		return nil, err
+	}
+
+	var purged []TrashItem
+	for _, item := range items {
+		// Items that lost their stamp have no expiry; go by the id's date.
+		expires := item.ExpiresAt
+		if expires.IsZero() {
+			deletedAt, _ := time.Parse("20060102T150405Z", strings.Split(item.Id, "-")[0])
+			expires = deletedAt.Add(DefaultTrashRetention)
+		}
+		if expires.After(now) {
+			continue
+		}
+
+		results, err := f.Purge(item.Id)
+		if err != nil {
+			return purged, err
+		}
+		if Failed(results) > 0 {
+			log.Printf("Failed to purge all of trash item %s", item.Id)
+			continue
+		}
+		purged = append(purged, item)
+	}
+	return purged, nil
+}
+
+// TrashedPath returns the path a trashed object was deleted from.
+func TrashedPath(name string) string {
+	if _, rest, ok := strings.Cut(strings.TrimPrefix(name, TrashPrefix), "/"); ok && strings.HasPrefix(name, TrashPrefix) {
+		return rest
+	}
+	return name
+}
+
+// TrashedItem returns the trash item with the given id.
+func (f *File) TrashedItem(id string) (*TrashItem, error) {
+	if id == "" || strings.Contains(id, "/") {
+		return nil, apperror.NotFound("Trash item %s not found", id)
+	}
+
+	list, err := f.Store.List(f.Context, BlobQuery{Prefix: trashFolder(id)})
+	if err != nil {
+		return nil, storageError(err, "Failed to read trash")
+	}
+	if len(list) == 0 {
+		return nil, apperror.NotFound("Trash item %s not found", id)
+	}
+
+	item := &TrashItem{Id: id, Path: strings.TrimPrefix(list[0].Name, trashFolder(id))}
+	for _, obj := range list {
+		if path := obj.Metadata[trashPathKey]; path != "" {
+			item.Path = path
+			return item, nil
+		}
+	}
+	// None are stamped, as ListTrash allows for.
+	for _, obj := range list[1:] {
+		item.Path = commonFolder(item.Path, strings.TrimPrefix(obj.Name, trashFolder(id)))
+	}
+	return item, nil
+}
+
+// commonFolder returns the deepest folder holding both a and b.
+func commonFolder(a, b string) string {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return a[:strings.LastIndex(a[:n], "/")+1]
+}
@@ -0,0 +1,75 @@
+package model_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/model"
+)
+
+// TestRestoreUnstamped restores items whose objects lost the metadata that
+// records where they were deleted from.
+func TestRestoreUnstamped(t *testing.T) {
+	tests := map[string][]string{
+		"file":                      {"docs/a.txt"},
+		"folder":                    {"docs/a.txt", "docs/b.txt"},
+		"folder with subfolders":    {"docs/2024/a.txt", "docs/2024/b.txt", "docs/2025/c.txt"},
+		"folder with one subfolder": {"docs/2024/a.txt", "docs/2024/b.txt"},
+	}
+	for name, files := range tests {
+		t.Run(name, func(t *testing.T) {
+			f := newTestFile(t)
+			for _, file := range files {
+				write(t, f, file, file)
+			}
+			write(t, f, "other/x.txt", "x")
+
+			target := files[0]
+			if len(files) > 1 {
+				target = "docs/"
+			}
+			item, _, err := f.Trash(target, "staff@proluxe.test", time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			trashed, err := f.Store.List(f.Context, model.BlobQuery{Prefix: model.TrashPrefix})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, obj := range trashed {
+				if _, err := f.Store.UpdateMetadata(f.Context, obj.Name, map[string]string{"trash-path": ""}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			listed, err := f.ListTrash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := f.TrashedItem(item.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(listed) != 1 || listed[0].Path != got.Path {
+				t.Errorf("ListTrash = %+v, want one item at %s", listed, got.Path)
+			}
+
+			results, err := f.Restore(item.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if model.Failed(results) > 0 {
+				t.Fatalf("Restore results: %+v", results)
+			}
+			for _, file := range files {
+				if content := read(t, f, file); content != file {
+					t.Errorf("%s = %q after restoring, want it back", file, content)
+				}
+			}
+			if content := read(t, f, "other/x.txt"); content != "x" {
+				t.Errorf("other/x.txt = %q, want it untouched", content)
+			}
+		})
+	}
+}
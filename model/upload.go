@@ -0,0 +1,448 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+)
+
+// UploadPolicy limits what can be uploaded.
+type UploadPolicy struct {
+	// MaxBytes caps the size of one file. Zero means no limit.
+	MaxBytes int64
+	// AllowedTypes lists the MIME types accepted, judged by sniffing the
+	// content rather than trusting the client. Entries may end in "/*". An
+	// empty list accepts anything.
+	AllowedTypes []string
+	// Scanner checks uploads for malware. Without one, files are stored
+	// as unscanned.
+	Scanner Scanner
+}
+
+// Allows reports whether content of the given sniffed type may be uploaded.
+func (p UploadPolicy) Allows(contentType string) bool {
+	if len(p.AllowedTypes) == 0 {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, allowed := range p.AllowedTypes {
+		if allowed == mediaType || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checksum is a client-declared digest, written "md5:<base64>" or
+// "crc32c:<base64>" as in Cloud Storage's x-goog-hash header.
+type Checksum struct {
+	Algorithm string
+	Sum       []byte
+}
+
+func ParseChecksum(s string) (*Checksum, error) {
+	if s == "" {
+		return nil, nil
+	}
+	algorithm, value, _ := strings.Cut(s, ":")
+	algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	switch {
+	case err != nil:
+		return nil, apperror.Validation("Checksum %q is not base64", s)
+	case algorithm == "md5" && len(sum) == md5.Size, algorithm == "crc32c" && len(sum) == 4:
+		return &Checksum{Algorithm: algorithm, Sum: sum}, nil
+	}
+	return nil, apperror.Validation("Checksum must be md5:<base64> or crc32c:<base64>")
+}
+
+func (c *Checksum) String() string {
+	return c.Algorithm + ":" + base64.StdEncoding.EncodeToString(c.Sum)
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Upload stores the contents of r at objectName under the policy, recording
+// uploadedBy in its metadata. The content type is sniffed and the size
+// capped, and the store verifies the content against want, if given, before
+// replacing anything. The upload is held in quarantine until the policy's
+// scanner has passed it; infected files are turned away and their uploader
+// told. The content's SHA-256 is recorded for finding copies.
+func (f *File) Upload(objectName string, r io.Reader, policy UploadPolicy, want *Checksum, uploadedBy string) (*BlobAttrs, error) {
+	if IsHidden(objectName) {
+		return nil, apperror.Validation("Path is reserved")
+	}
+
+	br := bufio.NewReaderSize(r, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return nil, apperror.Validation("Failed to read upload")
+	}
+
+	sniffed := http.DetectContentType(head)
+	if !policy.Allows(sniffed) {
+		return nil, apperror.Validation("Files of type %s are not allowed", sniffed)
+	}
+
+	contentType := sniffed
+	if generic := strings.HasPrefix(sniffed, "text/plain") || sniffed == "application/octet-stream"; generic {
+		if byExt := mime.TypeByExtension(path.Ext(objectName)); byExt != "" {
+			contentType = byExt
+		}
+	}
+
+	opts := BlobWriteOptions{ContentType: contentType}
+	if uploadedBy != "" {
+		opts.Metadata = map[string]string{uploadedByKey: uploadedBy}
+	}
+	if want != nil && want.Algorithm == "md5" {
+		opts.MD5 = want.Sum
+	}
+	if want != nil && want.Algorithm == "crc32c" {
+		sum := binary.BigEndian.Uint32(want.Sum)
+		opts.CRC32C = &sum
+	}
+
+	md5sum, crc, sha := md5.New(), crc32.New(crc32cTable), sha256.New()
+	body := io.TeeReader(br, io.MultiWriter(md5sum, crc, sha))
+	if policy.MaxBytes > 0 {
+		body = &limitedReader{r: body, remaining: policy.MaxBytes}
+	}
+
+	quarantined, err := quarantineName(objectName)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := f.Store.Write(f.Context, quarantined, body, opts)
+
+	got := &Checksum{Algorithm: "md5", Sum: md5sum.Sum(nil)}
+	if want != nil && want.Algorithm == "crc32c" {
+		got = &Checksum{Algorithm: "crc32c", Sum: binary.BigEndian.AppendUint32(nil, crc.Sum32())}
+	}
+
+	switch {
+	case errors.Is(err, errTooLarge):
+		return nil, apperror.Validation("Files may be at most %d bytes", policy.MaxBytes)
+	case err != nil && want != nil && !bytes.Equal(want.Sum, got.Sum):
+		// The store turned the write down; say why in the caller's terms.
+		return nil, apperror.Validation("Checksum mismatch: expected %s, received %s", want, got)
+	case err != nil:
+		return nil, storageError(err, "Failed to upload file")
+	}
+	defer func() {
+		if err := f.Store.Delete(f.Context, quarantined); err != nil && !apperror.Is(err, apperror.CodeNotFound) {
+			log.Printf("Failed to clear quarantined upload %s: %v", quarantined, err)
+		}
+	}()
+
+	// Guard against the bytes changing between us and the store.
+	if len(attrs.MD5) > 0 && !bytes.Equal(attrs.MD5, md5sum.Sum(nil)) {
+		return nil, apperror.Unavailable(nil, "Stored file does not match the upload")
+	}
+
+	metadata, result, err := f.scan(quarantined, policy.Scanner)
+	if err != nil {
+		return nil, err
+	}
+	if result.Infected {
+		f.notifyInfected(uploadedBy, objectName, result.Signature)
+		return nil, apperror.Validation("%s is infected with %s and was not stored", path.Base(objectName), result.Signature)
+	}
+
+	// The hash is only known once the bytes are in, so it goes on after.
+	hash := hex.EncodeToString(sha.Sum(nil))
+	metadata[contentHashKey] = hash
+	if _, err := f.Store.UpdateMetadata(f.Context, quarantined, metadata); err != nil {
+		return nil, storageError(err, "Failed to record scan of %s", objectName)
+	}
+
+	attrs, err = f.Store.Copy(f.Context, quarantined, objectName)
+	if err != nil {
+		return nil, storageError(err, "Failed to upload file")
+	}
+	f.indexHash(objectName, hash)
+
+	return attrs, nil
+}
+
+var errTooLarge = errors.New("upload exceeds the size limit")
+
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errTooLarge
+	}
+	return n, err
+}
+
+// Resumable uploads
+//
+// A session lives in the store itself, so any instance of the API can take
+// the next chunk: an info object under UploadsPrefix carries the session in
+// its metadata and every chunk is stored next to it, named by its offset.
+// Once the last byte arrives the chunks are stitched together through
+// Upload, which applies the same checks as a single-request upload.
+
+// UploadsPrefix holds resumable upload sessions. It is hidden from listings.
+const UploadsPrefix = ".uploads/"
+
+// UploadSessionTTL is how long a session can sit unfinished.
+const UploadSessionTTL = 24 * time.Hour
+
+type UploadSession struct {
+	Id        string    `json:"Id"`
+	Path      string    `json:"Path"`
+	Size      int64     `json:"Size"`
+	Offset    int64     `json:"Offset"`
+	Checksum  string    `json:"Checksum,omitempty"`
+	ExpiresAt time.Time `json:"ExpiresAt"`
+	// UploadedBy is recorded on the file once it is assembled.
+	UploadedBy string `json:"UploadedBy,omitempty"`
+	// Result is set once the last chunk has been stored.
+	Result *BlobAttrs `json:"Result,omitempty"`
+	// Duplicates lists, along with Result, the files already holding the
+	// same content.
+	Duplicates []string `json:"Duplicates,omitempty"`
+}
+
+func sessionPrefix(id string) string {
+	return UploadsPrefix + id + "/"
+}
+
+// StartUpload opens a session for a size-byte file to be stored at
+// objectName on behalf of uploadedBy.
+func (f *File) StartUpload(objectName string, size int64, checksum *Checksum, policy UploadPolicy, uploadedBy string) (*UploadSession, error) {
+	if objectName == "" || strings.HasSuffix(objectName, "/") {
+		return nil, apperror.Validation("Path must name a file")
+	}
+	if IsHidden(objectName) {
+		return nil, apperror.Validation("Path is reserved")
+	}
+	if size <= 0 {
+		return nil, apperror.Validation("Size must be positive")
+	}
+	if policy.MaxBytes > 0 && size > policy.MaxBytes {
+		return nil, apperror.Validation("Files may be at most %d bytes", policy.MaxBytes)
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	session := &UploadSession{
+		Id:         hex.EncodeToString(b),
+		Path:       objectName,
+		Size:       size,
+		ExpiresAt:  time.Now().Add(UploadSessionTTL).UTC(),
+		UploadedBy: uploadedBy,
+	}
+	metadata := map[string]string{
+		"path":        session.Path,
+		"size":        strconv.FormatInt(size, 10),
+		"expires":     session.ExpiresAt.Format(time.RFC3339),
+		uploadedByKey: uploadedBy,
+	}
+	if checksum != nil {
+		session.Checksum = checksum.String()
+		metadata["checksum"] = session.Checksum
+	}
+
+	_, err := f.Store.Write(f.Context, sessionPrefix(session.Id)+"info", strings.NewReader(""), BlobWriteOptions{Metadata: metadata})
+	if err != nil {
+		return nil, storageError(err, "Failed to start upload")
+	}
+	return session, nil
+}
+
+// UploadStatus returns the session with the offset the next chunk must start at.
+func (f *File) UploadStatus(id string) (*UploadSession, error) {
+	session, _, err := f.loadSession(id)
+	return session, err
+}
+
+// AppendUpload stores the chunk in r, which must start at offset. When it
+// completes the file, the file is assembled and checked and the session
+// removed; the returned session then carries the Result.
+func (f *File) AppendUpload(id string, offset int64, r io.Reader, policy UploadPolicy) (*UploadSession, error) {
+	session, chunks, err := f.loadSession(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != session.Offset {
+		return nil, apperror.Conflict("Upload is at offset %d, not %d", session.Offset, offset)
+	}
+
+	// Turn away disallowed content on the first chunk rather than the last.
+	if offset == 0 {
+		br := bufio.NewReaderSize(r, 512)
+		head, err := br.Peek(512)
+		if err != nil && err != io.EOF {
+			return nil, apperror.Validation("Failed to read chunk")
+		}
+		if sniffed := http.DetectContentType(head); !policy.Allows(sniffed) {
+			return nil, apperror.Validation("Files of type %s are not allowed", sniffed)
+		}
+		r = br
+	}
+
+	body := &limitedReader{r: r, remaining: session.Size - session.Offset}
+	chunkName := fmt.Sprintf("%schunk-%020d", sessionPrefix(id), offset)
+
+	attrs, err := f.Store.Write(f.Context, chunkName, body, BlobWriteOptions{ContentType: "application/octet-stream"})
+	if errors.Is(err, errTooLarge) {
+		f.Store.Delete(f.Context, chunkName)
+		return nil, apperror.Validation("Chunk runs past the declared size of %d bytes", session.Size)
+	}
+	if err != nil {
+		return nil, storageError(err, "Failed to store chunk")
+	}
+	if attrs.Size == 0 {
+		f.Store.Delete(f.Context, chunkName)
+		return session, nil
+	}
+
+	session.Offset += attrs.Size
+	chunks = append(chunks, chunkName)
+	if session.Offset < session.Size {
+		return session, nil
+	}
+
+	want, _ := ParseChecksum(session.Checksum)
+	result, err := f.Upload(session.Path, &chunkReader{f: f, names: chunks}, policy, want, session.UploadedBy)
+	f.removeSession(id)
+	if err != nil {
+		return nil, err
+	}
+	session.Result = result
+	return session, nil
+}
+
+// CancelUpload abandons the session and its chunks.
+func (f *File) CancelUpload(id string) error {
+	if _, _, err := f.loadSession(id); err != nil {
+		return err
+	}
+	f.removeSession(id)
+	return nil
+}
+
+// loadSession reads the session's info object and works out its offset from
+// the chunks stored so far. Expired sessions are removed and reported as
+// missing.
+func (f *File) loadSession(id string) (*UploadSession, []string, error) {
+	if id == "" || strings.ContainsAny(id, "/.") {
+		return nil, nil, apperror.NotFound("Upload %s not found", id)
+	}
+
+	info, err := f.Store.Attrs(f.Context, sessionPrefix(id)+"info")
+	if apperror.Is(err, apperror.CodeNotFound) {
+		return nil, nil, apperror.NotFound("Upload %s not found", id)
+	}
+	if err != nil {
+		return nil, nil, storageError(err, "Failed to read upload")
+	}
+
+	size, _ := strconv.ParseInt(info.Metadata["size"], 10, 64)
+	expires, _ := time.Parse(time.RFC3339, info.Metadata["expires"])
+	session := &UploadSession{
+		Id:         id,
+		Path:       info.Metadata["path"],
+		Size:       size,
+		Checksum:   info.Metadata["checksum"],
+		ExpiresAt:  expires,
+		UploadedBy: info.Metadata[uploadedByKey],
+	}
+	if time.Now().After(expires) {
+		f.removeSession(id)
+		return nil, nil, apperror.NotFound("Upload %s has expired", id)
+	}
+
+	list, err := f.Store.List(f.Context, BlobQuery{Prefix: sessionPrefix(id) + "chunk-"})
+	if err != nil {
+		return nil, nil, storageError(err, "Failed to read upload")
+	}
+
+	var chunks []string
+	for _, chunk := range list {
+		// A chunk that doesn't start where the last one ended was left by a
+		// request that failed halfway; drop it and everything after it.
+		start, _ := strconv.ParseInt(strings.TrimPrefix(chunk.Name, sessionPrefix(id)+"chunk-"), 10, 64)
+		if start != session.Offset {
+			break
+		}
+		chunks = append(chunks, chunk.Name)
+		session.Offset += chunk.Size
+	}
+	return session, chunks, nil
+}
+
+func (f *File) removeSession(id string) {
+	list, err := f.Store.List(f.Context, BlobQuery{Prefix: sessionPrefix(id)})
+	if err != nil {
+		log.Printf("Failed to list upload %s for cleanup: %v", id, err)
+		return
+	}
+	for _, obj := range list {
+		if err := f.Store.Delete(f.Context, obj.Name); err != nil {
+			log.Printf("Failed to remove %s: %v", obj.Name, err)
+		}
+	}
+}
+
+// chunkReader reads the named chunks back to back.
+type chunkReader struct {
+	f       *File
+	names   []string
+	current io.ReadCloser
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if len(c.names) == 0 {
+				return 0, io.EOF
+			}
+			rc, _, err := c.f.Store.NewRangeReader(c.f.Context, c.names[0], 0, -1)
+			if err != nil {
+				return 0, err
+			}
+			c.current, c.names = rc, c.names[1:]
+		}
+
+		n, err := c.current.Read(p)
+		if err == io.EOF {
+			c.current.Close()
+			c.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
@@ -1,9 +1,8 @@
 package model
 
 import (
-	"fmt"
-	"log"
-
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/salesforce"
 	"github.com/scottraio/simpleforce"
 )
 
@@ -15,6 +14,9 @@ type User struct {
 	IssueNotifications         bool   `json:"IssueNotifications"`
 	NewLeadNotification        bool   `json:"NewLeadNotification"`
 	NewOpportunityNotification bool   `json:"NewOpportunityNotification"`
+	// Role is the user's API role (admin, staff or external). Blank means
+	// the default for org users, staff.
+	Role string `json:"Role"`
 }
 
 type MentionableUser struct {
@@ -22,16 +24,13 @@ type MentionableUser struct {
 	Display string `json:"display"`
 }
 
-func FetchUsers(client *simpleforce.Client, whereCondition string) []User {
-	q := fmt.Sprintf(`
-		SELECT Id, Name, rstk__syusr_empl_email__c, rstk__syusr_phone__c, Issue_Notifications__c, New_Lead_Notification__c, New_Opportunities_Notification__c
-		FROM rstk__syusr__c
-		WHERE %s
-	`, whereCondition)
+func FetchUsers(client *simpleforce.Client, q salesforce.Query) ([]User, error) {
+	q = q.Select("Id", "Name", "rstk__syusr_empl_email__c", "rstk__syusr_phone__c", "Issue_Notifications__c", "New_Lead_Notification__c", "New_Opportunities_Notification__c", "CXP_Role__c").
+		From("rstk__syusr__c")
 
-	result, err := client.Query(q)
+	result, err := q.Run(client)
 	if err != nil {
-		log.Fatal("Error: ", err)
+		return nil, err
 	}
 
 	var users []User
@@ -44,26 +43,35 @@ func FetchUsers(client *simpleforce.Client, whereCondition string) []User {
 			IssueNotifications:         getBoolField("Issue_Notifications__c", record),
 			NewLeadNotification:        getBoolField("New_Lead_Notification__c", record),
 			NewOpportunityNotification: getBoolField("New_Opportunities_Notification__c", record),
+			Role:                       getStringField("CXP_Role__c", record),
 		}
 
 		users = append(users, u)
 	}
 
-	return users
+	return users, nil
 }
 
-func (u *User) Update(client *simpleforce.Client) *simpleforce.SObject {
-	return client.SObject("rstk__syusr__c").
+func (u *User) Update(client *simpleforce.Client) error {
+	sobj := client.SObject("rstk__syusr__c").
 		Set("Id", u.Id).
 		Set("rstk__syusr_phone__c", u.Phone).
 		Set("Issue_Notifications__c", u.IssueNotifications).
 		Set("New_Lead_Notification__c", u.NewLeadNotification).
 		Set("New_Opportunities_Notification__c", u.NewOpportunityNotification).
 		Update()
+
+	if sobj == nil {
+		return apperror.Unavailable(nil, "Failed to update user %s", u.Id)
+	}
+	return nil
 }
 
-func MentionableUsers(client *simpleforce.Client) []MentionableUser {
-	users := FetchUsers(client, "rstk__syusr_obsolete__c = FALSE AND Id != 'a9W3u000000PBWpEAO'")
+func MentionableUsers(client *simpleforce.Client) ([]MentionableUser, error) {
+	users, err := FetchUsers(client, salesforce.Where("rstk__syusr_obsolete__c = ? AND Id != ?", false, "a9W3u000000PBWpEAO"))
+	if err != nil {
+		return nil, err
+	}
 
 	var mentionableUsers []MentionableUser
 	for _, user := range users {
@@ -72,5 +80,5 @@ func MentionableUsers(client *simpleforce.Client) []MentionableUser {
 			Display: user.Name,
 		})
 	}
-	return mentionableUsers
+	return mentionableUsers, nil
 }
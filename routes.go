@@ -1,43 +1,102 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/auth"
 	"github.com/Proluxe/proluxe-common-api/salesforce"
 	"github.com/Proluxe/proluxe-common-api/util"
 	"github.com/gin-gonic/gin"
 )
 
+// Route is one entry in the route table: the handler and the auth policy it
+// is served under.
+type Route struct {
+	Method  string
+	Path    string
+	Auth    auth.Policy
+	Handler gin.HandlerFunc
+}
+
+// registerRoutes mounts each route behind the middleware for its policy. It
+// registers nothing if any route's policy is missing or can't be enforced.
+func registerRoutes(router *gin.Engine, routes []Route, cfg auth.Config) error {
+	middleware := make([]gin.HandlerFunc, len(routes))
+	for i, route := range routes {
+		m, err := cfg.Middleware(route.Auth)
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", route.Method, route.Path, err)
+		}
+		middleware[i] = m
+	}
+
+	for i, route := range routes {
+		router.Handle(route.Method, route.Path, middleware[i], route.Handler)
+	}
+	return nil
+}
+
 // Global funcs
 
 func HandleRequest(handlerFunc func(*gin.Context, *util.App) interface{}, App *util.App) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		checkClient(App.SF, c) // Check client credentials or other preprocessing
-		App.SF.QueryCount = 0  // Resetting the count for each request
+		if !checkClient(App.SF, c) { // Check client credentials or other preprocessing
+			return
+		}
+		scoped, done := App.ForRequest(c)
+		defer done()
 
 		// Call the specific handler for this route
-		result := handlerFunc(c, App)
+		result := handlerFunc(c, scoped)
 		c.JSON(http.StatusOK, result)
 	}
 }
 
 func apiRoute(handlerFunc func(*gin.Context, *util.App), App *util.App) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		checkClient(App.SF, c) // Check client credentials or other preprocessing
-		handlerFunc(c, App)
+		if !checkClient(App.SF, c) { // Check client credentials or other preprocessing
+			return
+		}
+
+		scoped, done := App.ForRequest(c)
+		defer done()
+
+		handlerFunc(c, scoped)
+	}
+}
+
+// storeRoute serves handlers that work mostly on the blob store. It skips
+// the up-front Salesforce login, so they keep working while Salesforce is
+// unreachable as far as they can, but the Salesforce calls they do make,
+// such as looking up folder grants, are counted against the request.
+func storeRoute(handlerFunc func(*gin.Context, *util.App), App *util.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scoped, done := App.ForRequest(c)
+		defer done()
+
+		handlerFunc(c, scoped)
 	}
 }
 
 func clientErrorResponse(c *gin.Context) {
-	c.JSON(http.StatusInternalServerError, gin.H{"error": "client not initialized"})
+	apperror.Respond(c, apperror.Unavailable(nil, "Salesforce client not initialized"))
 }
 
-func checkClient(SF *salesforce.SF, c *gin.Context) {
-	if SF.Client == nil {
+// checkClient makes sure there is a live Salesforce session, logging in if
+// this is the first request or the last session has aged out.
+func checkClient(SF *salesforce.SF, c *gin.Context) bool {
+	if SF == nil {
 		clientErrorResponse(c)
-		return
+		return false
+	}
+	if _, err := SF.Session(); err != nil {
+		apperror.Respond(c, err)
+		return false
 	}
+	return true
 }
 
 func parseDate(endDate string, c *gin.Context) {
@@ -0,0 +1,133 @@
+package salesforce
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	u "github.com/scottraio/go-utils"
+	simpleforce "github.com/scottraio/simpleforce"
+)
+
+// Authenticator opens a new session on client.
+type Authenticator interface {
+	Login(client *simpleforce.Client) error
+}
+
+// PasswordAuth logs in with a username, password and security token.
+type PasswordAuth struct {
+	User     string
+	Password string
+	Token    string
+}
+
+func (a *PasswordAuth) Login(client *simpleforce.Client) error {
+	return client.LoginPassword(a.User, a.Password, a.Token)
+}
+
+// JWTBearerAuth logs in with the OAuth 2.0 JWT bearer flow, using a connected
+// app's consumer key and the private key of the certificate uploaded to it.
+type JWTBearerAuth struct {
+	LoginURL   string
+	ClientID   string
+	User       string
+	Key        *rsa.PrivateKey
+	HTTPClient *http.Client
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	InstanceURL      string `json:"instance_url"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (a *JWTBearerAuth) Login(client *simpleforce.Client) error {
+	loginURL := strings.TrimRight(a.LoginURL, "/")
+
+	// Salesforce wants aud as a plain string, not the array RegisteredClaims
+	// would produce.
+	claims := jwt.MapClaims{
+		"iss": a.ClientID,
+		"sub": a.User,
+		"aud": loginURL,
+		"exp": time.Now().Add(3 * time.Minute).Unix(),
+	}
+
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(a.Key)
+	if err != nil {
+		return fmt.Errorf("signing JWT assertion: %w", err)
+	}
+
+	httpClient := a.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	resp, err := httpClient.PostForm(loginURL+"/services/oauth2/token", url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return fmt.Errorf("requesting access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("decoding token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return fmt.Errorf("JWT bearer login failed: %s %s", token.Error, token.ErrorDescription)
+	}
+
+	client.SetSidLoc(token.AccessToken, token.InstanceURL)
+	return nil
+}
+
+// authFromEnv picks the login flow from SF_AUTH ("password", the default, or
+// "jwt") and reads its settings from the environment.
+func authFromEnv() (Authenticator, error) {
+	switch strings.ToLower(u.GetDotEnvVariable("SF_AUTH")) {
+	case "", "password":
+		return &PasswordAuth{
+			User:     u.GetDotEnvVariable("SF_USER"),
+			Password: u.GetDotEnvVariable("SF_PASSWORD"),
+			Token:    u.GetDotEnvVariable("SF_TOKEN"),
+		}, nil
+	case "jwt":
+		pem := []byte(u.GetDotEnvVariable("SF_JWT_KEY"))
+		if keyFile := u.GetDotEnvVariable("SF_JWT_KEY_FILE"); keyFile != "" {
+			var err error
+			if pem, err = os.ReadFile(keyFile); err != nil {
+				return nil, fmt.Errorf("reading SF_JWT_KEY_FILE: %w", err)
+			}
+		}
+
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("parsing Salesforce JWT key: %w", err)
+		}
+
+		loginURL := u.GetDotEnvVariable("SF_LOGIN_URL")
+		if loginURL == "" {
+			loginURL = "https://login.salesforce.com"
+		}
+
+		return &JWTBearerAuth{
+			LoginURL: loginURL,
+			ClientID: u.GetDotEnvVariable("SF_CLIENT_ID"),
+			User:     u.GetDotEnvVariable("SF_USER"),
+			Key:      key,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown SF_AUTH %q", u.GetDotEnvVariable("SF_AUTH"))
+	}
+}
@@ -0,0 +1,150 @@
+package salesforce
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	simpleforce "github.com/scottraio/simpleforce"
+)
+
+// CollectionLimit is the most records one collection call can carry.
+const CollectionLimit = 200
+
+// Record is one record of a collection update: its Id and the fields to set.
+type Record struct {
+	Id     string
+	Fields map[string]interface{}
+}
+
+// RecordError reports a record Salesforce refused to save.
+type RecordError struct {
+	Id      string
+	Code    string
+	Message string
+}
+
+// CollectionError is returned when a collection update saved nothing because
+// some of its records were refused.
+type CollectionError struct {
+	Records []RecordError
+}
+
+func (e *CollectionError) Error() string {
+	if len(e.Records) == 0 {
+		return "collection update failed"
+	}
+	parts := make([]string, len(e.Records))
+	for n, r := range e.Records {
+		parts[n] = fmt.Sprintf("%s: %s %s", r.Id, r.Code, r.Message)
+	}
+	return "collection update failed: " + strings.Join(parts, "; ")
+}
+
+// UpdateCollection updates up to CollectionLimit records of objectType in a
+// single all-or-none call: either every record is saved or none is.
+func (sf *SF) UpdateCollection(objectType string, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if len(records) > CollectionLimit {
+		return fmt.Errorf("collection update of %d records is over the limit of %d", len(records), CollectionLimit)
+	}
+
+	type attributes struct {
+		Type string `json:"type"`
+	}
+	payload := struct {
+		AllOrNone bool                     `json:"allOrNone"`
+		Records   []map[string]interface{} `json:"records"`
+	}{AllOrNone: true}
+	for _, r := range records {
+		record := map[string]interface{}{"attributes": attributes{Type: objectType}, "id": r.Id}
+		for k, v := range r.Fields {
+			record[k] = v
+		}
+		payload.Records = append(payload.Records, record)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return sf.Write(func(client *simpleforce.Client) error {
+		url := strings.TrimRight(client.GetLoc(), "/") + "/services/data/v" + simpleforce.DefaultAPIVersion + "/composite/sobjects"
+		req, err := http.NewRequest(http.MethodPatch, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+client.GetSid())
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := sf.httpClient().Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		raw, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			// Carries the error code, so a dead session is recognized.
+			return fmt.Errorf("collection update: %s: %s", resp.Status, raw)
+		}
+
+		var results []struct {
+			Id      string `json:"id"`
+			Success bool   `json:"success"`
+			Errors  []struct {
+				StatusCode string `json:"statusCode"`
+				Message    string `json:"message"`
+			} `json:"errors"`
+		}
+		if err := json.Unmarshal(raw, &results); err != nil {
+			return fmt.Errorf("decoding collection update response: %w", err)
+		}
+
+		failed, refused := &CollectionError{}, 0
+		for n, r := range results {
+			if r.Success {
+				continue
+			}
+			refused++
+			// Refused records come back without their Id.
+			e := RecordError{Id: r.Id}
+			if e.Id == "" && n < len(records) {
+				e.Id = records[n].Id
+			}
+			for _, detail := range r.Errors {
+				// allOrNone rolls back the records that were fine with this
+				// code; they aren't the cause.
+				if detail.StatusCode == "ALL_OR_NONE_OPERATION_ROLLED_BACK" {
+					continue
+				}
+				e.Code, e.Message = detail.StatusCode, detail.Message
+			}
+			if e.Code != "" {
+				failed.Records = append(failed.Records, e)
+			}
+		}
+		if refused > 0 {
+			return failed
+		}
+		return nil
+	})
+}
+
+// httpClient returns a client for calls simpleforce can't make, metered into
+// the request's usage like the simpleforce client is.
+func (sf *SF) httpClient() *http.Client {
+	if sf.usage == nil {
+		return &http.Client{Timeout: 2 * time.Minute}
+	}
+	return &http.Client{Timeout: 2 * time.Minute, Transport: &meteredTransport{usage: sf.usage, next: http.DefaultTransport}}
+}
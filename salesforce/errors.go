@@ -0,0 +1,36 @@
+package salesforce
+
+import (
+	"strings"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+)
+
+// WrapError maps a Salesforce API error onto an apperror so handlers can
+// report it with the right status. Errors that are already typed pass through.
+func WrapError(err error, message string) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*apperror.Error); ok {
+		return err
+	}
+
+	text := err.Error()
+	switch {
+	case strings.Contains(text, "ENTITY_IS_DELETED"),
+		strings.Contains(text, "INVALID_CROSS_REFERENCE_KEY"),
+		strings.Contains(text, "NOT_FOUND"):
+		return apperror.NotFound("%s", message).Wrap(err)
+	case strings.Contains(text, "DUPLICATE_VALUE"):
+		return apperror.Conflict("%s", message).Wrap(err)
+	case strings.Contains(text, "INVALID_TYPE"),
+		strings.Contains(text, "INVALID_FIELD"),
+		strings.Contains(text, "MALFORMED_ID"),
+		strings.Contains(text, "FIELD_CUSTOM_VALIDATION_EXCEPTION"),
+		strings.Contains(text, "REQUIRED_FIELD_MISSING"):
+		return apperror.Validation("%s", message).Wrap(err)
+	default:
+		return apperror.Unavailable(err, "%s", message)
+	}
+}
@@ -0,0 +1,261 @@
+package salesforce
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	simpleforce "github.com/scottraio/simpleforce"
+)
+
+var (
+	identifierPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z][A-Za-z0-9_]*)*$`)
+	orderByPattern    = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.]*( (?i:ASC|DESC))?( (?i:NULLS (FIRST|LAST)))?$`)
+)
+
+// Query is a SOQL statement built from typed parts. Values passed to Where are
+// bound to "?" placeholders and rendered as escaped SOQL literals, so callers
+// never concatenate user input into the statement.
+//
+// Query is a value type: every builder method returns a copy, so a partial
+// query (e.g. a WHERE clause) can be shared by several fetchers.
+type Query struct {
+	fields  []string
+	object  string
+	where   []string
+	orderBy []string
+	limit   int
+	err     error
+}
+
+// Select starts a query with the given fields.
+func Select(fields ...string) Query {
+	return Query{}.Select(fields...)
+}
+
+// Where starts a query with a single condition and no SELECT/FROM, for
+// fetchers that fill those in themselves.
+func Where(clause string, args ...interface{}) Query {
+	return Query{}.Where(clause, args...)
+}
+
+// Select replaces the selected fields.
+func (q Query) Select(fields ...string) Query {
+	for _, f := range fields {
+		if !identifierPattern.MatchString(f) {
+			return q.fail(fmt.Errorf("invalid field name %q", f))
+		}
+	}
+	q.fields = append([]string(nil), fields...)
+	return q
+}
+
+// From sets the object being queried.
+func (q Query) From(object string) Query {
+	if !identifierPattern.MatchString(object) {
+		return q.fail(fmt.Errorf("invalid object name %q", object))
+	}
+	q.object = object
+	return q
+}
+
+// Where adds a condition, ANDed with any existing ones. Each "?" in clause is
+// replaced by the matching argument rendered with Literal.
+func (q Query) Where(clause string, args ...interface{}) Query {
+	bound, err := bind(clause, args)
+	if err != nil {
+		return q.fail(err)
+	}
+	q.where = append(append([]string(nil), q.where...), bound)
+	return q
+}
+
+// OrderBy appends ORDER BY terms such as "CreatedDate DESC".
+func (q Query) OrderBy(terms ...string) Query {
+	for _, t := range terms {
+		if !orderByPattern.MatchString(t) {
+			return q.fail(fmt.Errorf("invalid order by term %q", t))
+		}
+	}
+	q.orderBy = append(append([]string(nil), q.orderBy...), terms...)
+	return q
+}
+
+// Limit caps the number of rows returned.
+func (q Query) Limit(n int) Query {
+	if n < 0 {
+		return q.fail(fmt.Errorf("invalid limit %d", n))
+	}
+	q.limit = n
+	return q
+}
+
+// Build renders the SOQL statement.
+func (q Query) Build() (string, error) {
+	if q.err != nil {
+		return "", q.err
+	}
+	if len(q.fields) == 0 {
+		return "", fmt.Errorf("query has no fields")
+	}
+	if q.object == "" {
+		return "", fmt.Errorf("query has no object")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("SELECT ")
+	sb.WriteString(strings.Join(q.fields, ", "))
+	sb.WriteString(" FROM ")
+	sb.WriteString(q.object)
+
+	if len(q.where) > 0 {
+		sb.WriteString(" WHERE ")
+		if len(q.where) == 1 {
+			sb.WriteString(q.where[0])
+		} else {
+			sb.WriteString("(" + strings.Join(q.where, ") AND (") + ")")
+		}
+	}
+
+	if len(q.orderBy) > 0 {
+		sb.WriteString(" ORDER BY ")
+		sb.WriteString(strings.Join(q.orderBy, ", "))
+	}
+
+	if q.limit > 0 {
+		sb.WriteString(" LIMIT ")
+		sb.WriteString(strconv.Itoa(q.limit))
+	}
+
+	return sb.String(), nil
+}
+
+// Run builds the query and executes it with client. A query that can't be
+// built is a validation error; a failed call is classified by WrapError.
+func (q Query) Run(client *simpleforce.Client) (*simpleforce.QueryResult, error) {
+	soql, err := q.Build()
+	if err != nil {
+		return nil, apperror.Validation("Invalid query: %v", err)
+	}
+
+	result, err := client.Query(soql)
+	if err != nil {
+		return nil, WrapError(err, "Salesforce query failed")
+	}
+
+	return result, nil
+}
+
+func (q Query) fail(err error) Query {
+	if q.err == nil {
+		q.err = err
+	}
+	return q
+}
+
+func bind(clause string, args []interface{}) (string, error) {
+	var sb strings.Builder
+	n := 0
+
+	for _, r := range clause {
+		if r != '?' {
+			sb.WriteRune(r)
+			continue
+		}
+		if n >= len(args) {
+			return "", fmt.Errorf("not enough arguments for %q", clause)
+		}
+		lit, err := Literal(args[n])
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(lit)
+		n++
+	}
+
+	if n != len(args) {
+		return "", fmt.Errorf("too many arguments for %q", clause)
+	}
+
+	return sb.String(), nil
+}
+
+// Like is a LIKE pattern built by Contains or StartsWith. The user-supplied
+// part is matched literally; % and _ in it do not act as wildcards.
+type Like struct {
+	pattern string
+}
+
+// Contains returns a LIKE pattern matching s anywhere in the field.
+func Contains(s string) Like {
+	return Like{pattern: "%" + escapeLike(s) + "%"}
+}
+
+// StartsWith returns a LIKE pattern matching fields that begin with s.
+func StartsWith(s string) Like {
+	return Like{pattern: escapeLike(s) + "%"}
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`%`, `\%`, `_`, `\_`).Replace(escape(s))
+}
+
+// Literal renders v as a SOQL literal. Strings are quoted and escaped, slices
+// become parenthesised lists for IN / NOT IN.
+func Literal(v interface{}) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return "null", nil
+	case string:
+		return quote(val), nil
+	case Like:
+		return "'" + val.pattern + "'", nil
+	case bool:
+		if val {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case int:
+		return strconv.Itoa(val), nil
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	case time.Time:
+		return val.UTC().Format("2006-01-02T15:04:05Z"), nil
+	case civil.Date:
+		return val.String(), nil
+	case []string:
+		if len(val) == 0 {
+			return "", fmt.Errorf("empty list literal")
+		}
+		quoted := make([]string, len(val))
+		for i, s := range val {
+			quoted[i] = quote(s)
+		}
+		return "(" + strings.Join(quoted, ", ") + ")", nil
+	default:
+		return "", fmt.Errorf("unsupported SOQL literal type %T", v)
+	}
+}
+
+func quote(s string) string {
+	return "'" + escape(s) + "'"
+}
+
+func escape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		`'`, `\'`,
+		`"`, `\"`,
+		"\n", `\n`,
+		"\r", `\r`,
+		"\t", `\t`,
+		"\b", `\b`,
+		"\f", `\f`,
+	).Replace(s)
+}
@@ -0,0 +1,134 @@
+package salesforce
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+)
+
+func TestLiteral(t *testing.T) {
+	tests := []struct {
+		name string
+		in   interface{}
+		want string
+	}{
+		{"plain string", "Acme", `'Acme'`},
+		{"single quote", "O'Brien", `'O\'Brien'`},
+		{"quote breaking out", "x' OR Name != '", `'x\' OR Name != \''`},
+		{"double quote", `say "hi"`, `'say \"hi\"'`},
+		{"backslash", `C:\files`, `'C:\\files'`},
+		{"backslash before quote", `\'`, `'\\\''`},
+		{"newline", "one\ntwo", `'one\ntwo'`},
+		{"carriage return and tab", "a\r\tb", `'a\r\tb'`},
+		{"backspace and form feed", "a\b\fb", `'a\b\fb'`},
+		{"percent and underscore outside LIKE", "50%_off", `'50%_off'`},
+		{"nil", nil, "null"},
+		{"true", true, "TRUE"},
+		{"false", false, "FALSE"},
+		{"int", 42, "42"},
+		{"int64", int64(-7), "-7"},
+		{"float", 2.5, "2.5"},
+		{"time in UTC", time.Date(2024, 3, 1, 8, 30, 0, 0, time.FixedZone("PST", -8*3600)), "2024-03-01T16:30:00Z"},
+		{"date", civil.Date{Year: 2024, Month: time.March, Day: 1}, "2024-03-01"},
+		{"list", []string{"a", "b'c"}, `('a', 'b\'c')`},
+		{"contains", Contains("50%_off"), `'%50\%\_off%'`},
+		{"starts with", StartsWith("docs/"), `'docs/%'`},
+		{"LIKE with quote and backslash", Contains(`a'\`), `'%a\'\\%'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Literal(tt.in)
+			if err != nil {
+				t.Fatalf("Literal(%#v): %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("Literal(%#v) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLiteralRejects(t *testing.T) {
+	for _, v := range []interface{}{[]string{}, struct{}{}, []int{1}, int32(1)} {
+		if got, err := Literal(v); err == nil {
+			t.Errorf("Literal(%#v) = %s, want an error", v, got)
+		}
+	}
+}
+
+func TestWhere(t *testing.T) {
+	tests := []struct {
+		name    string
+		clause  string
+		args    []interface{}
+		want    string
+		wantErr string
+	}{
+		{"one placeholder", "Name = ?", []interface{}{"O'Brien"}, `SELECT Id FROM Account WHERE Name = 'O\'Brien'`, ""},
+		{"several placeholders", "Name = ? AND Active__c = ?", []interface{}{"Acme", true}, `SELECT Id FROM Account WHERE Name = 'Acme' AND Active__c = TRUE`, ""},
+		{"IN list", "Id IN ?", []interface{}{[]string{"001", "002"}}, `SELECT Id FROM Account WHERE Id IN ('001', '002')`, ""},
+		{"LIKE", "Name LIKE ?", []interface{}{Contains("a_b")}, `SELECT Id FROM Account WHERE Name LIKE '%a\_b%'`, ""},
+		{"question mark in an argument", "Name = ?", []interface{}{"why?"}, `SELECT Id FROM Account WHERE Name = 'why?'`, ""},
+		{"no placeholders", "IsDeleted = FALSE", nil, `SELECT Id FROM Account WHERE IsDeleted = FALSE`, ""},
+		{"too few arguments", "Name = ? AND Type = ?", []interface{}{"Acme"}, "", "not enough arguments"},
+		{"too many arguments", "Name = ?", []interface{}{"Acme", "extra"}, "", "too many arguments"},
+		{"arguments without placeholders", "IsDeleted = FALSE", []interface{}{"x"}, "", "too many arguments"},
+		{"unsupported argument", "Name = ?", []interface{}{struct{}{}}, "", "unsupported SOQL literal type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Select("Id").From("Account").Where(tt.clause, tt.args...).Build()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Build() = %q, %v, want an error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Build(): %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Build() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	base := Where("AccountId = ?", "001")
+	got, err := base.Select("Id", "Owner.Name").From("Contact").Where("Email != ?", nil).OrderBy("CreatedDate DESC", "Name ASC NULLS LAST").Limit(10).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `SELECT Id, Owner.Name FROM Contact WHERE (AccountId = '001') AND (Email != null) ORDER BY CreatedDate DESC, Name ASC NULLS LAST LIMIT 10`
+	if got != want {
+		t.Errorf("Build() = %s, want %s", got, want)
+	}
+
+	// Builders return copies, so the shared clause is left as it was.
+	if other, _ := base.Select("Id").From("Case").Build(); other != `SELECT Id FROM Case WHERE AccountId = '001'` {
+		t.Errorf("shared clause changed: %s", other)
+	}
+}
+
+func TestBuildRejects(t *testing.T) {
+	tests := map[string]Query{
+		"field with a space":    Select("Id, (SELECT Id FROM Contacts)").From("Account"),
+		"object with a quote":   Select("Id").From("Account'"),
+		"order by injection":    Select("Id").From("Account").OrderBy("Name; DELETE"),
+		"negative limit":        Select("Id").From("Account").Limit(-1),
+		"no fields":             Query{}.From("Account"),
+		"no object":             Select("Id"),
+		"bad object then where": Select("Id").From("Bad Name").Where("Name = ?"),
+		"missing argument":      Select("Id").From("Account").Where("Name = ?"),
+	}
+	for name, q := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got, err := q.Build(); err == nil {
+				t.Errorf("Build() = %s, want an error", got)
+			}
+		})
+	}
+}
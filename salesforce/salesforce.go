@@ -1,56 +1,233 @@
 package salesforce
 
 import (
-	"fmt"
+	"errors"
 	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/Proluxe/proluxe-common-api/apperror"
 	u "github.com/scottraio/go-utils"
 	simpleforce "github.com/scottraio/simpleforce"
 )
 
+// SF owns the Salesforce session. It logs in lazily, and Read and Write log
+// in again when Salesforce reports the session as invalid, so the API
+// survives session timeouts without a restart.
 type SF struct {
-	Client     *simpleforce.Client
-	QueryCount int
+	// MaxSessionAge forces a fresh login once a session is this old. DML calls
+	// report failures without the Salesforce error code, so an expired session
+	// can't always be detected after the fact.
+	MaxSessionAge time.Duration
+	// MaxRetries is how many times Read retries a transient failure.
+	MaxRetries int
+	// Backoff is the delay before the first retry; it doubles each attempt.
+	Backoff time.Duration
+	// RequestCallLimit caps the API calls made for a single request. Zero
+	// means no limit.
+	RequestCallLimit int
+	// CallTimeout bounds each API call, so a stalled org can't hold a
+	// request open. Zero means no timeout.
+	CallTimeout time.Duration
+
+	// session is shared with the request-scoped copies made by ForRequest.
+	*session
+	usage *Usage
+}
+
+type session struct {
+	auth        Authenticator
+	instanceURL string
+
+	mu sync.Mutex
+	// client is replaced, never changed, on each login, since calls in
+	// flight may still be using the old one.
+	client     *simpleforce.Client
+	loggedIn   bool
+	loggedInAt time.Time
+	generation int
+	loginErr   error
 }
 
 func NewSF() *SF {
-	client, err := CreateClient()
+	auth, err := authFromEnv()
 	if err != nil {
-		log.Println("Error creating Salesforce client: ", err)
-		return nil
+		log.Println("Error configuring Salesforce login: ", err)
+	}
+
+	sf := New(u.GetDotEnvVariable("SF_URL"), auth)
+	if err != nil {
+		sf.loginErr = err
 	}
 
-	return &SF{Client: client}
+	if age, err := time.ParseDuration(u.GetDotEnvVariable("SF_SESSION_MAX_AGE")); err == nil {
+		sf.MaxSessionAge = age
+	}
+	if retries, err := strconv.Atoi(u.GetDotEnvVariable("SF_MAX_RETRIES")); err == nil {
+		sf.MaxRetries = retries
+	}
+	if limit, err := strconv.Atoi(u.GetDotEnvVariable("SF_REQUEST_CALL_LIMIT")); err == nil {
+		sf.RequestCallLimit = limit
+	}
+	if timeout, err := time.ParseDuration(u.GetDotEnvVariable("SF_CALL_TIMEOUT")); err == nil {
+		sf.CallTimeout = timeout
+	}
+
+	return sf
+}
+
+// New returns an SF for the org at instanceURL. No request is made until the
+// session is first used.
+func New(instanceURL string, auth Authenticator) *SF {
+	return &SF{
+		MaxSessionAge:    90 * time.Minute,
+		MaxRetries:       3,
+		Backoff:          200 * time.Millisecond,
+		RequestCallLimit: 100,
+		CallTimeout:      30 * time.Second,
+		session:          &session{auth: auth, instanceURL: instanceURL},
+	}
 }
 
-func CreateClient() (*simpleforce.Client, error) {
-	sfURL := u.GetDotEnvVariable("SF_URL")
-	sfUser := u.GetDotEnvVariable("SF_USER")
-	sfPassword := u.GetDotEnvVariable("SF_PASSWORD")
-	sfToken := u.GetDotEnvVariable("SF_TOKEN")
+// ForRequest returns a copy of sf that shares its session but sends every
+// call through a client metered into usage.
+func (sf *SF) ForRequest(usage *Usage) *SF {
+	scoped := *sf
+	scoped.usage = usage
+	return &scoped
+}
 
-	client := simpleforce.NewClient(sfURL, simpleforce.DefaultClientID, simpleforce.DefaultAPIVersion)
+// Session returns the client, logging in first if there is no live session.
+func (sf *SF) Session() (*simpleforce.Client, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
 
-	if client == nil {
-		// handle the error
-		log.Println("Something went wrong with creating the client.")
-		return nil, fmt.Errorf("error creating client")
+	if !sf.loggedIn || (sf.MaxSessionAge > 0 && time.Since(sf.loggedInAt) >= sf.MaxSessionAge) {
+		if err := sf.login(); err != nil {
+			return nil, err
+		}
 	}
 
-	err := client.LoginPassword(sfUser, sfPassword, sfToken)
+	if sf.usage != nil {
+		return sf.usage.client(sf.client, sf.CallTimeout), nil
+	}
+	return sf.client, nil
+}
+
+// login must be called with sf.mu held.
+func (sf *SF) login() error {
+	if sf.auth == nil {
+		if sf.loginErr != nil {
+			return apperror.Unavailable(sf.loginErr, "Salesforce client not configured")
+		}
+		return apperror.Unavailable(nil, "Salesforce client not configured")
+	}
+
+	client := simpleforce.NewClient(sf.instanceURL, simpleforce.DefaultClientID, simpleforce.DefaultAPIVersion)
+	client.SetHttpClient(&http.Client{Timeout: sf.CallTimeout})
+	if err := sf.auth.Login(client); err != nil {
+		sf.loggedIn = false
+		log.Println("Error logging in to Salesforce: ", err)
+		return apperror.Unavailable(err, "Salesforce login failed")
+	}
 
-	// Do some other stuff with the client instance if needed.
-	return client, err
+	sf.client = client
+	sf.loggedIn = true
+	sf.loggedInAt = time.Now()
+	sf.generation++
+	return nil
 }
 
-func (sf *SF) Query(query string) (*simpleforce.QueryResult, error) {
-	result, err := sf.Client.Query(query)
-	if err != nil {
-		log.Println("Error querying Salesforce: ", err)
-		return nil, err
+// relogin replaces the session that was current at generation. Concurrent
+// callers that saw the same dead session share a single login.
+func (sf *SF) relogin(generation int) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	if sf.generation != generation && sf.loggedIn {
+		return nil
+	}
+
+	log.Println("Salesforce session expired, logging in again")
+	return sf.login()
+}
+
+func (sf *SF) currentGeneration() int {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.generation
+}
+
+// Read runs an idempotent call. It logs in again if the session was rejected
+// and retries transient failures with exponential backoff.
+func (sf *SF) Read(fn func(client *simpleforce.Client) error) error {
+	return sf.run(fn, sf.MaxRetries)
+}
+
+// Write runs a call that must not be repeated blindly. The only retry is
+// after a rejected session, since Salesforce applied nothing in that case.
+func (sf *SF) Write(fn func(client *simpleforce.Client) error) error {
+	return sf.run(fn, 0)
+}
+
+func (sf *SF) run(fn func(client *simpleforce.Client) error, retries int) error {
+	reloggedIn := false
+	delay := sf.Backoff
+
+	for attempt := 0; ; attempt++ {
+		if err := sf.usage.err(); err != nil {
+			return err
+		}
+
+		client, err := sf.Session()
+		if err != nil {
+			return err
+		}
+
+		generation := sf.currentGeneration()
+		err = fn(client)
+		if err == nil {
+			return nil
+		}
+
+		switch {
+		case sf.usage.err() != nil:
+			return sf.usage.err()
+		case IsInvalidSession(err) && !reloggedIn:
+			reloggedIn = true
+			if loginErr := sf.relogin(generation); loginErr != nil {
+				return loginErr
+			}
+		case isTransient(err) && attempt < retries:
+			log.Printf("Transient Salesforce error, retrying in %s: %v", delay, err)
+			time.Sleep(delay)
+			delay *= 2
+		default:
+			return err
+		}
 	}
+}
 
-	sf.QueryCount++
+// IsInvalidSession reports whether err is Salesforce rejecting the session.
+func IsInvalidSession(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "INVALID_SESSION_ID")
+}
 
-	return result, nil
+func isTransient(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	text := err.Error()
+	for _, code := range []string{"SERVER_UNAVAILABLE", "UNABLE_TO_LOCK_ROW", "503 Service Unavailable", "connection reset"} {
+		if strings.Contains(text, code) {
+			return true
+		}
+	}
+	return false
 }
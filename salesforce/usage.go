@@ -0,0 +1,143 @@
+package salesforce
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	simpleforce "github.com/scottraio/simpleforce"
+)
+
+var errBudgetExceeded = errors.New("salesforce call budget exceeded")
+
+// Usage counts the Salesforce API calls made on behalf of one request. Every
+// call is checked against Limit before it is sent; zero means no limit.
+type Usage struct {
+	Limit int
+
+	mu        sync.Mutex
+	calls     int
+	queries   int
+	queryMore int
+	dml       int
+	elapsed   time.Duration
+	exceeded  bool
+
+	// metered is the client on shared's session, built on first use and
+	// again only when a new login replaces shared.
+	shared  *simpleforce.Client
+	metered *simpleforce.Client
+}
+
+// UsageStats is a snapshot of a Usage.
+type UsageStats struct {
+	Calls     int
+	Queries   int
+	QueryMore int
+	DML       int
+	Elapsed   time.Duration
+	Exceeded  bool
+}
+
+func NewUsage(limit int) *Usage {
+	return &Usage{Limit: limit}
+}
+
+func (u *Usage) Stats() UsageStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return UsageStats{
+		Calls:     u.calls,
+		Queries:   u.queries,
+		QueryMore: u.queryMore,
+		DML:       u.dml,
+		Elapsed:   u.elapsed,
+		Exceeded:  u.exceeded,
+	}
+}
+
+// reserve claims a call from the budget, or reports that it is spent.
+func (u *Usage) reserve() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.Limit > 0 && u.calls >= u.Limit {
+		u.exceeded = true
+		return false
+	}
+	u.calls++
+	return true
+}
+
+func (u *Usage) record(req *http.Request, elapsed time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.elapsed += elapsed
+
+	path := req.URL.Path
+	switch {
+	case req.Method != http.MethodGet:
+		u.dml++
+	case strings.HasSuffix(path, "/query") || strings.HasSuffix(path, "/queryAll"):
+		u.queries++
+	case strings.Contains(path, "/query/") || strings.Contains(path, "/queryAll/"):
+		u.queryMore++
+	}
+}
+
+// err returns the error to report once a call was refused for going over
+// the budget, or nil.
+func (u *Usage) err() error {
+	if u == nil {
+		return nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if !u.exceeded {
+		return nil
+	}
+	return apperror.RateLimited("Request exceeded its budget of %d Salesforce calls", u.Limit)
+}
+
+// client returns a client on the same session as shared whose calls are
+// metered into u and give up after timeout. It is built once per session.
+func (u *Usage) client(shared *simpleforce.Client, timeout time.Duration) *simpleforce.Client {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.metered != nil && u.shared == shared {
+		return u.metered
+	}
+
+	client := simpleforce.NewClient(shared.GetLoc(), simpleforce.DefaultClientID, simpleforce.DefaultAPIVersion)
+	client.SetSidLoc(shared.GetSid(), shared.GetLoc())
+	client.SetHttpClient(&http.Client{
+		Transport: &meteredTransport{usage: u, next: http.DefaultTransport},
+		Timeout:   timeout,
+	})
+	u.shared, u.metered = shared, client
+	return client
+}
+
+type meteredTransport struct {
+	usage *Usage
+	next  http.RoundTripper
+}
+
+func (t *meteredTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.usage.reserve() {
+		return nil, errBudgetExceeded
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	t.usage.record(req, time.Since(start))
+	return resp, err
+}
@@ -0,0 +1,51 @@
+package salesforce
+
+import (
+	"testing"
+
+	simpleforce "github.com/scottraio/simpleforce"
+)
+
+type testLogin struct{}
+
+func (testLogin) Login(client *simpleforce.Client) error {
+	client.SetSidLoc("test-session", "https://test.my.salesforce.com")
+	return nil
+}
+
+func TestSessionReusesMeteredClient(t *testing.T) {
+	sf := New("https://test.my.salesforce.com", testLogin{})
+	scoped := sf.ForRequest(NewUsage(0))
+
+	first, err := scoped.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := scoped.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("a second call in the same request built a new client")
+	}
+
+	other, err := sf.ForRequest(NewUsage(0)).Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first {
+		t.Error("two requests share a metered client")
+	}
+
+	// A new login replaces the session, so the request's client follows it.
+	if err := sf.relogin(sf.currentGeneration()); err != nil {
+		t.Fatal(err)
+	}
+	third, err := scoped.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third == first {
+		t.Error("the metered client kept the old session")
+	}
+}
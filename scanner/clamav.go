@@ -0,0 +1,92 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/model"
+)
+
+// chunkSize is how much is sent to clamd at a time.
+const chunkSize = 64 << 10
+
+// ClamAV scans with clamd's INSTREAM command over a unix or TCP socket.
+type ClamAV struct {
+	Network string
+	Address string
+	// Timeout bounds a whole scan.
+	Timeout time.Duration
+}
+
+func (c *ClamAV) Name() string { return "clamav" }
+
+func (c *ClamAV) Scan(ctx context.Context, r io.Reader) (*model.ScanResult, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, fmt.Errorf("starting scan: %w", err)
+	}
+
+	// Each chunk goes as a 4-byte big-endian length and the bytes; a zero
+	// length ends the stream. clamd may answer early, such as when the
+	// stream passes its size limit, so a failed write falls through to
+	// reading the reply.
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, readErr := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				break
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			conn.Write([]byte{0, 0, 0, 0})
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("reading file: %w", readErr)
+		}
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return nil, fmt.Errorf("reading clamd reply: %w", err)
+	}
+	return parseReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseReply reads clamd's answer: "stream: OK", "stream: <name> FOUND",
+// or "<reason> ERROR".
+func parseReply(reply string) (*model.ScanResult, error) {
+	switch {
+	case strings.HasSuffix(reply, " OK"):
+		return &model.ScanResult{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		signature := strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")
+		return &model.ScanResult{Infected: true, Signature: signature}, nil
+	case strings.Contains(reply, "size limit exceeded"):
+		return nil, model.ErrScanSkipped
+	}
+	return nil, fmt.Errorf("clamd: %s", reply)
+}
@@ -0,0 +1,56 @@
+// Package scanner holds the malware scanners uploads are checked with.
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Proluxe/proluxe-common-api/model"
+	u "github.com/scottraio/go-utils"
+)
+
+const defaultClamAVAddress = "unix:/var/run/clamav/clamd.ctl"
+
+// FromEnv picks the scanner named by SCANNER: "clamav", reached at
+// CLAMAV_ADDRESS ("unix:<socket>" or "tcp:<host:port>"), or "none". Left
+// unset, uploads aren't scanned, as before scanning was added, and a warning
+// says so at startup.
+func FromEnv() (model.Scanner, error) {
+	switch strings.ToLower(u.GetDotEnvVariable("SCANNER")) {
+	case "clamav":
+		address := u.GetDotEnvVariable("CLAMAV_ADDRESS")
+		if address == "" {
+			address = defaultClamAVAddress
+		}
+		network, addr, ok := strings.Cut(address, ":")
+		if !ok || network != "unix" && network != "tcp" {
+			return nil, fmt.Errorf("CLAMAV_ADDRESS: want unix:<socket> or tcp:<host:port>, got %q", address)
+		}
+		return &ClamAV{Network: network, Address: addr, Timeout: 5 * time.Minute}, nil
+	case "none":
+		return Noop{}, nil
+	case "":
+		log.Println("WARNING: SCANNER is not set, so uploads are NOT scanned for malware. Set SCANNER=clamav to scan them, or SCANNER=none to silence this warning.")
+		return Noop{}, nil
+	}
+	return nil, fmt.Errorf("unknown SCANNER %q", u.GetDotEnvVariable("SCANNER"))
+}
+
+// Noop lets everything through without judging it. It is for local runs
+// and deployments that scan files some other way; files it lets through are
+// stored as unscanned, and are scanned by POST /files/scan once a real
+// scanner is set.
+type Noop struct{}
+
+func (Noop) Name() string { return "none" }
+
+func (Noop) Scan(ctx context.Context, r io.Reader) (*model.ScanResult, error) {
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return nil, err
+	}
+	return nil, model.ErrScanSkipped
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"strings"
 
 	"github.com/knocklabs/knock-go/knock"
 	u "github.com/scottraio/go-utils"
@@ -10,7 +11,9 @@ import (
 type Knock struct {
 	User       *knock.User
 	WorkFlowId string
-	Email      string
+	// Email identifies the actor. Callers without an address, such as API
+	// keys, pass their actor name instead and are identified by it alone.
+	Email string
 }
 
 func (k *Knock) client() (context.Context, *knock.Client) {
@@ -29,10 +32,14 @@ func (k *Knock) client() (context.Context, *knock.Client) {
 func (k *Knock) Identify() *knock.User {
 	ctx, client := k.client()
 
-	user, _ := client.Users.Identify(ctx, &knock.IdentifyUserRequest{
-		ID:    k.Email,
-		Email: k.Email,
-	})
+	req := &knock.IdentifyUserRequest{ID: k.Email}
+	if strings.Contains(k.Email, "@") {
+		req.Email = k.Email
+	} else {
+		req.Name = k.Email
+	}
+
+	user, _ := client.Users.Identify(ctx, req)
 
 	k.User = user
 
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestID tags every request with an ID, reusing the caller's X-Request-ID
+// when present. The ID is echoed in the response header and in error bodies.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-ID")
+		if id == "" || len(id) > 64 {
+			id = newRequestID()
+		}
+
+		c.Set("RequestID", id)
+		c.Header("X-Request-ID", id)
+
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
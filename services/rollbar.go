@@ -2,14 +2,16 @@ package services
 
 import (
 	"fmt"
-	"net/http"
 	"runtime/debug"
 
+	"github.com/Proluxe/proluxe-common-api/apperror"
 	"github.com/gin-gonic/gin"
 	"github.com/rollbar/rollbar-go"
 	u "github.com/scottraio/go-utils"
 )
 
+// ErrorHandling reports panics to Rollbar and turns them, and any error a
+// handler attached with c.Error, into the apperror envelope.
 func ErrorHandling() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
@@ -29,13 +31,18 @@ func ErrorHandling() gin.HandlerFunc {
 				rollbar.SetEnvironment("development")
 
 				rollbar.RequestErrorWithStackSkipWithExtras(rollbar.CRIT, c.Request, err, 2, map[string]interface{}{
-					"endpoint": c.Request.RequestURI,
+					"endpoint":   c.Request.RequestURI,
+					"request_id": c.GetString("RequestID"),
 				})
 
-				c.AbortWithStatus(http.StatusInternalServerError)
+				apperror.Respond(c, err)
 			}
 		}()
 
 		c.Next()
+
+		if len(c.Errors) > 0 && !c.Writer.Written() {
+			apperror.Respond(c, c.Errors.Last().Err)
+		}
 	}
 }
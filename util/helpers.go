@@ -2,41 +2,70 @@ package util
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 	"time"
 
 	"cloud.google.com/go/civil"
+	"github.com/Proluxe/proluxe-common-api/apperror"
+	"github.com/Proluxe/proluxe-common-api/model"
 	"github.com/Proluxe/proluxe-common-api/salesforce"
 	"github.com/gin-gonic/gin"
 )
 
 type App struct {
 	SF *salesforce.SF
+	model.Repositories
+	// Blobs holds the files served under /files.
+	Blobs model.BlobStore
+	// Uploads limits what can be uploaded to Blobs.
+	Uploads model.UploadPolicy
+	// TrashRetention is how long deleted files stay restorable.
+	TrashRetention time.Duration
+	// Archives caps the ZIP archives built from Blobs.
+	Archives model.ArchiveLimits
+	// Previews configures the thumbnails made of files in Blobs.
+	Previews model.PreviewSettings
+	// StaffAccess is what the staff role may do across the whole bucket
+	// besides its folder grants. Empty limits staff to their grants.
+	StaffAccess model.Permission
+	// Activity is the file audit log. Nil records nothing.
+	Activity model.ActivityLog
+
+	// RepositoriesFor rebuilds Repositories on a request-scoped SF so that
+	// their calls count against the request. Nil keeps Repositories as is.
+	RepositoriesFor func(sf *salesforce.SF) model.Repositories
 }
 
 func HandleRequest(handlerFunc func(*gin.Context, *App) interface{}, App *App) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		checkClient(App.SF, c) // Check client credentials or other preprocessing
-		App.SF.QueryCount = 0  // Resetting the count for each request
+		if !checkClient(App.SF, c) { // Check client credentials or other preprocessing
+			return
+		}
+		scoped, done := App.ForRequest(c)
+		defer done()
 
 		// Call the specific handler for this route
-		result := handlerFunc(c, App)
-
-		log.Println("Query count: ", App.SF.QueryCount)
+		result := handlerFunc(c, scoped)
 		c.JSON(http.StatusOK, result)
 	}
 }
 
 func clientErrorResponse(c *gin.Context) {
-	c.JSON(http.StatusInternalServerError, gin.H{"error": "client not initialized"})
+	apperror.Respond(c, apperror.Unavailable(nil, "Salesforce client not initialized"))
 }
 
-func checkClient(SF *salesforce.SF, c *gin.Context) {
-	if SF.Client == nil {
+// checkClient makes sure there is a live Salesforce session, logging in if
+// this is the first request or the last session has aged out.
+func checkClient(SF *salesforce.SF, c *gin.Context) bool {
+	if SF == nil {
 		clientErrorResponse(c)
-		return
+		return false
+	}
+	if _, err := SF.Session(); err != nil {
+		apperror.Respond(c, err)
+		return false
 	}
+	return true
 }
 
 func ParseDate(endDate string, c *gin.Context) {
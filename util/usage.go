@@ -0,0 +1,77 @@
+package util
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/Proluxe/proluxe-common-api/auth"
+	"github.com/Proluxe/proluxe-common-api/salesforce"
+	"github.com/gin-gonic/gin"
+)
+
+// ForRequest returns a copy of the app whose Salesforce calls are counted
+// against this request's budget. The counts go out as X-Salesforce-* response
+// headers; call the returned func when the handler is done to log them. An
+// app without Salesforce is returned as is.
+func (a *App) ForRequest(c *gin.Context) (*App, func()) {
+	if a.SF == nil {
+		return a, func() {}
+	}
+	usage := salesforce.NewUsage(a.SF.RequestCallLimit)
+
+	scoped := *a
+	scoped.SF = a.SF.ForRequest(usage)
+	if a.RepositoriesFor != nil {
+		scoped.Repositories = a.RepositoriesFor(scoped.SF)
+	}
+
+	c.Writer = &usageWriter{ResponseWriter: c.Writer, usage: usage}
+
+	return &scoped, func() { logUsage(c, usage) }
+}
+
+func logUsage(c *gin.Context, usage *salesforce.Usage) {
+	stats := usage.Stats()
+	log.Printf("request_id=%s actor=%s method=%s path=%s status=%d sf_calls=%d sf_queries=%d sf_query_more=%d sf_dml=%d sf_time_ms=%d sf_budget_exceeded=%t",
+		c.GetString("RequestID"), auth.CurrentPrincipal(c).Actor(), c.Request.Method, c.FullPath(), c.Writer.Status(),
+		stats.Calls, stats.Queries, stats.QueryMore, stats.DML, stats.Elapsed.Milliseconds(), stats.Exceeded)
+}
+
+// usageWriter adds the usage headers just before the response is written,
+// when the handler has made all the calls it needed for the body.
+type usageWriter struct {
+	gin.ResponseWriter
+	usage *salesforce.Usage
+}
+
+func (w *usageWriter) setHeaders() {
+	if w.Written() {
+		return
+	}
+
+	stats := w.usage.Stats()
+	h := w.Header()
+	h.Set("X-Salesforce-Calls", strconv.Itoa(stats.Calls))
+	h.Set("X-Salesforce-Queries", strconv.Itoa(stats.Queries))
+	h.Set("X-Salesforce-Query-More", strconv.Itoa(stats.QueryMore))
+	h.Set("X-Salesforce-DML", strconv.Itoa(stats.DML))
+	h.Set("X-Salesforce-Time-Ms", strconv.FormatInt(stats.Elapsed.Milliseconds(), 10))
+	if w.usage.Limit > 0 {
+		h.Set("X-Salesforce-Call-Limit", strconv.Itoa(w.usage.Limit))
+	}
+}
+
+func (w *usageWriter) WriteHeaderNow() {
+	w.setHeaders()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *usageWriter) Write(data []byte) (int, error) {
+	w.setHeaders()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *usageWriter) WriteString(s string) (int, error) {
+	w.setHeaders()
+	return w.ResponseWriter.WriteString(s)
+}